@@ -0,0 +1,515 @@
+// Package api serves a small local HTTP API to trigger runs on demand and
+// inspect them, e.g. for home automation to ask for a playlist of the last 3
+// hours of FIP Groove:
+//
+//	POST /runs {"station": "fipGroove", "window": "3h"}
+//	GET /runs
+//	GET /runs/{id}
+//	GET /stations
+//
+// It also serves a web UI at / to review the tracks each run matched and fix
+// them, which saves overrides used by future runs:
+//
+//	GET /search?title=...&artist=...&query=...
+//	GET /overrides
+//	POST /overrides {"title": "...", "artist": "...", "tidal_id": 42}
+//
+// POST requests must be sent as application/json, which browsers won't let
+// another site do without asking first, so that a page open in the same
+// browser can't trigger runs or save overrides.
+package api
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/fip"
+	"github.com/coaxial/tizinger/pipeline"
+	"github.com/coaxial/tizinger/tidal"
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/overrides"
+)
+
+// ui is the web UI's page, which only uses the API.
+//
+//go:embed ui.html
+var ui []byte
+
+// Runner runs job for the window from `from` up until `to`, and reports what
+// it did.
+type Runner func(job config.Job, from time.Time, to time.Time) (pipeline.Report, error)
+
+// The statuses of runs.
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Run is a run triggered through the API, as GET /runs/{id} describes it.
+type Run struct {
+	ID           string    `json:"id"`
+	Job          string    `json:"job"`
+	Station      string    `json:"station"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Destinations []string  `json:"destinations"`
+	// Status is one of StatusRunning, StatusSucceeded or StatusFailed.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Playlist is the name of the playlist created, once known.
+	Playlist string `json:"playlist,omitempty"`
+	// Tracks counts the tracks the filters let through.
+	Tracks int `json:"tracks"`
+	// Report has what each destination matched and created, by
+	// destination name.
+	Report map[string]DestinationReport `json:"report,omitempty"`
+}
+
+// DestinationReport is what a destination did during a run.
+type DestinationReport struct {
+	Matched   int `json:"matched"`
+	Unmatched int `json:"unmatched"`
+	// Playlists are the URLs of the playlists created, by account name.
+	Playlists map[string]string `json:"playlists,omitempty"`
+	// Strategies count the tracks each search strategy found, by strategy
+	// name.
+	Strategies map[string]int `json:"strategies,omitempty"`
+	Matches    []Match        `json:"matches"`
+}
+
+// Match is what a track matched on a destination.
+type Match struct {
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album,omitempty"`
+	// Found tells whether the track was found, in which case ID,
+	// MatchTitle and MatchArtist describe what it matched.
+	Found       bool   `json:"found"`
+	ID          int    `json:"id,omitempty"`
+	MatchTitle  string `json:"match_title,omitempty"`
+	MatchArtist string `json:"match_artist,omitempty"`
+	// Confidence is how similar the match is to the track, from 0 to 1.
+	Confidence float64 `json:"confidence"`
+	// Override tells whether an override decided the match rather than a
+	// search.
+	Override bool `json:"override,omitempty"`
+	// Strategy is the name of the search strategy which found the match,
+	// e.g. "full" for the title and artists or "album" for the title and
+	// album.
+	Strategy string `json:"strategy,omitempty"`
+	// Candidates are the search results the match was chosen from.
+	Candidates []Candidate `json:"candidates,omitempty"`
+}
+
+// Candidate is a track a source track could match.
+type Candidate struct {
+	ID         int     `json:"id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Album      string  `json:"album,omitempty"`
+	Confidence float64 `json:"confidence"`
+}
+
+// runRequest is what POST /runs takes. Every field is optional, except for
+// the window when not starting from a job.
+type runRequest struct {
+	// Job is the name of the job from the config file to start from,
+	// rather than from the defaults.
+	Job     string `json:"job"`
+	Station string `json:"station"`
+	// Window is how far back from now to get tracks from, e.g. "3h".
+	Window       string   `json:"window"`
+	Destinations []string `json:"destinations"`
+	Accounts     []string `json:"accounts"`
+}
+
+// jobName is the name of the runs' job when they don't start from one.
+const jobName = "api"
+
+// keptRuns is how many runs the server keeps in memory. The oldest finished
+// runs are forgotten beyond that.
+const keptRuns = 100
+
+// Server serves the API. It keeps the last runs it triggered or recorded in
+// memory.
+type Server struct {
+	jobs      []config.Job
+	run       Runner
+	overrides *overrides.Store
+	mux       *http.ServeMux
+	// now, search and keep can be overridden when testing.
+	now    func() time.Time
+	search func(source extractor.Track, query string) ([]tidal.Candidate, error)
+	keep   int
+
+	// mu guards runs and order.
+	mu   sync.Mutex
+	runs map[string]*Run
+	// order has the IDs of the runs, the oldest first.
+	order []string
+	// running tracks the runs in progress.
+	running sync.WaitGroup
+}
+
+// New returns a server triggering runs with run, which can start from jobs,
+// and saving the corrections made in the web UI to o.
+func New(jobs []config.Job, run Runner, o *overrides.Store) (s *Server) {
+	s = &Server{
+		jobs:      jobs,
+		run:       run,
+		overrides: o,
+		mux:       http.NewServeMux(),
+		now:       time.Now,
+		search:    tidal.Candidates,
+		keep:      keptRuns,
+		runs:      make(map[string]*Run),
+	}
+	s.mux.HandleFunc("GET /{$}", s.serveUI)
+	s.mux.HandleFunc("POST /runs", s.createRun)
+	s.mux.HandleFunc("GET /runs", s.listRuns)
+	s.mux.HandleFunc("GET /runs/{id}", s.getRun)
+	s.mux.HandleFunc("GET /stations", s.listStations)
+	s.mux.HandleFunc("GET /search", s.searchTracks)
+	s.mux.HandleFunc("GET /overrides", s.listOverrides)
+	s.mux.HandleFunc("POST /overrides", s.setOverride)
+	return s
+}
+
+// Record returns a function running jobs with run like the scheduler does,
+// which keeps the runs along with the ones triggered through the API so that
+// the web UI can review them too.
+func (s *Server) Record(run Runner) func(job config.Job, from time.Time, to time.Time) error {
+	return func(job config.Job, from time.Time, to time.Time) error {
+		id := s.add(job, from, to)
+		report, err := run(job, from, to)
+		s.finish(id, report, err)
+		return err
+	}
+}
+
+// Wait waits for the runs triggered through the API to finish, e.g. before
+// shutting down.
+func (s *Server) Wait() {
+	s.running.Wait()
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// createRun starts a run in the background and responds with it, along with
+// its URL in the Location header.
+func (s *Server) createRun(w http.ResponseWriter, r *http.Request) {
+	var req runRequest
+	err := decode(w, r, &req)
+	if err != nil {
+		return
+	}
+	job, err := s.job(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	to := s.now()
+	from := to.Add(-job.Window)
+	id := s.add(job, from, to)
+	logger.Info("run triggered through the API", "id", id, "job", job.Name, "station", job.Station, "window", job.Window)
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		report, err := s.run(job, from, to)
+		s.finish(id, report, err)
+	}()
+
+	w.Header().Set("Location", "/runs/"+id)
+	writeJSON(w, http.StatusAccepted, s.snapshot(id))
+}
+
+// add records a run of job from `from` up until `to` as running, and returns
+// its ID. It forgets the oldest finished runs beyond the ones to keep.
+func (s *Server) add(job config.Job, from time.Time, to time.Time) (id string) {
+	run := &Run{
+		ID:           newID(),
+		Job:          job.Name,
+		Station:      job.Station,
+		From:         from,
+		To:           to,
+		Destinations: job.Destinations,
+		Status:       StatusRunning,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[run.ID] = run
+	s.order = append(s.order, run.ID)
+	s.evict()
+	return run.ID
+}
+
+// evict forgets the oldest finished runs until no more than s.keep are left,
+// or only runs in progress. s.mu must be held.
+func (s *Server) evict() {
+	excess := len(s.order) - s.keep
+	kept := s.order[:0]
+	for _, id := range s.order {
+		if excess > 0 && s.runs[id].Status != StatusRunning {
+			delete(s.runs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
+
+// job returns the job req asks for, starting from the job it names if any.
+func (s *Server) job(req runRequest) (job config.Job, err error) {
+	job.Name = jobName
+	if req.Job != "" {
+		found := false
+		for _, j := range s.jobs {
+			if j.Name == req.Job {
+				job, found = j, true
+			}
+		}
+		if !found {
+			return job, fmt.Errorf("no such job %q", req.Job)
+		}
+	}
+	if req.Station != "" {
+		job.Source, job.Station = "fip", req.Station
+	}
+	if req.Window != "" {
+		job.Window, err = time.ParseDuration(req.Window)
+		if err != nil {
+			return job, fmt.Errorf("invalid window %q: %v", req.Window, err)
+		}
+		job.Count = 0
+	}
+	if len(req.Destinations) > 0 {
+		job.Destinations = req.Destinations
+	}
+	if len(req.Accounts) > 0 {
+		job.Accounts = req.Accounts
+	}
+	return config.Prepare(job, fip.Stations())
+}
+
+// finish records the outcome of the run id.
+func (s *Server) finish(id string, report pipeline.Report, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := s.runs[id]
+	run.Status = StatusSucceeded
+	if err != nil {
+		run.Status, run.Error = StatusFailed, err.Error()
+	}
+	run.Playlist, run.Tracks = report.Playlist, len(report.Tracks)
+	if len(report.Summaries) > 0 {
+		run.Report = make(map[string]DestinationReport)
+	}
+	for d, summary := range report.Summaries {
+		dr := DestinationReport{Matched: summary.Matched, Unmatched: summary.Unmatched, Playlists: summary.Playlists, Matches: []Match{}}
+		for _, m := range summary.Matches {
+			dr.Matches = append(dr.Matches, Match{
+				Title:       m.Track.Title,
+				Artist:      m.Track.Artist,
+				Album:       m.Track.Album,
+				Found:       m.TidalID != -1,
+				ID:          m.TidalID,
+				MatchTitle:  m.TidalTitle,
+				MatchArtist: m.TidalArtist,
+				Confidence:  m.Confidence,
+				Override:    m.Override,
+				Strategy:    m.Strategy,
+				Candidates:  candidates(m.Candidates),
+			})
+			if m.TidalID != -1 && m.Strategy != "" {
+				if dr.Strategies == nil {
+					dr.Strategies = make(map[string]int)
+				}
+				dr.Strategies[m.Strategy]++
+			}
+		}
+		run.Report[d] = dr
+	}
+	logger.Info("run finished", "id", id, "status", run.Status)
+}
+
+// candidates converts the candidates from Tidal.
+func candidates(tcs []tidal.Candidate) (cs []Candidate) {
+	for _, c := range tcs {
+		cs = append(cs, Candidate{ID: c.ID, Title: c.Title, Artist: c.Artist, Album: c.Album, Confidence: c.Confidence})
+	}
+	return cs
+}
+
+// snapshot returns a copy of the run id, which can be read while the run
+// goes on.
+func (s *Server) snapshot(id string) (run Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.runs[id]
+}
+
+// getRun responds with the run's status and its report once done.
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	_, ok := s.runs[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such run %q", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.snapshot(id))
+}
+
+// listRuns responds with every run, the most recent first. The runs'
+// reports are left out, see getRun.
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	runs := []Run{}
+	for _, run := range s.runs {
+		summary := *run
+		summary.Report = nil
+		runs = append(runs, summary)
+	}
+	s.mu.Unlock()
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].To.Equal(runs[j].To) {
+			return runs[i].To.After(runs[j].To)
+		}
+		return runs[i].ID < runs[j].ID
+	})
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// listStations responds with the FIP stations runs can get tracks from.
+func (s *Server) listStations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, fip.Stations())
+}
+
+// searchTracks searches Tidal manually, for the query or else the title and
+// artist, and responds with the results scored against the title and artist.
+func (s *Server) searchTracks(w http.ResponseWriter, r *http.Request) {
+	source := extractor.Track{Title: r.URL.Query().Get("title"), Artist: r.URL.Query().Get("artist")}
+	query := strings.TrimSpace(r.URL.Query().Get("query"))
+	if query == "" {
+		query = strings.TrimSpace(source.Title + " " + source.Artist)
+	}
+	if query == "" {
+		writeError(w, http.StatusBadRequest, errors.New("nothing to search for, set query or title"))
+		return
+	}
+	tcs, err := s.search(source, query)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("searching Tidal: %v", err))
+		return
+	}
+	cs := candidates(tcs)
+	if cs == nil {
+		cs = []Candidate{}
+	}
+	writeJSON(w, http.StatusOK, cs)
+}
+
+// listOverrides responds with the overrides, in the order they were added.
+func (s *Server) listOverrides(w http.ResponseWriter, r *http.Request) {
+	list := []overrides.Override{}
+	if s.overrides != nil {
+		list = append(list, s.overrides.List()...)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// setOverride saves an override, replacing the one for the same track if
+// any, and responds with it. It takes the source track's title and artist,
+// and either the tidal_id of the track it matches or skip to leave it out of
+// the playlists.
+func (s *Server) setOverride(w http.ResponseWriter, r *http.Request) {
+	if s.overrides == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("overrides aren't enabled"))
+		return
+	}
+	var o overrides.Override
+	err := decode(w, r, &o)
+	if err != nil {
+		return
+	}
+	err = o.Check()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	err = s.overrides.Set(o)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("saving override: %v", err))
+		return
+	}
+	logger.Info("override saved", "title", o.Title, "artist", o.Artist, "tidal_id", o.TidalID, "skip", o.Skip)
+	writeJSON(w, http.StatusCreated, o)
+}
+
+// serveUI serves the web UI.
+func (s *Server) serveUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(ui)
+}
+
+// newID returns a random ID for a run.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// decode decodes the JSON body of r in v, and responds with the error if the
+// body isn't sent as JSON or doesn't decode.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) (err error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType != "application/json" {
+		err = fmt.Errorf("unsupported content type %q", mediaType)
+	}
+	if err != nil {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("requests must be sent as application/json: %v", err))
+		return err
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+	}
+	return err
+}
+
+// writeJSON responds with v as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.Error("error writing response", "err", err)
+	}
+}
+
+// writeError responds with err as JSON.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/pipeline"
+	"github.com/coaxial/tizinger/tidal"
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/overrides"
+	"github.com/stretchr/testify/assert"
+)
+
+// request sends method to path with body on s, and decodes the response in v.
+func request(s *Server, method string, path string, body string, v interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	s.ServeHTTP(rec, req)
+	json.Unmarshal(rec.Body.Bytes(), v)
+	return rec
+}
+
+func TestCreateRun(t *testing.T) {
+	now := time.Date(2020, time.June, 24, 21, 0, 0, 0, time.UTC)
+	var ran config.Job
+	var from, to time.Time
+	run := func(job config.Job, f time.Time, t time.Time) (pipeline.Report, error) {
+		ran, from, to = job, f, t
+		return pipeline.Report{
+			Playlist: "fipGroove 2020-06-24, 2 tracks",
+			Tracks:   extractor.Tracklist{{Title: "Scar tissue"}, {Title: "Nope"}},
+			Summaries: map[string]*tidal.Summary{"tidal": {
+				Matched:   1,
+				Unmatched: 1,
+				Playlists: map[string]string{"me": "https://tidal.com/browse/playlist/mock-uuid"},
+				Matches: []tidal.Match{
+					{Track: extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}, TidalID: 42, TidalTitle: "Scar Tissue", TidalArtist: "Red Hot Chili Peppers", Confidence: 1, Strategy: "full"},
+					{Track: extractor.Track{Title: "Nope", Artist: "Nobody"}, TidalID: -1},
+				},
+			}},
+		}, nil
+	}
+	s := New(nil, run, nil)
+	s.now = func() time.Time { return now }
+
+	var created Run
+	rec := request(s, http.MethodPost, "/runs", `{"station": "fipGroove", "window": "3h", "accounts": ["me"]}`, &created)
+	s.Wait()
+
+	assert.Equal(t, http.StatusAccepted, rec.Code, "should have accepted the run")
+	assert.Equal(t, "/runs/"+created.ID, rec.Header().Get("Location"), "should tell where the run is")
+	assert.Equal(t, StatusRunning, created.Status, "should be running")
+	assert.Equal(t, "fipGroove", ran.Station, "should run for the station")
+	assert.Equal(t, []string{"tidal"}, ran.Destinations, "should default to Tidal")
+	assert.Equal(t, []string{"me"}, ran.Accounts, "should target the accounts")
+	assert.Equal(t, now.Add(-3*time.Hour), from, "should run for the window")
+	assert.Equal(t, now, to, "should run up until now")
+
+	var got Run
+	rec = request(s, http.MethodGet, "/runs/"+created.ID, "", &got)
+	assert.Equal(t, http.StatusOK, rec.Code, "should have found the run")
+	assert.Equal(t, StatusSucceeded, got.Status, "should have succeeded")
+	assert.Equal(t, 2, got.Tracks, "should count the tracks")
+	report := got.Report["tidal"]
+	assert.Equal(t, 1, report.Matched, "should report the matches")
+	assert.Equal(t, "https://tidal.com/browse/playlist/mock-uuid", report.Playlists["me"], "should report the playlists")
+	assert.Equal(t, Match{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", Found: true, ID: 42, MatchTitle: "Scar Tissue", MatchArtist: "Red Hot Chili Peppers", Confidence: 1, Strategy: "full"}, report.Matches[0], "should report what tracks matched")
+	assert.Equal(t, map[string]int{"full": 1}, report.Strategies, "should count the tracks each strategy found")
+	assert.False(t, report.Matches[1].Found, "should report the tracks not found")
+}
+
+func TestCreateRunFromJob(t *testing.T) {
+	var ran config.Job
+	run := func(job config.Job, from time.Time, to time.Time) (pipeline.Report, error) {
+		ran = job
+		return pipeline.Report{}, errors.New("mock error")
+	}
+	jobs := []config.Job{{Name: "rock-evenings", Station: "fipRock", Count: 100, Playlist: "Rock"}}
+	s := New(jobs, run, nil)
+
+	var created, got Run
+	request(s, http.MethodPost, "/runs", `{"job": "rock-evenings", "window": "1h"}`, &created)
+	s.Wait()
+	request(s, http.MethodGet, "/runs/"+created.ID, "", &got)
+
+	assert.Equal(t, "fipRock", ran.Station, "should start from the job")
+	assert.Equal(t, time.Hour, ran.Window, "should override the job")
+	assert.Equal(t, 0, ran.Count, "should use the window instead of the count")
+	assert.Equal(t, StatusFailed, got.Status, "should have failed")
+	assert.Equal(t, "mock error", got.Error, "should tell why")
+}
+
+func TestCreateRunInvalid(t *testing.T) {
+	s := New(nil, nil, nil)
+	tests := map[string]string{
+		"not JSON":        `station=fip`,
+		"unknown field":   `{"statoin": "fip"}`,
+		"unknown job":     `{"job": "nope", "window": "1h"}`,
+		"unknown station": `{"station": "fipNope", "window": "1h"}`,
+		"invalid window":  `{"window": "yesterday"}`,
+		"no window":       `{"station": "fip"}`,
+	}
+	for name, body := range tests {
+		var got map[string]string
+		rec := request(s, http.MethodPost, "/runs", body, &got)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "should refuse the request: "+name)
+		assert.NotEmpty(t, got["error"], "should tell why: "+name)
+	}
+}
+
+func TestPostNotJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-api")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := overrides.Open(filepath.Join(dir, "overrides.yaml"))
+	assert.Nil(t, err)
+	s := New(nil, nil, store)
+
+	for _, path := range []string{"/runs", "/overrides"} {
+		for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"station": "fip", "window": "1h"}`))
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			s.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code, "should refuse requests that aren't JSON: "+path+" "+contentType)
+		}
+	}
+}
+
+func TestGetRunNotFound(t *testing.T) {
+	s := New(nil, nil, nil)
+
+	var got map[string]string
+	rec := request(s, http.MethodGet, "/runs/nope", "", &got)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code, "should not have found the run")
+}
+
+func TestListStations(t *testing.T) {
+	s := New(nil, nil, nil)
+
+	var got []string
+	rec := request(s, http.MethodGet, "/stations", "", &got)
+
+	assert.Equal(t, http.StatusOK, rec.Code, "should have listed the stations")
+	assert.Contains(t, got, "fipGroove", "should list the FIP stations")
+}
+
+func TestRecord(t *testing.T) {
+	run := func(job config.Job, from time.Time, to time.Time) (pipeline.Report, error) {
+		return pipeline.Report{Playlist: job.Playlist}, nil
+	}
+	s := New(nil, nil, nil)
+	to := time.Date(2020, time.June, 24, 21, 0, 0, 0, time.UTC)
+	record := s.Record(run)
+
+	assert.Nil(t, record(config.Job{Name: "daily", Playlist: "Older"}, to.Add(-48*time.Hour), to.Add(-24*time.Hour)), "should not have errored")
+	assert.Nil(t, record(config.Job{Name: "daily", Playlist: "Newer"}, to.Add(-24*time.Hour), to), "should not have errored")
+
+	var got []Run
+	rec := request(s, http.MethodGet, "/runs", "", &got)
+	assert.Equal(t, http.StatusOK, rec.Code, "should have listed the runs")
+	assert.Len(t, got, 2, "should list the recorded runs")
+	assert.Equal(t, "Newer", got[0].Playlist, "should list the most recent run first")
+	assert.Equal(t, StatusSucceeded, got[1].Status, "should have recorded the outcome")
+}
+
+func TestRunsEviction(t *testing.T) {
+	run := func(job config.Job, from time.Time, to time.Time) (pipeline.Report, error) {
+		return pipeline.Report{Playlist: job.Playlist}, nil
+	}
+	s := New(nil, nil, nil)
+	s.keep = 2
+	to := time.Date(2020, time.June, 24, 21, 0, 0, 0, time.UTC)
+	running := s.add(config.Job{Name: "daily"}, to.Add(-96*time.Hour), to.Add(-72*time.Hour))
+	record := s.Record(run)
+	for i, name := range []string{"Oldest", "Older", "Newest"} {
+		assert.Nil(t, record(config.Job{Name: "daily", Playlist: name}, to.Add(time.Duration(i-3)*24*time.Hour), to.Add(time.Duration(i-2)*24*time.Hour)), "should not have errored")
+	}
+
+	var got []Run
+	request(s, http.MethodGet, "/runs", "", &got)
+	assert.Len(t, got, 2, "should only keep the last runs")
+	assert.Equal(t, "Newest", got[0].Playlist, "should keep the most recent runs")
+	assert.Equal(t, running, got[1].ID, "should keep the runs in progress")
+}
+
+func TestSearch(t *testing.T) {
+	s := New(nil, nil, nil)
+	var source extractor.Track
+	var query string
+	s.search = func(t extractor.Track, q string) ([]tidal.Candidate, error) {
+		source, query = t, q
+		return []tidal.Candidate{{ID: 42, Title: "Scar Tissue", Artist: "Red Hot Chili Peppers", Confidence: 1}}, nil
+	}
+
+	var got []Candidate
+	rec := request(s, http.MethodGet, "/search?title=Scar+tissue&artist=RHCP", "", &got)
+
+	assert.Equal(t, http.StatusOK, rec.Code, "should have searched")
+	assert.Equal(t, extractor.Track{Title: "Scar tissue", Artist: "RHCP"}, source, "should score the results against the track")
+	assert.Equal(t, "Scar tissue RHCP", query, "should default to searching for the title and artist")
+	assert.Equal(t, []Candidate{{ID: 42, Title: "Scar Tissue", Artist: "Red Hot Chili Peppers", Confidence: 1}}, got, "should respond with the candidates")
+
+	request(s, http.MethodGet, "/search?title=Scar+tissue&query=scar+tissue+live", "", &got)
+	assert.Equal(t, "scar tissue live", query, "should search for the query")
+
+	rec = request(s, http.MethodGet, "/search", "", &got)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "should need something to search for")
+}
+
+func TestSetOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-api")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := overrides.Open(filepath.Join(dir, "overrides.yaml"))
+	assert.Nil(t, err)
+	s := New(nil, nil, store)
+
+	var created overrides.Override
+	rec := request(s, http.MethodPost, "/overrides", `{"title": "Scar tissue", "artist": "RHCP", "tidal_id": 42}`, &created)
+	assert.Equal(t, http.StatusCreated, rec.Code, "should have saved the override")
+	rec = request(s, http.MethodPost, "/overrides", `{"title": "Jingle", "artist": "FIP", "skip": true}`, &created)
+	assert.Equal(t, http.StatusCreated, rec.Code, "should have saved the override")
+	got, ok := store.Lookup(extractor.Track{Title: "Scar tissue", Artist: "RHCP"})
+	assert.True(t, ok, "should have stored the override")
+	assert.Equal(t, 42, got.TidalID, "should match the track to the Tidal track")
+
+	var list []overrides.Override
+	request(s, http.MethodGet, "/overrides", "", &list)
+	assert.Equal(t, []overrides.Override{{Title: "Scar tissue", Artist: "RHCP", TidalID: 42}, {Title: "Jingle", Artist: "FIP", Skip: true}}, list, "should list the overrides")
+
+	var refused map[string]string
+	rec = request(s, http.MethodPost, "/overrides", `{"title": "Scar tissue", "artist": "RHCP"}`, &refused)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "should need a Tidal ID or skip")
+	assert.NotEmpty(t, refused["error"], "should tell why")
+}
+
+func TestUI(t *testing.T) {
+	s := New(nil, nil, nil)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code, "should serve the UI")
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/html", "should serve HTML")
+	assert.Contains(t, rec.Body.String(), "/overrides", "should save overrides through the API")
+}
@@ -0,0 +1,268 @@
+// Package archive persists tracklists as CSV or JSON files and reads them back,
+// so that a tracklist can be kept, edited by hand, and replayed into any
+// exporter without querying the original source again.
+package archive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/logger"
+)
+
+// Client implements the extractor.Client interface for tracklist files.
+type Client struct {
+	// Path is the CSV or JSON file to read the tracklist from.
+	Path string
+}
+
+// Playlist returns up to trackCount tracks from the file that aired up until
+// timestampFrom, which is a Unix epoch in seconds. Tracks without an air time
+// are always included. A timestampFrom or trackCount of 0 disables the
+// respective limit, so Playlist(0, 0) returns the whole file.
+func (c Client) Playlist(timestampFrom int64, trackCount int) (trackList extractor.Tracklist, err error) {
+	logger.Info("reading tracklist", "path", c.Path)
+	all, err := Read(c.Path)
+	if err != nil {
+		return trackList, err
+	}
+
+	for _, t := range all {
+		if trackCount > 0 && len(trackList) >= trackCount {
+			break
+		}
+		if timestampFrom > 0 && t.StartTime > timestampFrom {
+			continue
+		}
+		trackList = append(trackList, t)
+	}
+	logger.Info("read tracks", "tracks", len(trackList), "path", c.Path)
+	return trackList, err
+}
+
+// Between returns the tracks from the file which started airing from `from`
+// up until `to`, both Unix epochs in seconds. Tracks without an air time are
+// left out.
+func (c Client) Between(from int64, to int64) (trackList extractor.Tracklist, err error) {
+	logger.Info("reading tracklist", "path", c.Path)
+	all, err := Read(c.Path)
+	if err != nil {
+		return trackList, err
+	}
+
+	for _, t := range all {
+		if t.StartTime >= from && t.StartTime < to {
+			trackList = append(trackList, t)
+		}
+	}
+	logger.Info("read tracks", "tracks", len(trackList), "from", from, "to", to, "path", c.Path)
+	return trackList, err
+}
+
+// Read loads every track from the file at path. The format is picked from the
+// file extension.
+func Read(path string) (tracks extractor.Tracklist, err error) {
+	format, err := formatOf(path)
+	if err != nil {
+		return tracks, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Error("could not read tracklist", "path", path, "err", err)
+		return tracks, err
+	}
+
+	var records []record
+	switch format {
+	case "csv":
+		records, err = decodeCSV(content)
+	case "json":
+		err = json.Unmarshal(content, &records)
+	}
+	if err != nil {
+		logger.Error("could not parse tracklist", "path", path, "err", err)
+		return tracks, err
+	}
+
+	for i, r := range records {
+		t, err := r.track()
+		if err != nil {
+			logger.Error("invalid track", "track", i+1, "path", path, "err", err)
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, err
+}
+
+// Write saves tracks to the file at path, overwriting it. The format is picked
+// from the file extension. tidalIDs holds the Tidal ID matched for each track,
+// in the same order as tracks; it can be nil, and IDs of -1 or 0 are left
+// empty.
+func Write(path string, tracks extractor.Tracklist, tidalIDs []int) (err error) {
+	format, err := formatOf(path)
+	if err != nil {
+		return err
+	}
+	if tidalIDs != nil && len(tidalIDs) != len(tracks) {
+		err = fmt.Errorf("got %d Tidal IDs for %d tracks", len(tidalIDs), len(tracks))
+		logger.Error("could not write tracklist", "err", err)
+		return err
+	}
+
+	records := make([]record, len(tracks))
+	for i, t := range tracks {
+		records[i] = newRecord(t)
+		if tidalIDs != nil && tidalIDs[i] > 0 {
+			records[i].TidalID = tidalIDs[i]
+		}
+	}
+
+	var content []byte
+	switch format {
+	case "csv":
+		content, err = encodeCSV(records)
+	case "json":
+		content, err = json.MarshalIndent(records, "", "  ")
+	}
+	if err != nil {
+		logger.Error("could not encode tracklist", "err", err)
+		return err
+	}
+
+	logger.Info("writing tracks", "tracks", len(records), "path", path)
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		logger.Error("could not write tracklist", "path", path, "err", err)
+	}
+	return err
+}
+
+// formatOf returns "csv" or "json" depending on the path's extension.
+func formatOf(path string) (format string, err error) {
+	format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if format != "csv" && format != "json" {
+		err = fmt.Errorf("unsupported tracklist format %q for %q, use .csv or .json", format, path)
+		logger.Error("unsupported tracklist format", "path", path)
+		return "", err
+	}
+	return format, err
+}
+
+// newRecord converts a track to its file representation.
+func newRecord(t extractor.Track) record {
+	return record{
+		Title:     t.Title,
+		Artist:    t.Artist,
+		Album:     t.Album,
+		StartTime: formatTime(t.StartTime),
+		EndTime:   formatTime(t.EndTime),
+		TidalID:   t.TidalID,
+	}
+}
+
+// track converts a record back to a track.
+func (r record) track() (t extractor.Track, err error) {
+	t = extractor.Track{Title: r.Title, Artist: r.Artist, Album: r.Album, TidalID: r.TidalID}
+	t.StartTime, err = parseTime(r.StartTime)
+	if err != nil {
+		return t, err
+	}
+	t.EndTime, err = parseTime(r.EndTime)
+	return t, err
+}
+
+// formatTime turns an epoch into an RFC3339 UTC timestamp, or an empty string
+// for 0.
+func formatTime(epoch int64) string {
+	if epoch == 0 {
+		return ""
+	}
+	return time.Unix(epoch, 0).UTC().Format(time.RFC3339)
+}
+
+// parseTime turns an RFC3339 timestamp back into an epoch, or 0 for an empty
+// string.
+func parseTime(ts string) (epoch int64, err error) {
+	if ts == "" {
+		return 0, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Unix(), nil
+}
+
+// encodeCSV writes the records as CSV, header first.
+func encodeCSV(records []record) (content []byte, err error) {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	rows := [][]string{csvHeader}
+	for _, r := range records {
+		ID := ""
+		if r.TidalID != 0 {
+			ID = strconv.Itoa(r.TidalID)
+		}
+		rows = append(rows, []string{r.Title, r.Artist, r.Album, r.StartTime, r.EndTime, ID})
+	}
+	err = w.WriteAll(rows)
+	return []byte(b.String()), err
+}
+
+// decodeCSV reads CSV records. The header line is required so that columns
+// can be reordered, or dropped, when editing the file by hand.
+func decodeCSV(content []byte) (records []record, err error) {
+	reader := csv.NewReader(strings.NewReader(string(content)))
+	// Hand-edited files may have rows with trailing columns left out.
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return records, err
+	}
+	if len(rows) == 0 {
+		return records, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	for _, name := range []string{"title", "artist"} {
+		if _, ok := columns[name]; !ok {
+			return records, fmt.Errorf("missing %q column in CSV header", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+
+	for _, row := range rows[1:] {
+		r := record{
+			Title:     field(row, "title"),
+			Artist:    field(row, "artist"),
+			Album:     field(row, "album"),
+			StartTime: field(row, "start_time"),
+			EndTime:   field(row, "end_time"),
+		}
+		if ID := field(row, "tidal_id"); ID != "" {
+			r.TidalID, err = strconv.Atoi(ID)
+			if err != nil {
+				return records, fmt.Errorf("invalid tidal_id %q: %v", ID, err)
+			}
+		}
+		records = append(records, r)
+	}
+	return records, err
+}
@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/stretchr/testify/assert"
+)
+
+var fixtureTracks = extractor.Tracklist{
+	{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", Album: "Greatest hits", StartTime: 1592891806, EndTime: 1592892022, TidalID: 132616868},
+	{Title: "Off the wall", Artist: "Jil Is Lucky", Album: "Off the wall", StartTime: 1592891600, EndTime: 1592891811},
+	{Title: "Sambarilove (feat. Roubinho Jacobina)", Artist: "Chiara Civello", Album: "Eclipse"},
+}
+
+func TestRead(t *testing.T) {
+	for _, path := range []string{
+		"../fixtures/archive/tracklist.csv",
+		"../fixtures/archive/tracklist.json",
+	} {
+		got, err := Read(path)
+
+		assert.Nil(t, err, "should not have errored")
+		assert.Equal(t, fixtureTracks, got, "should have read the tracks from "+path)
+	}
+}
+
+func TestReadUnsupportedFormat(t *testing.T) {
+	got, err := Read("../fixtures/credentials/mock-credentials.yaml")
+
+	assert.Error(t, err, "should have errored")
+	assert.Nil(t, got, "should not have returned tracks")
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-archive")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"tracks.csv", "tracks.json"} {
+		path := filepath.Join(dir, name)
+
+		err := Write(path, fixtureTracks, []int{132616868, -1, 0})
+		assert.Nil(t, err, "should not have errored")
+		got, err := Read(path)
+
+		assert.Nil(t, err, "should not have errored")
+		assert.Equal(t, fixtureTracks, got, "should read back what was written to "+name)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-archive")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tracks.csv")
+	want := "title,artist,album,start_time,end_time,tidal_id\n" +
+		"Scar tissue,Red Hot Chili Peppers,Greatest hits,2020-06-23T05:56:46Z,2020-06-23T06:00:22Z,132616868\n" +
+		"Off the wall,Jil Is Lucky,Off the wall,2020-06-23T05:53:20Z,2020-06-23T05:56:51Z,\n" +
+		"Sambarilove (feat. Roubinho Jacobina),Chiara Civello,Eclipse,,,\n"
+
+	err = Write(path, fixtureTracks, []int{132616868, -1, -1})
+	assert.Nil(t, err, "should not have errored")
+	got, err := ioutil.ReadFile(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, want, string(got), "should have written the header and rows")
+}
+
+func TestWriteIDsMismatch(t *testing.T) {
+	err := Write("tracks.csv", fixtureTracks, []int{1})
+
+	assert.Error(t, err, "should have errored")
+}
+
+func TestPlaylist(t *testing.T) {
+	tests := []struct {
+		from  int64
+		count int
+		want  extractor.Tracklist
+		msg   string
+	}{
+		{0, 0, fixtureTracks, "should return every track"},
+		{0, 2, fixtureTracks[:2], "should return at most count tracks"},
+		{1592891700, 0, fixtureTracks[1:], "should skip tracks aired after from"},
+	}
+	c := Client{Path: "../fixtures/archive/tracklist.json"}
+
+	for _, test := range tests {
+		got, err := c.Playlist(test.from, test.count)
+
+		assert.Nil(t, err, "should not have errored")
+		assert.Equal(t, test.want, got, test.msg)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	c := Client{Path: "../fixtures/archive/tracklist.csv"}
+
+	got, err := c.Between(1592891600, 1592891806)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, fixtureTracks[1:2], got, "should only return the tracks aired within the window")
+}
@@ -0,0 +1,18 @@
+package archive
+
+// record is a single track as it is stored in a tracklist file. Times are
+// RFC3339 strings rather than epochs so that the files stay readable when
+// opened in a spreadsheet, and empty when unknown.
+type record struct {
+	Title     string `json:"title"`
+	Artist    string `json:"artist"`
+	Album     string `json:"album"`
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	// TidalID is 0 when the track wasn't matched on Tidal.
+	TidalID int `json:"tidal_id,omitempty"`
+}
+
+// csvHeader is the first line of a CSV tracklist, in the order the columns
+// are written and expected when reading.
+var csvHeader = []string{"title", "artist", "album", "start_time", "end_time", "tidal_id"}
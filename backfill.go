@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coaxial/tizinger/backfill"
+	"github.com/coaxial/tizinger/pipeline"
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/overrides"
+	"github.com/coaxial/tizinger/utils/state"
+)
+
+// runBackfill creates a playlist per day or week between two dates for each
+// station.
+func runBackfill(args []string) (exitCode int) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	fromDate := flags.String("from", "", "first day to create playlists for, as YYYY-MM-DD")
+	toDate := flags.String("to", "", "last day to create playlists for, as YYYY-MM-DD")
+	period := flags.String("per", "day", "create a playlist per day or per week")
+	stationList := flags.String("stations", "", "comma-separated stations, defaults to the stations of the jobs in -config")
+	destinations := flags.String("destinations", "tidal", "comma-separated destinations to create the playlists on")
+	concurrency := flags.Int("concurrency", 4, "maximum number of playlists to fetch tracks for at once")
+	configPath := flags.String("config", "config.yaml", "file defining the jobs whose stations to backfill")
+	checkpointPath := flags.String("checkpoint", "", "file recording the playlists already done, to resume from (default from -config)")
+	statePath := flags.String("state", "", "file recording the progress of each playlist (default from -config)")
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath, false)
+	if err != nil {
+		return 1
+	}
+	if *checkpointPath == "" {
+		*checkpointPath = cfg.Settings.CheckpointFile
+	}
+	if *statePath == "" {
+		*statePath = cfg.Settings.StateFile
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", *fromDate, time.Local)
+	if err != nil {
+		logger.Error("invalid -from date", "date", *fromDate, "err", err)
+		return 1
+	}
+	to, err := time.ParseInLocation("2006-01-02", *toDate, time.Local)
+	if err != nil {
+		logger.Error("invalid -to date", "date", *toDate, "err", err)
+		return 1
+	}
+
+	stations := splitList(*stationList)
+	if len(stations) == 0 {
+		stations = configuredStations(cfg)
+	}
+	tasks, err := backfill.Plan(stations, from, to, *period, splitList(*destinations))
+	if err != nil {
+		logger.Error("error planning backfill", "err", err)
+		return 1
+	}
+	checkpoint, err := state.Open(*checkpointPath)
+	if err != nil {
+		logger.Error("error loading checkpoint", "err", err)
+		return 1
+	}
+	store, err := state.Open(*statePath)
+	if err != nil {
+		logger.Error("error loading state", "err", err)
+		return 1
+	}
+	pipeline.UseState(store)
+	corrections, err := overrides.Open(cfg.Settings.OverridesFile)
+	if err != nil {
+		logger.Error("error loading overrides", "err", err)
+		return 1
+	}
+	pipeline.UseOverrides(corrections)
+	_, err = loadISRC(cfg)
+	if err != nil {
+		return 1
+	}
+
+	// Let the tasks in progress finish, and the checkpoint be saved,
+	// before exiting on SIGINT or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logger.Info("backfilling", "playlists", len(tasks), "stations", stations)
+	failed := backfill.Run(ctx, tasks, *concurrency, checkpoint, pipeline.Run)
+	if failed > 0 {
+		logger.Error("done backfilling, some playlists failed", "failed", failed, "playlists", len(tasks))
+		return 1
+	}
+	logger.Info("done backfilling")
+	return 0
+}
+
+// configuredStations returns the stations of the FIP jobs in cfg, or just FIP
+// if there are none.
+func configuredStations(cfg config.Config) (stations []string) {
+	seen := make(map[string]bool)
+	for _, j := range cfg.Jobs {
+		if j.Source == "fip" && !seen[j.Station] {
+			seen[j.Station] = true
+			stations = append(stations, j.Station)
+		}
+	}
+	if len(stations) == 0 {
+		logger.Warning("no jobs to get stations from, backfilling fip only")
+		stations = []string{"fip"}
+	}
+	return stations
+}
+
+// splitList splits a comma-separated list, ignoring empty elements.
+func splitList(list string) (elements []string) {
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elements = append(elements, e)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,122 @@
+// Package backfill creates the playlists for past days or weeks in bulk,
+// keeping track of the ones done so that an interrupted backfill can resume
+// where it left off.
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coaxial/tizinger/scheduler"
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/state"
+)
+
+// Task is a playlist to create: the tracks a station aired during a window.
+type Task struct {
+	Job  config.Job
+	From time.Time
+	To   time.Time
+}
+
+// key identifies the task in the checkpoint file.
+func (t Task) key() string {
+	return fmt.Sprintf("%s/%s/%s", t.Job.Station, t.From.Format(time.RFC3339), t.To.Format(time.RFC3339))
+}
+
+// periods maps the supported periods to their length in days and the
+// playlist naming template for that period.
+var periods = map[string]struct {
+	days     int
+	playlist string
+}{
+	"day":  {1, `FIP {{.Station}} {{.From.Format "2006-01-02"}}`},
+	"week": {7, `FIP {{.Station}} week of {{.From.Format "2006-01-02"}}`},
+}
+
+// Plan returns a task per station and per period from the day `from` up to
+// and including the day `to`. period is "day" or "week", the last week is
+// shortened if needed so that it doesn't go past `to`. The playlists names
+// don't include the number of tracks so that existing playlists can be
+// recognized before the tracks are fetched.
+func Plan(stations []string, from time.Time, to time.Time, period string, destinations []string) (tasks []Task, err error) {
+	p, ok := periods[period]
+	if !ok {
+		return tasks, fmt.Errorf("unknown period %q, use day or week", period)
+	}
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, to.Location()).AddDate(0, 0, 1)
+	if !start.Before(end) {
+		return tasks, fmt.Errorf("%s is after %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+
+	for _, station := range stations {
+		job := config.Job{
+			Name:         "backfill-" + station,
+			Source:       "fip",
+			Station:      station,
+			Destinations: destinations,
+			Playlist:     p.playlist,
+			SkipExisting: true,
+		}
+		for t := start; t.Before(end); t = t.AddDate(0, 0, p.days) {
+			windowEnd := t.AddDate(0, 0, p.days)
+			if windowEnd.After(end) {
+				windowEnd = end
+			}
+			tasks = append(tasks, Task{Job: job, From: t, To: windowEnd})
+		}
+	}
+	return tasks, err
+}
+
+// Run runs the tasks with at most concurrency of them at once, skipping the
+// ones the checkpoint store has as done and marking the successful ones as
+// such. It stops starting new tasks once ctx is cancelled, and returns the
+// number of tasks which failed.
+func Run(ctx context.Context, tasks []Task, concurrency int, checkpoint *state.Store, run scheduler.Runner) (failed int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	slots := make(chan bool, concurrency)
+
+	for i, t := range tasks {
+		if checkpoint.IsDone(t.key()) {
+			logger.Info("skipping task, already done", "task", i+1, "tasks", len(tasks), "key", t.key())
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			logger.Warning("backfill interrupted", "not_started", len(tasks)-i)
+			wg.Wait()
+			return failed
+		case slots <- true:
+		}
+
+		wg.Add(1)
+		go func(i int, t Task) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			logger.Info("starting task", "task", i+1, "tasks", len(tasks), "key", t.key())
+			err := run(t.Job, t.From, t.To)
+			if err == nil {
+				err = checkpoint.MarkDone(t.key())
+			}
+			if err != nil {
+				logger.Error("task failed", "task", i+1, "tasks", len(tasks), "key", t.key(), "err", err)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				return
+			}
+			logger.Info("done with task", "task", i+1, "tasks", len(tasks), "key", t.key())
+		}(i, t)
+	}
+	wg.Wait()
+	return failed
+}
@@ -0,0 +1,89 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	from := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, time.June, 10, 0, 0, 0, 0, time.UTC)
+
+	days, err := Plan([]string{"fip", "fipRock"}, from, to, "day", []string{"tidal"})
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, 20, len(days), "should plan a task per station and day")
+	assert.Equal(t, "fipRock", days[10].Job.Station, "should plan the second station after the first")
+	assert.Equal(t, time.Date(2020, time.June, 11, 0, 0, 0, 0, time.UTC), days[19].To, "should include the last day")
+
+	name, err := days[0].Job.PlaylistName(config.NameData{Station: "fip", From: days[0].From})
+	assert.Nil(t, err)
+	assert.Equal(t, "FIP fip 2020-06-01", name, "should name playlists after the day")
+
+	weeks, err := Plan([]string{"fip"}, from, to, "week", []string{"tidal"})
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, 2, len(weeks), "should plan a task per week")
+	assert.Equal(t, time.Date(2020, time.June, 11, 0, 0, 0, 0, time.UTC), weeks[1].To, "should shorten the last week")
+}
+
+func TestPlanInvalid(t *testing.T) {
+	from := time.Date(2020, time.June, 10, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := Plan([]string{"fip"}, from, to, "day", nil)
+	assert.Error(t, err, "should error when from is after to")
+
+	_, err = Plan([]string{"fip"}, to, from, "month", nil)
+	assert.Error(t, err, "should error on unknown periods")
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-backfill")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	checkpoint, err := state.Open(filepath.Join(dir, "checkpoint.json"))
+	assert.Nil(t, err)
+	from := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, time.June, 6, 0, 0, 0, 0, time.UTC)
+	tasks, err := Plan([]string{"fip"}, from, to, "day", []string{"tidal"})
+	assert.Nil(t, err)
+	checkpoint.MarkDone(tasks[0].key())
+
+	var mu sync.Mutex
+	var ran []time.Time
+	running, maxRunning := 0, 0
+	run := func(job config.Job, from time.Time, to time.Time) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		running--
+		if from.Day() == 3 {
+			return errors.New("mock error")
+		}
+		ran = append(ran, from)
+		return nil
+	}
+
+	failed := Run(context.Background(), tasks, 2, checkpoint, run)
+
+	assert.Equal(t, 1, failed, "should have counted the failed task")
+	assert.Equal(t, 4, len(ran), "should have skipped the task already done")
+	assert.True(t, maxRunning <= 2, "should not have run more than 2 tasks at once")
+	assert.True(t, checkpoint.IsDone(tasks[1].key()), "should have checkpointed successful tasks")
+	assert.False(t, checkpoint.IsDone(tasks[2].key()), "should not have checkpointed the failed task")
+}
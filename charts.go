@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/coaxial/tizinger/charts"
+	"github.com/coaxial/tizinger/fip"
+	"github.com/coaxial/tizinger/isrc"
+	"github.com/coaxial/tizinger/pipeline"
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/overrides"
+	"github.com/coaxial/tizinger/utils/state"
+)
+
+// runCharts ranks what a station aired over a week, month or year, and
+// optionally exports its top tracks as a playlist.
+func runCharts(args []string) (exitCode int) {
+	flags := flag.NewFlagSet("charts", flag.ExitOnError)
+	station := flags.String("station", "fip", "FIP station to rank the tracks of")
+	period := flags.String("period", "month", "rank the tracks aired over a week, month or year")
+	date := flags.String("date", "", "day in the period to rank, as YYYY-MM-DD (default the last complete period)")
+	by := flags.String("by", "artist,track", "comma-separated charts: artist, track, album, label or genre")
+	top := flags.Int("top", 50, "number of rows per chart, and of tracks in the exported playlist")
+	format := flags.String("format", "text", "output format: text, csv or json")
+	output := flags.String("output", "", "file to write the charts to (default stdout)")
+	destinations := flags.String("export", "", "comma-separated destinations to create a playlist of the top tracks on, e.g. tidal")
+	configPath := flags.String("config", "config.yaml", "file with the settings, optional")
+	statePath := flags.String("state", "", "file recording the progress of each playlist (default from -config)")
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath, false)
+	if err != nil {
+		return 1
+	}
+	if *statePath == "" {
+		*statePath = cfg.Settings.StateFile
+	}
+
+	day := time.Now()
+	if *date != "" {
+		day, err = time.ParseInLocation("2006-01-02", *date, time.Local)
+		if err != nil {
+			logger.Error("invalid -date", "date", *date, "err", err)
+			return 1
+		}
+	}
+	err = charts.Check(splitList(*by), *format)
+	if err != nil {
+		logger.Error("invalid charts", "err", err)
+		return 1
+	}
+	from, to, label, err := charts.Window(*period, day)
+	if err != nil {
+		logger.Error("invalid -period", "err", err)
+		return 1
+	}
+	if *date == "" {
+		// The current period isn't over, rank the one before.
+		from, to, label, _ = charts.Window(*period, from.AddDate(0, 0, -1))
+	}
+
+	tracks, err := fip.APIClient{Station: *station}.Between(from.Unix(), to.Unix())
+	if err != nil {
+		logger.Error("error getting tracks", "err", err)
+		return 1
+	}
+	var ranked []charts.Chart
+	for _, kind := range splitList(*by) {
+		c, _ := charts.Rank(tracks, kind)
+		ranked = append(ranked, c.Top(*top))
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		w, err = os.Create(*output)
+		if err != nil {
+			logger.Error("error creating output file", "path", *output, "err", err)
+			return 1
+		}
+		defer w.Close()
+	}
+	err = charts.Write(w, ranked, *format)
+	if err != nil {
+		logger.Error("error writing charts", "err", err)
+		return 1
+	}
+
+	if *destinations == "" {
+		return 0
+	}
+	store, err := state.Open(*statePath)
+	if err != nil {
+		logger.Error("error loading state", "err", err)
+		return 1
+	}
+	pipeline.UseState(store)
+	corrections, err := overrides.Open(cfg.Settings.OverridesFile)
+	if err != nil {
+		logger.Error("error loading overrides", "err", err)
+		return 1
+	}
+	pipeline.UseOverrides(corrections)
+	resolver, err := loadISRC(cfg)
+	if err != nil {
+		return 1
+	}
+
+	c, _ := charts.Rank(tracks, "track")
+	list := c.Top(*top).Tracks()
+	if resolver != nil {
+		list = isrc.Enrich(resolver, list)
+	}
+	job := config.Job{Name: "charts", Source: "fip", Station: *station, Destinations: splitList(*destinations)}
+	name := fmt.Sprintf("FIP %s top %d, %s", *station, len(list), label)
+	_, err = pipeline.Export(job, name, list)
+	if err != nil {
+		logger.Error("error exporting the top tracks", "playlist", name, "err", err)
+		return 1
+	}
+	logger.Info("exported the top tracks", "playlist", name, "tracks", len(list))
+	return 0
+}
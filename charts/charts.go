@@ -0,0 +1,244 @@
+// Package charts ranks what a station aired over a period, such as its most
+// aired artists or tracks of the month.
+package charts
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/normalize"
+)
+
+// Kinds are what tracks can be ranked by.
+var Kinds = []string{"artist", "track", "album", "label", "genre"}
+
+// Formats are the formats charts can be written in.
+var Formats = []string{"text", "csv", "json"}
+
+// Chart ranks the artists, tracks, albums, labels or genres aired by how many
+// times they were.
+type Chart struct {
+	// By is what is ranked, one of Kinds.
+	By   string `json:"by"`
+	Rows []Row  `json:"rows"`
+}
+
+// Row is an entry of a chart.
+type Row struct {
+	// Rank is the row's position, rows aired as many times share it.
+	Rank int    `json:"rank"`
+	Name string `json:"name"`
+	// Artist is the artist of tracks and albums, empty for the other
+	// kinds.
+	Artist string `json:"artist,omitempty"`
+	Plays  int    `json:"plays"`
+	// Track is the latest airing of a track, for exporting the tracks
+	// chart as a playlist.
+	Track extractor.Track `json:"-"`
+}
+
+// entry is an artist, track, album, label or genre as aired by a track,
+// before being counted.
+type entry struct {
+	key    string
+	name   string
+	artist string
+}
+
+// entries returns what t counts as a play for when ranking by kind.
+func entries(t extractor.Track, by string) (es []entry) {
+	credited := normalize.Featured(t.Artist)
+	artist := ""
+	if len(credited) > 0 {
+		artist = normalize.Key(credited[0])
+	}
+	switch by {
+	case "artist":
+		// Every artist credited gets a play. Only featured artists are
+		// told apart, ampersands or commas being part of band names as
+		// often as not, e.g. "Earth, Wind & Fire".
+		for _, a := range credited {
+			es = append(es, entry{key: normalize.Key(a), name: a})
+		}
+	case "track":
+		title, _ := normalize.Title(t.Title)
+		es = append(es, entry{key: normalize.Key(title) + "\x00" + artist, name: t.Title, artist: t.Artist})
+	case "album":
+		es = append(es, entry{key: normalize.Key(t.Album) + "\x00" + artist, name: t.Album, artist: t.Artist})
+	case "label":
+		es = append(es, entry{key: normalize.Key(t.Label), name: t.Label})
+	case "genre":
+		es = append(es, entry{key: normalize.Key(t.MusicalKind), name: t.MusicalKind})
+	}
+	return es
+}
+
+// Check returns an error if any of kinds isn't one of Kinds, or format one of
+// Formats, so that mistakes are caught before fetching the tracks.
+func Check(kinds []string, format string) (err error) {
+	for _, k := range kinds {
+		if !contains(Kinds, k) {
+			return fmt.Errorf("unknown chart %q, valid charts are %v", k, Kinds)
+		}
+	}
+	if !contains(Formats, format) {
+		return fmt.Errorf("unknown format %q, valid formats are %v", format, Formats)
+	}
+	return err
+}
+
+// Rank returns the chart of tracks by kind, one of Kinds. Names are compared
+// once normalized, and shown as they were spelt by the latest airing, tracks
+// being most recent first. Tracks missing what is ranked are left out.
+func Rank(tracks extractor.Tracklist, by string) (c Chart, err error) {
+	if !contains(Kinds, by) {
+		return c, fmt.Errorf("unknown chart %q, valid charts are %v", by, Kinds)
+	}
+	c.By = by
+	index := make(map[string]int)
+	for _, t := range tracks {
+		for _, e := range entries(t, by) {
+			if e.key == "" || e.key[0] == 0 {
+				continue
+			}
+			i, ok := index[e.key]
+			if !ok {
+				i = len(c.Rows)
+				index[e.key] = i
+				c.Rows = append(c.Rows, Row{Name: e.name, Artist: e.artist, Track: t})
+			}
+			c.Rows[i].Plays++
+		}
+	}
+	sort.SliceStable(c.Rows, func(i, j int) bool {
+		if c.Rows[i].Plays != c.Rows[j].Plays {
+			return c.Rows[i].Plays > c.Rows[j].Plays
+		}
+		return normalize.Key(c.Rows[i].Name) < normalize.Key(c.Rows[j].Name)
+	})
+	for i := range c.Rows {
+		c.Rows[i].Rank = i + 1
+		if i > 0 && c.Rows[i].Plays == c.Rows[i-1].Plays {
+			c.Rows[i].Rank = c.Rows[i-1].Rank
+		}
+	}
+	return c, err
+}
+
+// Top returns the chart with its first n rows only.
+func (c Chart) Top(n int) Chart {
+	if n >= 0 && n < len(c.Rows) {
+		c.Rows = c.Rows[:n]
+	}
+	return c
+}
+
+// Tracks returns the latest airing of each row's track, in the chart's order.
+func (c Chart) Tracks() (tracks extractor.Tracklist) {
+	for _, r := range c.Rows {
+		tracks = append(tracks, r.Track)
+	}
+	return tracks
+}
+
+// periods maps the supported periods to how their windows are labelled.
+var periods = map[string]string{
+	"week":  "week of 2006-01-02",
+	"month": "2006-01",
+	"year":  "2006",
+}
+
+// Window returns the calendar week, starting on Monday, month or year that t
+// is in, along with its label for naming playlists, e.g. "2020-06" for June
+// 2020.
+func Window(period string, t time.Time) (from time.Time, to time.Time, label string, err error) {
+	layout, ok := periods[period]
+	if !ok {
+		return from, to, label, fmt.Errorf("unknown period %q, use week, month or year", period)
+	}
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case "week":
+		from = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		to = from.AddDate(0, 0, 7)
+	case "month":
+		from = day.AddDate(0, 0, 1-day.Day())
+		to = from.AddDate(0, 1, 0)
+	case "year":
+		from = time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+		to = from.AddDate(1, 0, 0)
+	}
+	return from, to, from.Format(layout), err
+}
+
+// Write writes the charts to w in format, one of Formats.
+func Write(w io.Writer, charts []Chart, format string) (err error) {
+	switch format {
+	case "text":
+		return writeText(w, charts)
+	case "csv":
+		return writeCSV(w, charts)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(charts)
+	}
+	return fmt.Errorf("unknown format %q, valid formats are %v", format, Formats)
+}
+
+// writeText writes the charts as aligned tables, one after the other. Only
+// the tracks and albums charts have an artist column.
+func writeText(w io.Writer, charts []Chart) (err error) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, c := range charts {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		withArtist := c.By == "track" || c.By == "album"
+		fmt.Fprintf(tw, "Top %ss\n", c.By)
+		if withArtist {
+			fmt.Fprintln(tw, "#\tPlays\tName\tArtist")
+		} else {
+			fmt.Fprintln(tw, "#\tPlays\tName")
+		}
+		for _, r := range c.Rows {
+			if withArtist {
+				fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", r.Rank, r.Plays, r.Name, r.Artist)
+			} else {
+				fmt.Fprintf(tw, "%d\t%d\t%s\n", r.Rank, r.Plays, r.Name)
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+// writeCSV writes the charts' rows as a single CSV table, telling which chart
+// each row is from in the first column.
+func writeCSV(w io.Writer, charts []Chart) (err error) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"chart", "rank", "name", "artist", "plays"})
+	for _, c := range charts {
+		for _, r := range c.Rows {
+			cw.Write([]string{c.By, strconv.Itoa(r.Rank), r.Name, r.Artist, strconv.Itoa(r.Plays)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// contains returns whether list has s.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
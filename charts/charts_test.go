@@ -0,0 +1,197 @@
+package charts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// tracks are aired most recent first, as the extractors return them.
+var tracks = extractor.Tracklist{
+	{Title: "Scar Tissue (Live)", Artist: "Red Hot Chili Peppers", Album: "Live in Hyde Park", Label: "WARNER", MusicalKind: "Rock"},
+	{Title: "Un homme heureux", Artist: "Brigitte Fontaine avec Areski", Album: "Vous et nous", Label: "SARAVAH", MusicalKind: "Variété francophone"},
+	{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", Album: "Californication", Label: "Warner", MusicalKind: "Rock"},
+	{Title: "Otherside", Artist: "Red Hot Chili Peppers", Album: "Californication", Label: "WARNER", MusicalKind: "Rock"},
+	{Title: "Le bonheur", Artist: "Brigitte Fontaine", Album: "Vous et nous", MusicalKind: "Variété francophone"},
+	{Title: "Jingle", Artist: "FIP"},
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		by   string
+		want []Row
+		msg  string
+	}{
+		{"artist", []Row{
+			{Rank: 1, Name: "Red Hot Chili Peppers", Plays: 3},
+			{Rank: 2, Name: "Brigitte Fontaine", Plays: 2},
+			{Rank: 3, Name: "Areski", Plays: 1},
+			{Rank: 3, Name: "FIP", Plays: 1},
+		}, "should give a play to every artist credited, and share ranks"},
+		{"track", []Row{
+			{Rank: 1, Name: "Scar Tissue (Live)", Artist: "Red Hot Chili Peppers", Plays: 2, Track: tracks[0]},
+			{Rank: 2, Name: "Jingle", Artist: "FIP", Plays: 1, Track: tracks[5]},
+			{Rank: 2, Name: "Le bonheur", Artist: "Brigitte Fontaine", Plays: 1, Track: tracks[4]},
+			{Rank: 2, Name: "Otherside", Artist: "Red Hot Chili Peppers", Plays: 1, Track: tracks[3]},
+			{Rank: 2, Name: "Un homme heureux", Artist: "Brigitte Fontaine avec Areski", Plays: 1, Track: tracks[1]},
+		}, "should count every version of a track together, named after the latest airing"},
+		{"album", []Row{
+			{Rank: 1, Name: "Californication", Artist: "Red Hot Chili Peppers", Plays: 2, Track: tracks[2]},
+			{Rank: 1, Name: "Vous et nous", Artist: "Brigitte Fontaine avec Areski", Plays: 2, Track: tracks[1]},
+			{Rank: 3, Name: "Live in Hyde Park", Artist: "Red Hot Chili Peppers", Plays: 1, Track: tracks[0]},
+		}, "should leave out tracks without an album"},
+		{"label", []Row{
+			{Rank: 1, Name: "WARNER", Plays: 3, Track: tracks[0]},
+			{Rank: 2, Name: "SARAVAH", Plays: 1, Track: tracks[1]},
+		}, "should ignore case"},
+		{"genre", []Row{
+			{Rank: 1, Name: "Rock", Plays: 3, Track: tracks[0]},
+			{Rank: 2, Name: "Variété francophone", Plays: 2, Track: tracks[1]},
+		}, "should rank the musical kinds"},
+	}
+	for _, tc := range tests {
+		got, err := Rank(tracks, tc.by)
+		assert.Nil(t, err, tc.msg)
+		assert.Equal(t, tc.by, got.By, tc.msg)
+		if tc.by == "artist" {
+			// Which of the artist's tracks is kept doesn't matter.
+			for i := range got.Rows {
+				got.Rows[i].Track = extractor.Track{}
+			}
+		}
+		assert.Equal(t, tc.want, got.Rows, tc.msg)
+	}
+
+	_, err := Rank(tracks, "colour")
+	assert.Error(t, err, "should error on unknown charts")
+}
+
+func TestRankBands(t *testing.T) {
+	bands := extractor.Tracklist{
+		{Title: "September", Artist: "Earth, Wind & Fire"},
+		{Title: "The Cave", Artist: "Mumford & Sons"},
+		{Title: "There Will Be Time", Artist: "Mumford & Sons feat. Baaba Maal"},
+		{Title: "Boogie Wonderland", Artist: "Earth, Wind & Fire"},
+	}
+
+	got, err := Rank(bands, "artist")
+	assert.Nil(t, err)
+	for i := range got.Rows {
+		got.Rows[i].Track = extractor.Track{}
+	}
+
+	assert.Equal(t, []Row{
+		{Rank: 1, Name: "Earth, Wind & Fire", Plays: 2},
+		{Rank: 1, Name: "Mumford & Sons", Plays: 2},
+		{Rank: 3, Name: "Baaba Maal", Plays: 1},
+	}, got.Rows, "should keep band names whole and only split featured artists")
+}
+
+func TestTop(t *testing.T) {
+	c, _ := Rank(tracks, "track")
+
+	top := c.Top(2)
+
+	assert.Len(t, top.Rows, 2, "should keep the first rows")
+	assert.Equal(t, extractor.Tracklist{tracks[0], tracks[5]}, top.Tracks(), "should return the rows' tracks in order")
+	assert.Len(t, c.Top(50).Rows, 5, "should keep short charts whole")
+}
+
+func TestWindow(t *testing.T) {
+	day := time.Date(2020, time.June, 24, 13, 37, 0, 0, time.UTC)
+	tests := []struct {
+		period string
+		from   time.Time
+		to     time.Time
+		label  string
+	}{
+		{"week", time.Date(2020, time.June, 22, 0, 0, 0, 0, time.UTC), time.Date(2020, time.June, 29, 0, 0, 0, 0, time.UTC), "week of 2020-06-22"},
+		{"month", time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, time.July, 1, 0, 0, 0, 0, time.UTC), "2020-06"},
+		{"year", time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC), "2020"},
+	}
+	for _, tc := range tests {
+		from, to, label, err := Window(tc.period, day)
+		assert.Nil(t, err, "should not have errored")
+		assert.Equal(t, tc.from, from, "should start the %s's window on its first day", tc.period)
+		assert.Equal(t, tc.to, to, "should end the %s's window on the next one's first day", tc.period)
+		assert.Equal(t, tc.label, label, "should label the %s", tc.period)
+	}
+
+	from, _, _, _ := Window("week", time.Date(2020, time.June, 28, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2020, time.June, 22, 0, 0, 0, 0, time.UTC), from, "should start weeks on Mondays")
+
+	_, _, _, err := Window("decade", day)
+	assert.Error(t, err, "should error on unknown periods")
+}
+
+func TestWrite(t *testing.T) {
+	c := []Chart{{By: "track", Rows: []Row{
+		{Rank: 1, Name: "Scar tissue", Artist: "Red Hot Chili Peppers", Plays: 2},
+		{Rank: 2, Name: "Un petit poisson, un petit oiseau", Artist: "Juliette Greco", Plays: 1},
+	}}, {By: "label", Rows: []Row{{Rank: 1, Name: "WARNER", Plays: 2}}}}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"text", "Top tracks\n" +
+			"#  Plays  Name                               Artist\n" +
+			"1  2      Scar tissue                        Red Hot Chili Peppers\n" +
+			"2  1      Un petit poisson, un petit oiseau  Juliette Greco\n" +
+			"\n" +
+			"Top labels\n" +
+			"#  Plays  Name\n" +
+			"1  2      WARNER\n"},
+		{"csv", "chart,rank,name,artist,plays\n" +
+			"track,1,Scar tissue,Red Hot Chili Peppers,2\n" +
+			"track,2,\"Un petit poisson, un petit oiseau\",Juliette Greco,1\n" +
+			"label,1,WARNER,,2\n"},
+		{"json", `[
+  {
+    "by": "track",
+    "rows": [
+      {
+        "rank": 1,
+        "name": "Scar tissue",
+        "artist": "Red Hot Chili Peppers",
+        "plays": 2
+      },
+      {
+        "rank": 2,
+        "name": "Un petit poisson, un petit oiseau",
+        "artist": "Juliette Greco",
+        "plays": 1
+      }
+    ]
+  },
+  {
+    "by": "label",
+    "rows": [
+      {
+        "rank": 1,
+        "name": "WARNER",
+        "plays": 2
+      }
+    ]
+  }
+]
+`},
+	}
+	for _, tc := range tests {
+		var got bytes.Buffer
+		err := Write(&got, c, tc.format)
+		assert.Nil(t, err, "should not have errored")
+		assert.Equal(t, tc.want, got.String(), "should have written the charts as %s", tc.format)
+	}
+
+	err := Write(&bytes.Buffer{}, c, "xml")
+	assert.Error(t, err, "should error on unknown formats")
+}
+
+func TestCheck(t *testing.T) {
+	assert.Nil(t, Check([]string{"artist", "genre"}, "csv"), "should accept known charts and formats")
+	assert.Error(t, Check([]string{"artist", "colour"}, "csv"), "should catch unknown charts")
+	assert.Error(t, Check([]string{"artist"}, "xml"), "should catch unknown formats")
+}
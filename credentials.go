@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/coaxial/tizinger/utils/credentials"
+	"github.com/coaxial/tizinger/utils/logger"
+)
+
+// runCredentials encrypts, decrypts or edits the credentials file.
+func runCredentials(args []string) (exitCode int) {
+	flags := flag.NewFlagSet("credentials", flag.ExitOnError)
+	path := flags.String("file", "credentials.yaml", "credentials file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: tizinger credentials [-file credentials.yaml] encrypt|decrypt|edit")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var err error
+	switch flags.Arg(0) {
+	case "encrypt":
+		err = encryptCredentials(*path)
+	case "decrypt":
+		err = decryptCredentials(*path)
+	case "edit":
+		err = editCredentials(*path)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		logger.Error("credentials command failed", "path", *path, "err", err)
+		return 1
+	}
+	return 0
+}
+
+// encryptCredentials encrypts the credentials file at path in place.
+func encryptCredentials(path string) (err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if credentials.IsEncrypted(content) {
+		return errors.New("already encrypted")
+	}
+	err = credentials.Check(content)
+	if err != nil {
+		return err
+	}
+	passphrase, err := credentials.Passphrase(true)
+	if err != nil {
+		return err
+	}
+	encrypted, err := credentials.Encrypt(content, passphrase)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(path, encrypted)
+	if err == nil {
+		logger.Info("encrypted credentials", "path", path)
+	}
+	return err
+}
+
+// decryptCredentials decrypts the credentials file at path in place.
+func decryptCredentials(path string) (err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	passphrase, err := credentials.Passphrase(false)
+	if err != nil {
+		return err
+	}
+	plaintext, err := credentials.Decrypt(content, passphrase)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(path, plaintext)
+	if err == nil {
+		logger.Info("decrypted credentials", "path", path)
+	}
+	return err
+}
+
+// editCredentials decrypts the credentials file at path to a temporary file,
+// opens it in $EDITOR and encrypts it back with the same passphrase once the
+// editor exits. The file is left untouched if the edited credentials are
+// invalid.
+func editCredentials(path string) (err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	passphrase, err := credentials.Passphrase(false)
+	if err != nil {
+		return err
+	}
+	plaintext, err := credentials.Decrypt(content, passphrase)
+	if err != nil {
+		return err
+	}
+
+	// TempFile creates the file readable by its owner only.
+	tmp, err := ioutil.TempFile("", "tizinger-credentials-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(plaintext)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", tmp.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("editor failed: %v", err)
+	}
+
+	edited, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	err = credentials.Check(edited)
+	if err != nil {
+		return fmt.Errorf("edited credentials are invalid, left %q unchanged: %v", path, err)
+	}
+	encrypted, err := credentials.Encrypt(edited, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, encrypted)
+}
+
+// writeFileAtomic replaces the file at path with content, readable by its
+// owner only.
+func writeFileAtomic(path string, content []byte) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
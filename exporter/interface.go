@@ -4,5 +4,5 @@ import "github.com/coaxial/tizinger/extractor"
 
 // Client defines the interface for an exporter.
 type Client interface {
-	CreatePlaylist(name string, tracks []extractor.Tracklist) (ok bool, err error)
+	CreatePlaylist(name string, tracks extractor.Tracklist) (err error)
 }
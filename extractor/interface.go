@@ -5,4 +5,5 @@ package extractor
 // playlist data that can be further parsed by Tizinger.
 type Client interface {
 	Playlist(timestampFrom int64, tracksCount int) (Tracklist, error)
+	Between(from int64, to int64) (Tracklist, error)
 }
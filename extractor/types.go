@@ -5,6 +5,27 @@ type Track struct {
 	Title  string
 	Artist string
 	Album  string
+	// StartTime and EndTime are the Unix epochs in seconds when the track
+	// started and stopped airing. They are 0 when unknown.
+	StartTime int64
+	EndTime   int64
+	// UUID is the source's identifier for the track, e.g. FIP's uuid. It is
+	// empty when unknown.
+	UUID string
+	// ISRC identifies the recording, see the isrc package. It is empty
+	// when unknown.
+	ISRC string
+	// MusicalKind is the source's genre for the track, e.g. FIP's "Jazz".
+	// It is empty when unknown.
+	MusicalKind string
+	// Year is when the track was released. It is 0 when unknown.
+	Year int
+	// Label is the record label which released the track. It is empty
+	// when unknown.
+	Label string
+	// TidalID is the Tidal track the track is known to match, e.g. from a
+	// hand-edited tracklist file. It is 0 when unknown.
+	TidalID int
 }
 
 // Tracklist is the list of tracks played
@@ -8,36 +8,122 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coaxial/tizinger/extractor"
 	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/metrics"
 )
 
 // APIClient implements the extractor.Client interface for fip.fr
-type APIClient struct{}
+type APIClient struct {
+	// Station is the name of the FIP station to get tracks from, as listed
+	// in stationIDs. It defaults to "fip" (FIP Paris) when empty.
+	Station string
+}
 
 // endpointURL is the URL where the API endpoint is located. It can be
 // overridden when testing to serve canned responses instead.
 var endpointURL = "https://www.fip.fr/latest/api/graphql"
 
+// stationIDs maps the FIP stations' names to their ID in the API.
+var stationIDs = map[string]int{
+	"fip":            7,
+	"fipRock":        64,
+	"fipJazz":        65,
+	"fipGroove":      66,
+	"fipPop":         78,
+	"fipElectro":     74,
+	"fipMonde":       69,
+	"fipReggae":      71,
+	"fipToutNouveau": 70,
+}
+
+// maxCount is the maximum number of tracks the API will return in one
+// request.
+const maxCount = 100 // tracks
+
+// Stations returns the names of the FIP stations tracks can be fetched from,
+// sorted alphabetically.
+func Stations() (names []string) {
+	for name := range stationIDs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Playlist returns the playlist history from `timestampFrom`, which is a Unix
 // epoch in seconds. trackCount is the number of tracks to fetch. There seems
 // to be around 320 tracks played per 24h.
-func (fip APIClient) Playlist(timestampFrom int64, trackCount int) (trackList []extractor.Track, err error) {
-	logger.Info.Printf("asking FIP for %d tracks since %d", trackCount, timestampFrom)
-	trackList, _, err = appendTracks(timestampFrom, trackCount, trackList)
+func (fip APIClient) Playlist(timestampFrom int64, trackCount int) (trackList extractor.Tracklist, err error) {
+	station, err := fip.stationID()
+	if err != nil {
+		return trackList, err
+	}
+	logger.Info("asking FIP for tracks", "station", fip.Station, "count", trackCount, "since", timestampFrom)
+	trackList, _, err = appendTracks(station, timestampFrom, trackCount, trackList)
+	return trackList, err
+}
+
+// Between returns the tracks which started airing from `from` up until `to`,
+// both Unix epochs in seconds, most recent first.
+func (fip APIClient) Between(from int64, to int64) (trackList extractor.Tracklist, err error) {
+	station, err := fip.stationID()
+	if err != nil {
+		return trackList, err
+	}
+	logger.Info("asking FIP for the tracks aired in a window", "station", fip.Station, "from", from, "to", to)
+
+	// The API walks back in time from the cursor, so keep fetching chunks
+	// until the cursor goes past the window's start.
+	cursor := to
+	for cursor > from {
+		chunk, last, err := getTracks(station, cursor, maxCount)
+		if err != nil {
+			logger.Error("error fetching tracks", "station", fip.Station, "err", err)
+			return nil, err
+		}
+		for _, t := range chunk {
+			if t.StartTime >= from && t.StartTime < to {
+				trackList = append(trackList, t)
+			}
+		}
+		// Guard against looping forever if the API doesn't move back.
+		if last >= cursor {
+			break
+		}
+		cursor = last
+	}
+	logger.Info("received tracks", "station", fip.Station, "tracks", len(trackList), "from", from, "to", to)
 	return trackList, err
 }
 
+// stationID looks up the API ID for the client's station.
+func (fip APIClient) stationID() (ID int, err error) {
+	name := fip.Station
+	if name == "" {
+		name = "fip"
+	}
+	ID, ok := stationIDs[name]
+	if !ok {
+		err = fmt.Errorf("unknown FIP station %q, valid stations are %v", name, Stations())
+		logger.Error("unknown FIP station", "station", name)
+		return ID, err
+	}
+	return ID, err
+}
+
 // getTracks prepares, sends, and parses the request to the API. It returns the
 // `count` number of tracks played up until `ts` along with the `last`
 // timestamp of the last track in the list. `last` is required when splitting
 // requests, so that we're not requesting the same `count` tracks over and over
 // again but rather moving back in time.
-func getTracks(ts int64, count int) (tracks extractor.Tracklist, last int64, err error) {
-	req, err := buildRequest(ts, count)
+func getTracks(station int, ts int64, count int) (tracks extractor.Tracklist, last int64, err error) {
+	req, err := buildRequest(station, ts, count)
 	if err != nil {
 		return tracks, last, err
 	}
@@ -67,6 +153,8 @@ func getTracks(ts int64, count int) (tracks extractor.Tracklist, last int64, err
 // will only process requests for 100 tracks maximum, it is necessary to make
 // more than one request when requesting more.
 func appendTracks(
+	// station is the API ID of the station to fetch tracks from.
+	station int,
 	// ts is the timestamp to fetch backwards from.
 	ts int64,
 	// count is the numbers of tracks to fetch.
@@ -87,46 +175,44 @@ func appendTracks(
 	// total wanted number of tracks since we haven't done anything yet.
 	// Declaring remaining here makes it in scope for the if base case too.
 	remaining := count
-	// maxCount is the maximum number of tracks the API will return in one
-	// request.
-	const maxCount = 100 // tracks
 
 	// This is the base case.
 	if count <= maxCount {
-		logger.Info.Printf("requesting %d tracks or less, doing it in one call", maxCount)
-		chunk, last, err := getTracks(ts, count)
+		logger.Info("requesting few enough tracks to do it in one call", "count", count, "max", maxCount)
+		chunk, last, err := getTracks(station, ts, count)
 		if err != nil {
-			logger.Error.Printf("error fetching tracks: %v", err)
+			logger.Error("error fetching tracks", "station_id", station, "err", err)
 			return allChunks, last, err
 		}
 		remaining -= len(chunk)
-		logger.Info.Printf("received %d tracks after requesting %d, %d more to get", len(chunk), count, remaining)
+		logger.Info("received tracks", "tracks", len(chunk), "requested", count, "remaining", remaining)
 		allChunks = append(prevChunk, chunk...)
 
 		return allChunks, last, err
 	}
-	logger.Info.Printf("requesting over %d tracks, splitting calls", maxCount)
-	chunk, last, err := getTracks(ts, maxCount)
+	logger.Info("requesting too many tracks for one call, splitting calls", "count", count, "max", maxCount)
+	chunk, last, err := getTracks(station, ts, maxCount)
 	if err != nil {
-		logger.Error.Printf("error fetching tracks: %v", err)
+		logger.Error("error fetching tracks", "station_id", station, "err", err)
 		return allChunks, last, err
 	}
 	// The API sometimes returns less tracks than requested for some
 	// reason.
 	remaining -= len(chunk)
-	logger.Info.Printf("received %d tracks after requesting %d, %d more to get", len(chunk), maxCount, remaining)
+	logger.Info("received tracks", "tracks", len(chunk), "requested", maxCount, "remaining", remaining)
 	// We need all the tracks we already got from previous requests, plus
 	// the tracks we just got.
 	allChunks = append(prevChunk, chunk...)
 
-	logger.Info.Printf("now getting remaining tracks")
+	logger.Info("now getting remaining tracks")
 	// Do it all again for the remaining tracks.
-	return appendTracks(last, remaining, allChunks)
+	return appendTracks(station, last, remaining, allChunks)
 }
 
-// buildRequest assembles the query string and headers. from is the timestamp
-// from which to start looking back, first is how many tracks are requested.
-func buildRequest(from int64, first int) (*http.Request, error) {
+// buildRequest assembles the query string and headers. station is the
+// station's API ID, from is the timestamp from which to start looking back,
+// first is how many tracks are requested.
+func buildRequest(station int, from int64, first int) (*http.Request, error) {
 	// FIP uses graphql to serve its tracks history. To get the history for
 	// any given date and time, issue a GET to
 	// www.fip.fr/latest/api/graphql with a query string containing the
@@ -147,32 +233,16 @@ func buildRequest(from int64, first int) (*http.Request, error) {
 	// played since `after` timestamp
 
 	timestamp := base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(from, 10)))
-	var stationIDs = map[string]int{
-		"fip":            7,
-		"fipRock":        64,
-		"fipJazz":        65,
-		"fipGroove":      66,
-		"fipPop":         78,
-		"fipElectro":     74,
-		"fipMonde":       69,
-		"fipReggae":      71,
-		"fipToutNouveau": 70,
-	}
-	station := stationIDs["fip"]
-
-	logger.Info.Printf(
-		"preparing to fetch playlist history (last %d tracks) "+
-			"from timestamp %d (%s) for station ID %d (FIP Paris)",
-		first,
-		from,
-		time.Unix(from, 0),
-		station,
+	logger.Info(
+		"preparing to fetch playlist history",
+		"tracks", first,
+		"from", time.Unix(from, 0),
+		"station_id", station,
 	)
 
 	req, err := http.NewRequest("GET", endpointURL, nil)
 	if err != nil {
-		errMsg := fmt.Sprintf("error while building new request: %v", err)
-		logger.Error.Println(errMsg)
+		logger.Error("error while building new request", "err", err)
 		return nil, err
 	}
 	query := req.URL.Query()
@@ -195,31 +265,35 @@ func buildRequest(from int64, first int) (*http.Request, error) {
 	return req, nil
 }
 
+// timeout bounds how long requests to the API can take, 0 means no timeout.
+var timeout time.Duration
+
+// SetTimeout bounds how long requests to the API can take, 0 means no
+// timeout.
+func SetTimeout(d time.Duration) {
+	timeout = d
+}
+
+// buildClient returns the client making requests to the API, recording them
+// in the metrics.
 func buildClient() *http.Client {
-	client := &http.Client{}
+	client := &http.Client{Timeout: timeout, Transport: metrics.Transport("fip", nil)}
 	return client
 }
 
 // makeRequest sends the request to the API
 func makeRequest(req *http.Request, client *http.Client) (*http.Response, error) {
-	logger.Info.Printf("initiating GET %s", req.URL)
+	logger.Info("initiating request", "method", req.Method, "url", req.URL)
 	response, err := client.Do(req)
 	if err != nil {
-		errMsg := fmt.Sprintf(
-			"error while performing GET %s: %v",
-			req.URL,
-			err,
-		)
-		logger.Error.Print(errMsg)
+		logger.Error("error while performing request", "method", req.Method, "url", req.URL, "err", err)
 		return nil, err
 	}
 
-	logger.Info.Print(
-		fmt.Sprintf(
-			"received response %q, %d bytes",
-			response.Header.Get("content-type"),
-			response.ContentLength,
-		),
+	logger.Info(
+		"received response",
+		"content_type", response.Header.Get("content-type"),
+		"bytes", response.ContentLength,
 	)
 
 	return response, nil
@@ -230,8 +304,7 @@ func unmarshalResponse(response *http.Response) (history historyResponse, err er
 	responseData, err := ioutil.ReadAll(response.Body)
 	defer response.Body.Close()
 	if err != nil {
-		errMsg := fmt.Sprintf("error while reading response data: %v", err)
-		logger.Error.Print(errMsg)
+		logger.Error("error while reading response data", "err", err)
 		return history, err
 	}
 	// A response that isn't HTTP 200 OK will still make err nil, so a
@@ -242,7 +315,7 @@ func unmarshalResponse(response *http.Response) (history historyResponse, err er
 			response.StatusCode,
 			string(responseData),
 		)
-		logger.Error.Printf(errMsg)
+		logger.Error("request failed", "status", response.StatusCode, "body", logger.JSON(responseData))
 		return history, errors.New(errMsg)
 	}
 
@@ -250,7 +323,7 @@ func unmarshalResponse(response *http.Response) (history historyResponse, err er
 	// the fields we want.
 	err = json.Unmarshal(responseData, &history)
 	if err != nil {
-		logger.Error.Printf("error unmarshalling history response: %v", err)
+		logger.Error("error unmarshalling history response", "err", err)
 		return history, err
 	}
 	return history, err
@@ -261,16 +334,23 @@ func unmarshalResponse(response *http.Response) (history historyResponse, err er
 func buildTracklist(JSON historyResponse) (trackList []extractor.Track, err error) {
 	for _, v := range JSON.Data.TimelineCursor.Edges {
 		var track = extractor.Track{
-			Title:  v.Node.Title,
-			Artist: v.Node.Artist,
-			Album:  v.Node.Album,
+			Title:     v.Node.Title,
+			Artist:    v.Node.Artist,
+			Album:     v.Node.Album,
+			StartTime: int64(v.Node.StartTime),
+			EndTime:   int64(v.Node.EndTime),
+			UUID:      v.Node.UUID,
+			// FIP pads the musical kinds with a space.
+			MusicalKind: strings.TrimSpace(v.Node.MusicalKind),
+			Year:        v.Node.Year,
+			Label:       v.Node.Label,
 		}
 		trackList = append(trackList, track)
 	}
 
 	if len(trackList) == 0 {
 		errMsg := fmt.Sprintf("empty playlist. Unmarshalled reponse: %v", JSON)
-		logger.Error.Print(errMsg)
+		logger.Error("empty playlist", "response", fmt.Sprintf("%v", JSON))
 		return trackList, errors.New(errMsg)
 	}
 	return trackList, err
@@ -279,13 +359,13 @@ func buildTracklist(JSON historyResponse) (trackList []extractor.Track, err erro
 // extractEndCursor returns the timestamp for the last received track.
 func extractEndCursor(JSON *historyResponse) (timestamp int64, err error) {
 	ec := JSON.Data.TimelineCursor.PageInfo.EndCursor
-	logger.Trace.Printf("converting %q to int64 timestamp", ec)
+	logger.Trace("converting end cursor to timestamp", "end_cursor", ec)
 	endCursorByte, err := base64.StdEncoding.DecodeString(ec)
 	timestamp, err = strconv.ParseInt(string(endCursorByte), 0, 64)
 	if err != nil {
-		logger.Error.Printf("error decoding endCursor %q to timestamp: %v", ec, err)
+		logger.Error("error decoding end cursor to timestamp", "end_cursor", ec, "err", err)
 		return timestamp, err
 	}
-	logger.Trace.Printf("converted to %d", timestamp)
+	logger.Trace("converted end cursor", "timestamp", timestamp)
 	return timestamp, err
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"testing"
 	"time"
 
@@ -46,17 +47,17 @@ func TestPlaylist(t *testing.T) {
 	defer server.Close()
 	SetEndpointURL(server.URL)
 	defer ResetEndpointURL()
-	expected := []extractor.Track{
-		{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", Album: "Greatest hits"},
-		{Title: "Off the wall", Artist: "Jil Is Lucky", Album: "Off the wall"},
-		{Title: "Kalimba (Flute mix)", Artist: "Freakniks", Album: "Electro tunes"},
-		{Title: "Tsukikaage no rendezvous", Artist: "Keiko Mari", Album: "Nippon girls: Japanese pop, beat & bossa nova 1966-1970"},
-		{Title: "Un petit poisson, un petit oiseau", Artist: "Juliette Greco", Album: "Déshabillez-moi 1965-1969"},
-		{Title: "I want to be happy", Artist: "Ray Brown", Album: "Brown Ray trio / Some of my best friends are guitarists"},
-		{Title: "I'm so happy I can't stop crying", Artist: "Sting", Album: "Mercury falling"},
-		{Title: "Sambarilove (feat. Roubinho Jacobina)", Artist: "Chiara Civello", Album: "Eclipse"},
-		{Title: "Retiens l'été", Artist: "Double Francoise", Album: "Les bijoux"},
-		{Title: "Serenade nº13 en Sol Maj K 525 \"\"une petite musique de nuit\"\" : I. Allegro", Artist: "I Musici", Album: "Mozart, pachelbel, albinoni"},
+	expected := extractor.Tracklist{
+		{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", Album: "Greatest hits", StartTime: 1592891806, EndTime: 1592892022, UUID: "31883778-0704-4914-8d14-2df7af12814b", MusicalKind: "Pop / pop rock", Year: 1999, Label: "WARNER"},
+		{Title: "Off the wall", Artist: "Jil Is Lucky", Album: "Off the wall", StartTime: 1592891600, EndTime: 1592891811, UUID: "0863cd87-f4c8-4728-8e55-6b182a26de27", MusicalKind: "Folk / Folk rock", Year: 2020, Label: "VS COM"},
+		{Title: "Kalimba (Flute mix)", Artist: "Freakniks", Album: "Electro tunes", StartTime: 1592891486, EndTime: 1592891797, UUID: "7e629eb4-eab2-4f17-9fe9-5ce550181c81", MusicalKind: "Musique du monde", Year: 1986, Label: "WAGRAM"},
+		{Title: "Tsukikaage no rendezvous", Artist: "Keiko Mari", Album: "Nippon girls: Japanese pop, beat & bossa nova 1966-1970", StartTime: 1592891308, EndTime: 1592891487, UUID: "8d093dfa-4cfe-4423-8815-421c2fc9e684", MusicalKind: "Variété", Year: 1969, Label: "BIGBEAT"},
+		{Title: "Un petit poisson, un petit oiseau", Artist: "Juliette Greco", Album: "Déshabillez-moi 1965-1969", StartTime: 1592891208, EndTime: 1592891310, UUID: "41a71070-7979-49db-ae04-923cad07be2a", Year: 1966, Label: "PODIS"},
+		{Title: "I want to be happy", Artist: "Ray Brown", Album: "Brown Ray trio / Some of my best friends are guitarists", StartTime: 1592891000, EndTime: 1592891210, UUID: "cad6695b-08ae-45b8-b53d-8e3271f7bfbe", MusicalKind: "Jazz", Year: 2000, Label: "TELARC"},
+		{Title: "I'm so happy I can't stop crying", Artist: "Sting", Album: "Mercury falling", StartTime: 1592890765, EndTime: 1592891000, UUID: "197857e4-9d50-4120-9492-2d1c017eab25", MusicalKind: "Rock", Year: 1996, Label: "A & M"},
+		{Title: "Sambarilove (feat. Roubinho Jacobina)", Artist: "Chiara Civello", Album: "Eclipse", StartTime: 1592890581, EndTime: 1592890766, UUID: "bfa81f2c-9308-4c13-ad28-88f694113cda", MusicalKind: "Variété internationale", Year: 2018, Label: "!K7 / KWAIDAN RECORDS"},
+		{Title: "Retiens l'été", Artist: "Double Francoise", Album: "Les bijoux", StartTime: 1592890417, EndTime: 1592890590, UUID: "1d6edeeb-2ca9-4fa7-ab15-f8fbf0e7d8d1", MusicalKind: "Variété francophone", Year: 2020, Label: "FREAKSVILLE MUSIC"},
+		{Title: "Serenade nº13 en Sol Maj K 525 \"\"une petite musique de nuit\"\" : I. Allegro", Artist: "I Musici", Album: "Mozart, pachelbel, albinoni", StartTime: 1592890074, EndTime: 1592890417, UUID: "2615736f-0098-4ba0-a2e3-b229e749e288", MusicalKind: "Musique symphonique", Label: "PHILIPS"},
 	}
 
 	ts := time.Date(2019, time.July, 5, 0, 0, 0, 0, time.UTC).Unix()
@@ -93,8 +94,20 @@ func ExampleAPIClient_Playlist() {
 		log.Fatalf("Could not fetch FIP tracks: %v", err)
 	}
 
-	fmt.Println(tracks)
-	// Output: [{Riding the sun Howls Howls} {In the wake of adversity Dead Can Dance Within the realm of a dying sun} {Madame rêve Alain Bashung Osez Josephine} {The Planets op 32 : 3. Mercury, the Winged Messenger Orchestre Symphonique De Chicago Gustav Holst : Les Planètes} {Annie : The hard-knock life Alicia Morton BOF TV / Annie} {Bruce Lee Catastrophe Bruce Lee} {New comer 1 Walt Rockman Dusty fingers} {Cars Gary Numan The pleasure principle / Warriors} {Radio #1 Air 10000 hz legend} {Previsão do tempo Marcos Valle Previsao do tempo}]
+	for _, t := range tracks {
+		fmt.Printf("%s - %s\n", t.Artist, t.Title)
+	}
+	// Output:
+	// Howls - Riding the sun
+	// Dead Can Dance - In the wake of adversity
+	// Alain Bashung - Madame rêve
+	// Orchestre Symphonique De Chicago - The Planets op 32 : 3. Mercury, the Winged Messenger
+	// Alicia Morton - Annie : The hard-knock life
+	// Catastrophe - Bruce Lee
+	// Walt Rockman - New comer 1
+	// Gary Numan - Cars
+	// Air - Radio #1
+	// Marcos Valle - Previsão do tempo
 
 }
 
@@ -120,7 +133,7 @@ func TestPlaylist200(t *testing.T) {
 		} else {
 			fixture = "../fixtures/fip/history_100tracks_part2.json"
 		}
-		logger.Trace.Printf("using fixture %q", fixture)
+		logger.Trace("using fixture", "path", fixture)
 		length, historyJSON := mocks.LoadFixture(fixture)
 		resp.WriteHeader(http.StatusOK)
 		resp.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -139,3 +152,43 @@ func TestPlaylist200(t *testing.T) {
 	assert.Equal(t, "Scar tissue", actual[0].Title, "should match the first track from the first response part")
 	assert.Equal(t, "Belleville", actual[100].Title, "should match the first track from the second response part")
 }
+
+func TestBetween(t *testing.T) {
+	var requested []string
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		requested = append(requested, req.URL.Query().Get("variables"))
+		resp.WriteHeader(http.StatusOK)
+		resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+		length, historyJSON := mocks.LoadFixture("../fixtures/fip/history_response.json")
+		resp.Header().Set("Content-Length", strconv.Itoa(length))
+		resp.Write(historyJSON)
+	}
+	server := mocks.Server(http.HandlerFunc(handler))
+	defer server.Close()
+	SetEndpointURL(server.URL)
+	defer ResetEndpointURL()
+	fipRock := APIClient{Station: "fipRock"}
+
+	actual, err := fipRock.Between(1592890600, 1592891700)
+
+	assert.Nil(t, err, "should not error")
+	assert.Equal(t, 6, len(actual), "should only return the tracks aired within the window")
+	assert.Equal(t, "Off the wall", actual[0].Title, "should start with the most recent track")
+	assert.Equal(t, "I'm so happy I can't stop crying", actual[5].Title, "should end with the oldest track")
+	assert.Equal(t, 1, len(requested), "should stop once past the window's start")
+	assert.Contains(t, requested[0], `"stationID":64`, "should request the station's history")
+}
+
+func TestUnknownStation(t *testing.T) {
+	actual, err := APIClient{Station: "fipNope"}.Playlist(0, 10)
+
+	assert.Nil(t, actual, "should not return a playlist")
+	assert.Error(t, err, "should error")
+}
+
+func TestStations(t *testing.T) {
+	got := Stations()
+
+	assert.Equal(t, len(stationIDs), len(got), "should list every station")
+	assert.Equal(t, "fip", got[0], "should sort the stations")
+}
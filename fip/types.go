@@ -2,11 +2,13 @@ package fip
 
 // node contains the playlist individual tracks information from the API
 type node struct {
+	UUID string `json:"uuid"`
 	// the song's title is under the subtitle key
 	Title       string `json:"subtitle"`
 	StartTime   int    `json:"start_time"`
 	EndTime     int    `json:"end_time"`
 	Album       string `json:"album"`
+	Label       string `json:"label"`
 	MusicalKind string `json:"musical_kind"`
 	Year        int    `json:"year"`
 	// the artist's name is under the title key
@@ -11,12 +11,12 @@ var originalURL = endpointURL
 // the live one, and arbitrary responses or failures can be served as needed.
 func SetEndpointURL(url string) {
 	endpointURL = url
-	logger.Trace.Printf("endpoint URL overridden to %q", url)
+	logger.Trace("endpoint URL overridden", "url", url)
 }
 
 // ResetEndpointURL resets the endpointURL to its original value, hitting the
 // live fip.fr API
 func ResetEndpointURL() {
 	endpointURL = originalURL
-	logger.Trace.Printf("endpoint URL reset to %q", originalURL)
+	logger.Trace("endpoint URL reset", "url", originalURL)
 }
@@ -0,0 +1,162 @@
+// Package isrc resolves the ISRC (International Standard Recording Code) of
+// source tracks, so that exporters can find the exact recording rather than
+// guess from the title and artist. The ISRCs come from a local JSON lookup
+// file, e.g. extracted from a MusicBrainz dump, or from an HTTP service.
+package isrc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/metrics"
+	"github.com/coaxial/tizinger/utils/normalize"
+)
+
+// Resolver looks up the ISRC of tracks.
+type Resolver interface {
+	// ISRC returns the ISRC of t, or "" if it is unknown.
+	ISRC(t extractor.Track) (isrc string, err error)
+}
+
+// New returns the resolver for source: an HTTP service when it is an http or
+// https URL, or else a JSON lookup file.
+func New(source string) (r Resolver, err error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		_, err = url.Parse(source)
+		if err != nil {
+			return r, fmt.Errorf("invalid ISRC service URL %q: %v", source, err)
+		}
+		return Service{URL: source}, err
+	}
+	return Open(source)
+}
+
+// Enrich sets the ISRC of the tracks r knows about. Resolving is best effort:
+// tracks whose ISRC can't be resolved keep none, and are searched for by
+// their title and artist instead.
+func Enrich(r Resolver, tracks extractor.Tracklist) (enriched extractor.Tracklist) {
+	found := 0
+	for _, t := range tracks {
+		if t.ISRC == "" {
+			isrc, err := r.ISRC(t)
+			if err != nil {
+				logger.Warning("could not resolve ISRC", "title", t.Title, "artist", t.Artist, "err", err)
+			}
+			t.ISRC = isrc
+		}
+		if t.ISRC != "" {
+			found++
+		}
+		enriched = append(enriched, t)
+	}
+	logger.Info("resolved ISRCs", "found", found, "tracks", len(tracks))
+	return enriched
+}
+
+// entry is a track in a JSON lookup file.
+type entry struct {
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	ISRC   string `json:"isrc"`
+}
+
+// key identifies a track regardless of how its title and artist are spelled.
+func key(title string, artist string) string {
+	t, _ := normalize.Title(title)
+	return normalize.Key(t) + "\x00" + normalize.Key(normalize.PrimaryArtist(artist))
+}
+
+// File resolves ISRCs from a JSON lookup file, a list of objects with the
+// title, artist and isrc of tracks.
+type File struct {
+	isrcs map[string]string
+}
+
+// Open loads the JSON lookup file at path.
+func Open(path string) (f File, err error) {
+	logger.Trace("reading ISRC lookup file", "path", path)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Error("could not read ISRC lookup file", "path", path, "err", err)
+		return f, err
+	}
+	var entries []entry
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		logger.Error("could not parse ISRC lookup file", "path", path, "err", err)
+		return f, fmt.Errorf("%s: %v", path, err)
+	}
+	f.isrcs = make(map[string]string)
+	for _, e := range entries {
+		f.isrcs[key(e.Title, e.Artist)] = strings.ToUpper(strings.TrimSpace(e.ISRC))
+	}
+	logger.Info("loaded ISRC lookup file", "tracks", len(f.isrcs), "path", path)
+	return f, err
+}
+
+// ISRC implements Resolver.
+func (f File) ISRC(t extractor.Track) (isrc string, err error) {
+	return f.isrcs[key(t.Title, t.Artist)], err
+}
+
+// timeout bounds how long requests to ISRC services can take, 0 means no
+// timeout.
+var timeout time.Duration
+
+// SetTimeout bounds how long requests to ISRC services can take, 0 means no
+// timeout.
+func SetTimeout(d time.Duration) {
+	timeout = d
+}
+
+// Service resolves ISRCs with an HTTP service, which is sent GET requests
+// with the track's title, artist and album in the query string. It responds
+// with a JSON object with the isrc, or with HTTP 404 when it doesn't know
+// the track.
+type Service struct {
+	URL string
+}
+
+// serviceResponse is what ISRC services respond with.
+type serviceResponse struct {
+	ISRC string `json:"isrc"`
+}
+
+// ISRC implements Resolver.
+func (s Service) ISRC(t extractor.Track) (isrc string, err error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return isrc, err
+	}
+	q := u.Query()
+	q.Set("title", t.Title)
+	q.Set("artist", t.Artist)
+	q.Set("album", t.Album)
+	u.RawQuery = q.Encode()
+
+	client := &http.Client{Timeout: timeout, Transport: metrics.Transport("isrc", nil)}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return isrc, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return isrc, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return isrc, fmt.Errorf("ISRC service responded with HTTP %d", resp.StatusCode)
+	}
+	var body serviceResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return isrc, fmt.Errorf("invalid response from the ISRC service: %v", err)
+	}
+	return strings.ToUpper(strings.TrimSpace(body.ISRC)), err
+}
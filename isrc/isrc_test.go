@@ -0,0 +1,96 @@
+package isrc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	f, err := Open("../fixtures/isrc/lookup.json")
+	assert.Nil(t, err, "should not have errored")
+	tests := []struct {
+		track extractor.Track
+		want  string
+		msg   string
+	}{
+		{extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}, "USWB19900690", "should ignore case"},
+		{extractor.Track{Title: "Scar Tissue (Remastered)", Artist: "Red Hot Chili Peppers feat. Someone"}, "USWB19900690", "should normalize the title and artist"},
+		{extractor.Track{Title: "Deshabillez moi", Artist: "Juliette Greco"}, "FRZ016800120", "should ignore accents and punctuation, and upper-case ISRCs"},
+		{extractor.Track{Title: "Otherside", Artist: "Red Hot Chili Peppers"}, "", "should not resolve unknown tracks"},
+	}
+	for _, tc := range tests {
+		got, err := f.ISRC(tc.track)
+		assert.Nil(t, err, tc.msg)
+		assert.Equal(t, tc.want, got, tc.msg)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	_, err := Open("../fixtures/config/mock-config.yaml")
+	assert.Error(t, err, "should error on a file that isn't JSON")
+
+	_, err = New("../fixtures/isrc/nope.json")
+	assert.Error(t, err, "should error on a missing file")
+}
+
+func TestService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("title") {
+		case "Scar tissue":
+			assert.Equal(t, "Red Hot Chili Peppers", req.URL.Query().Get("artist"), "should send the artist")
+			json.NewEncoder(resp).Encode(serviceResponse{ISRC: "USWB19900690"})
+		case "Broken":
+			resp.WriteHeader(http.StatusInternalServerError)
+		default:
+			resp.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	r, err := New(server.URL + "/isrc?key=mock")
+	assert.Nil(t, err, "should not have errored")
+	assert.IsType(t, Service{}, r, "should use the service for URLs")
+
+	got, err := r.ISRC(extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"})
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, "USWB19900690", got, "should resolve the ISRC")
+
+	got, err = r.ISRC(extractor.Track{Title: "Otherside", Artist: "Red Hot Chili Peppers"})
+	assert.Nil(t, err, "should not error on unknown tracks")
+	assert.Equal(t, "", got, "should not resolve unknown tracks")
+
+	_, err = r.ISRC(extractor.Track{Title: "Broken"})
+	assert.Error(t, err, "should error when the service fails")
+}
+
+// resolverFunc is a Resolver for testing.
+type resolverFunc func(t extractor.Track) (string, error)
+
+func (f resolverFunc) ISRC(t extractor.Track) (string, error) {
+	return f(t)
+}
+
+func TestEnrich(t *testing.T) {
+	r := resolverFunc(func(t extractor.Track) (string, error) {
+		if t.Title == "Scar tissue" {
+			return "USWB19900690", nil
+		}
+		return "", assert.AnError
+	})
+	tracks := extractor.Tracklist{
+		{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"},
+		{Title: "Otherside", Artist: "Red Hot Chili Peppers"},
+		{Title: "Californication", Artist: "Red Hot Chili Peppers", ISRC: "USWB19900687"},
+	}
+
+	got := Enrich(r, tracks)
+
+	assert.Equal(t, "USWB19900690", got[0].ISRC, "should resolve the ISRCs")
+	assert.Equal(t, "", got[1].ISRC, "should carry on when an ISRC can't be resolved")
+	assert.Equal(t, "USWB19900687", got[2].ISRC, "should keep the ISRCs already known")
+	assert.Equal(t, "", tracks[0].ISRC, "should not modify the tracks")
+}
@@ -1,39 +1,255 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/coaxial/tizinger/archive"
+	"github.com/coaxial/tizinger/extractor"
 	"github.com/coaxial/tizinger/fip"
+	"github.com/coaxial/tizinger/isrc"
+	"github.com/coaxial/tizinger/notify"
+	"github.com/coaxial/tizinger/pipeline"
 	"github.com/coaxial/tizinger/tidal"
+	"github.com/coaxial/tizinger/utils/config"
 	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/overrides"
+	"github.com/coaxial/tizinger/utils/state"
 )
 
 func main() {
-	var fipClient fip.APIClient
-	var tidalClient tidal.APIClient
-	var exitCode int
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			os.Exit(serve(os.Args[2:]))
+		case "backfill":
+			os.Exit(runBackfill(os.Args[2:]))
+		case "credentials":
+			os.Exit(runCredentials(os.Args[2:]))
+		case "overrides":
+			os.Exit(runOverrides(os.Args[2:]))
+		case "charts":
+			os.Exit(runCharts(os.Args[2:]))
+		}
+	}
+	os.Exit(run(os.Args[1:]))
+}
+
+// run creates a single playlist with the tracks FIP aired in the last 24h, or
+// with an imported tracklist, or runs a job from the config file once.
+func run(args []string) (exitCode int) {
+	flags := flag.NewFlagSet("tizinger", flag.ExitOnError)
+	importPath := flags.String("import", "", "read the tracklist from this CSV or JSON file instead of FIP")
+	exportPath := flags.String("export", "", "save the tracklist and Tidal matches to this CSV or JSON file")
+	dryRun := flags.Bool("dry-run", false, "search Tidal and print the playlists instead of creating them")
+	configPath := flags.String("config", "config.yaml", "file with the settings and jobs, optional")
+	jobName := flags.String("job", "", "run this job from -config once, for its window up until now")
+	statePath := flags.String("state", "", "file recording the progress of each playlist, to resume failed runs (default from -config)")
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath, false)
+	if err != nil {
+		return 1
+	}
+	if *statePath == "" {
+		*statePath = cfg.Settings.StateFile
+	}
+
+	var source extractor.Client = fip.APIClient{}
+	tidalClient := tidal.APIClient{DryRun: *dryRun, Job: "default", Station: "fip"}
+	corrections, err := overrides.Open(cfg.Settings.OverridesFile)
+	if err != nil {
+		logger.Error("error loading overrides", "err", err)
+		return 1
+	}
+	tidalClient.Overrides = corrections
+	pipeline.UseOverrides(corrections)
+	resolver, err := loadISRC(cfg)
+	if err != nil {
+		return 1
+	}
+	if !*dryRun {
+		store, err := state.Open(*statePath)
+		if err != nil {
+			logger.Error("error loading state", "err", err)
+			return 1
+		}
+		tidalClient.State = store
+		pipeline.UseState(store)
+	}
+	if *jobName != "" {
+		if *importPath != "" || *exportPath != "" {
+			logger.Error("-job can't be used with -import or -export, the job decides where its tracks come from")
+			return 1
+		}
+		pipeline.UseDryRun(*dryRun)
+		return runJob(cfg, *jobName, *dryRun)
+	}
 	errorWords := "without errors"
+	summary := &tidal.Summary{}
+	tidalClient.Summary = summary
+	var errs []string
 
 	ts := time.Now().AddDate(0, 0, -1) // 24h ago
 	count := 300
 	plName := fmt.Sprintf("FIP %d-%d-%d, %d tracks", ts.Year(), ts.Month(), ts.Day(), count)
-	logger.Info.Printf("getting %d tracks as aired on FIP up until %s to Tidal", count, ts.Format("2006-01-02 15:04:05"))
+	from := ts.Unix()
+	if *importPath != "" {
+		// An imported tracklist is replayed as a whole.
+		source = archive.Client{Path: *importPath}
+		tidalClient.Station = ""
+		from, count = 0, 0
+		plName = strings.TrimSuffix(filepath.Base(*importPath), filepath.Ext(*importPath))
+		logger.Info("getting tracks from file to Tidal", "path", *importPath)
+	} else {
+		logger.Info("getting tracks as aired on FIP to Tidal", "count", count, "until", ts)
+	}
 
-	list, err := fipClient.Playlist(ts.Unix(), count)
+	list, err := source.Playlist(from, count)
 	if err != nil {
-		logger.Error.Printf("error getting tracks from fip: %v", err)
+		logger.Error("error getting tracks", "err", err)
 		errorWords = "with errors"
 		exitCode = 1
+		errs = append(errs, fmt.Sprintf("getting tracks: %v", err))
+	}
+
+	if resolver != nil {
+		list = isrc.Enrich(resolver, list)
+	}
+
+	if *exportPath != "" {
+		err = export(tidalClient, *exportPath, list)
+		if err != nil {
+			logger.Error("error exporting tracklist", "path", *exportPath, "err", err)
+			errorWords = "with errors"
+			exitCode = 1
+			errs = append(errs, fmt.Sprintf("exporting tracklist: %v", err))
+		}
 	}
 
 	err = tidalClient.CreatePlaylist(plName, list)
 	if err != nil {
-		logger.Error.Printf("error creating playlist %q on Tidal: %v", plName, err)
+		logger.Error("error creating playlist on Tidal", "playlist", plName, "err", err)
 		errorWords = "with errors"
 		exitCode = 1
+		errs = append(errs, fmt.Sprintf("creating playlist on Tidal: %v", err))
+	}
+	logger.Info("done processing " + errorWords)
+	if !*dryRun {
+		notify.Send(cfg.Notify, notify.Outcome{
+			Job:       tidalClient.Job,
+			Playlist:  plName,
+			Success:   exitCode == 0,
+			Matched:   summary.Matched,
+			Unmatched: summary.Unmatched,
+			Playlists: summary.Playlists,
+			Errors:    errs,
+		})
+	}
+	return exitCode
+}
+
+// runJob runs the job named name from cfg once, for its window up until now.
+// A dry run only prints the playlists, and sends no notification.
+func runJob(cfg config.Config, name string, dryRun bool) (exitCode int) {
+	for _, j := range cfg.Jobs {
+		if j.Name != name {
+			continue
+		}
+		now := time.Now()
+		report, err := pipeline.RunReport(j, now.Add(-j.Window), now)
+		if err != nil {
+			logger.Error("error running job", "job", name, "err", err)
+			exitCode = 1
+		}
+		if !dryRun {
+			notify.Send(cfg.Notify, outcome(name, report, err))
+		}
+		return exitCode
+	}
+	logger.Error("no such job in the config", "job", name)
+	return 1
+}
+
+// outcome returns what the run of job reported, having failed with err if
+// not nil, as notifications tell it.
+func outcome(job string, report pipeline.Report, err error) (o notify.Outcome) {
+	o = notify.Outcome{Job: job, Playlist: report.Playlist, Success: err == nil}
+	for _, s := range report.Summaries {
+		o.Matched += s.Matched
+		o.Unmatched += s.Unmatched
+		for account, URL := range s.Playlists {
+			if o.Playlists == nil {
+				o.Playlists = make(map[string]string)
+			}
+			o.Playlists[account] = URL
+		}
+	}
+	if err != nil {
+		o.Errors = []string{err.Error()}
+	}
+	return o
+}
+
+// loadConfig loads the config file at path and applies its settings. A
+// missing file is only an error if the file is required, the default
+// settings are used otherwise.
+func loadConfig(path string, required bool) (cfg config.Config, err error) {
+	cfg, err = config.Load(path, fip.Stations())
+	if os.IsNotExist(err) && !required {
+		logger.Info("no config file, using the default settings", "path", path)
+		cfg, err = config.Default(), nil
+	}
+	if err != nil {
+		logger.Error("error loading config", "err", err)
+		return cfg, err
+	}
+
+	err = logger.SetLevel(cfg.Settings.LogLevel)
+	if err != nil {
+		logger.Error("error setting log level", "err", err)
+		return cfg, err
+	}
+	err = logger.SetFormat(cfg.Settings.LogFormat)
+	if err != nil {
+		logger.Error("error setting log format", "err", err)
+		return cfg, err
+	}
+	fip.SetTimeout(cfg.Settings.HTTPTimeout)
+	tidal.SetTimeout(cfg.Settings.HTTPTimeout)
+	isrc.SetTimeout(cfg.Settings.HTTPTimeout)
+	return cfg, err
+}
+
+// loadISRC returns the resolver of the tracks' ISRCs from cfg, and makes the
+// pipeline use it. r is nil when no ISRC source is configured.
+func loadISRC(cfg config.Config) (r isrc.Resolver, err error) {
+	if cfg.Settings.ISRCSource == "" {
+		return r, err
+	}
+	r, err = isrc.New(cfg.Settings.ISRCSource)
+	if err != nil {
+		logger.Error("error loading ISRC source", "err", err)
+		return r, err
+	}
+	pipeline.UseISRC(r)
+	return r, err
+}
+
+// export matches the tracks on Tidal and saves them along with their Tidal
+// IDs to path.
+func export(tidalClient tidal.APIClient, path string, list extractor.Tracklist) (err error) {
+	matches, err := tidalClient.Search(list)
+	if err != nil {
+		return err
+	}
+	IDs := make([]int, len(matches))
+	for i, m := range matches {
+		IDs[i] = m.TidalID
 	}
-	logger.Info.Printf("done processing, %s", errorWords)
-	os.Exit(exitCode)
+	return archive.Write(path, list, IDs)
 }
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email sends outcomes by email through an SMTP server.
+type Email struct {
+	// Server is the SMTP server's host:port.
+	Server string
+	From   string
+	To     []string
+	// Username and Password log in to the server, unless Username is
+	// empty. The server must support TLS unless it is on localhost.
+	Username string
+	Password string
+}
+
+// Notify emails o to the recipients.
+func (e Email) Notify(o Outcome) (err error) {
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, err := net.SplitHostPort(e.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	return smtp.SendMail(e.Server, auth, e.From, e.To, e.message(o, time.Now()))
+}
+
+// message returns the email for o, sent at date.
+func (e Email) message(o Outcome, date time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", o.Subject()))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(o.Text(), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// String describes the email's recipients.
+func (e Email) String() string {
+	return "email to " + strings.Join(e.To, ", ")
+}
@@ -0,0 +1,133 @@
+// Package notify tells about the outcome of runs, through JSON webhooks such
+// as Slack's or Matrix's, and by email.
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/credentials"
+	"github.com/coaxial/tizinger/utils/logger"
+)
+
+// Outcome is what a run did.
+type Outcome struct {
+	// Job is the name of the job that ran, "default" for the default run.
+	Job string `json:"job"`
+	// Playlist is the name of the playlist the run created.
+	Playlist string `json:"playlist"`
+	Success  bool   `json:"success"`
+	// Matched and Unmatched count the tracks found and not found on the
+	// destinations.
+	Matched   int `json:"matched"`
+	Unmatched int `json:"unmatched"`
+	// Playlists are the URLs of the playlists created, by account name.
+	Playlists map[string]string `json:"playlists,omitempty"`
+	// Errors are what went wrong.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Subject summarizes the outcome in one line.
+func (o Outcome) Subject() string {
+	if o.Success {
+		return fmt.Sprintf("Tizinger: job %q succeeded", o.Job)
+	}
+	return fmt.Sprintf("Tizinger: job %q failed", o.Job)
+}
+
+// Text describes the outcome for people.
+func (o Outcome) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, playlist %q.\n", o.Subject(), o.Playlist)
+	fmt.Fprintf(&b, "%d tracks matched, %d not found.\n", o.Matched, o.Unmatched)
+	if len(o.Playlists) > 0 {
+		var accounts []string
+		for a := range o.Playlists {
+			accounts = append(accounts, a)
+		}
+		sort.Strings(accounts)
+		fmt.Fprintf(&b, "Playlists:\n")
+		for _, a := range accounts {
+			fmt.Fprintf(&b, "- %s: %s\n", a, o.Playlists[a])
+		}
+	}
+	if len(o.Errors) > 0 {
+		fmt.Fprintf(&b, "Errors:\n")
+		for _, e := range o.Errors {
+			fmt.Fprintf(&b, "- %s\n", e)
+		}
+	}
+	return b.String()
+}
+
+// Notifier tells about an outcome.
+type Notifier interface {
+	Notify(o Outcome) error
+	// String describes where the notifier sends outcomes, for the logs.
+	String() string
+}
+
+// smtpAccount is an smtp account from the credentials file.
+type smtpAccount struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password" secret:"true"`
+}
+
+func init() {
+	credentials.Register("smtp", smtpAccount{})
+}
+
+// New returns the notifiers set up in cfg. The email notifier's credentials
+// are those of the smtp account named in cfg, if any.
+func New(cfg config.Notify) (notifiers []Notifier, err error) {
+	for _, u := range cfg.Webhooks {
+		notifiers = append(notifiers, Webhook{URL: u})
+	}
+	if len(cfg.Email.To) == 0 {
+		return notifiers, err
+	}
+	email := Email{Server: cfg.Email.Server, From: cfg.Email.From, To: cfg.Email.To}
+	if cfg.Email.Account != "" {
+		account, err := credentials.Lookup("smtp", cfg.Email.Account)
+		if err != nil {
+			return notifiers, err
+		}
+		var smtp smtpAccount
+		err = account.Decode(&smtp)
+		if err != nil {
+			return notifiers, err
+		}
+		email.Username, email.Password = smtp.Username, smtp.Password
+	}
+	return append(notifiers, email), err
+}
+
+// Send tells every notifier set up in cfg about o, unless cfg only notifies
+// about failures and o is a success. Every notifier is tried even if some
+// fail.
+func Send(cfg config.Notify, o Outcome) (err error) {
+	if o.Success && cfg.On == "failure" {
+		return err
+	}
+	notifiers, err := New(cfg)
+	if err != nil {
+		logger.Error("error setting up notifications", "err", err)
+		return err
+	}
+	var failed []string
+	for _, n := range notifiers {
+		err := n.Notify(o)
+		if err != nil {
+			logger.Error("error sending notification", "notifier", n.String(), "err", err)
+			failed = append(failed, err.Error())
+			continue
+		}
+		logger.Info("sent notification", "notifier", n.String(), "job", o.Job, "success", o.Success)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d notifications failed: %s", len(failed), len(notifiers), strings.Join(failed, "; "))
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var outcome = Outcome{
+	Job:       "daily",
+	Playlist:  "FIP 2020-06-24, 3 tracks",
+	Success:   false,
+	Matched:   2,
+	Unmatched: 1,
+	Playlists: map[string]string{"user1@example.com": "https://tidal.com/browse/playlist/mock-uuid"},
+	Errors:    []string{"tidal API responded with HTTP 500"},
+}
+
+func TestText(t *testing.T) {
+	want := `Tizinger: job "daily" failed, playlist "FIP 2020-06-24, 3 tracks".
+2 tracks matched, 1 not found.
+Playlists:
+- user1@example.com: https://tidal.com/browse/playlist/mock-uuid
+Errors:
+- tidal API responded with HTTP 500
+`
+
+	assert.Equal(t, want, outcome.Text(), "should describe the outcome")
+}
+
+func TestWebhook(t *testing.T) {
+	var got webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"), "should post JSON")
+		body, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(body, &got)
+	}))
+	defer server.Close()
+
+	err := Webhook{URL: server.URL}.Notify(outcome)
+
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, outcome.Text(), got.Text, "should have Slack's text")
+	assert.Equal(t, "m.text", got.MsgType, "should have Matrix's message type")
+	assert.Equal(t, outcome.Text(), got.Body, "should have Matrix's body")
+	assert.Equal(t, outcome, got.Outcome, "should have the outcome")
+}
+
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := Webhook{URL: server.URL}.Notify(outcome)
+
+	assert.NotNil(t, err, "should have errored")
+	assert.Contains(t, err.Error(), "404", "should tell the status")
+}
+
+func TestWebhookErrorURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}))
+	server.Close()
+	URL := server.URL + "/hooks/s3cr3t-t0k3n"
+
+	err := Webhook{URL: URL}.Notify(outcome)
+
+	assert.NotNil(t, err, "should have errored")
+	assert.NotContains(t, err.Error(), "s3cr3t-t0k3n", "should keep the webhook's URL out of the error")
+	assert.Contains(t, err.Error(), strings.TrimPrefix(server.URL, "http://"), "should tell the webhook's host")
+}
+
+// smtpServer is an SMTP stand-in accepting one email, which it sends to the
+// returned channel as the recipients followed by the data.
+func smtpServer(t *testing.T) (addr string, mail chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	mail = make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		var got strings.Builder
+		reply("220 localhost")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "RCPT"):
+				got.WriteString(line)
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					got.WriteString(line)
+				}
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 bye")
+				mail <- got.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String(), mail
+}
+
+func TestEmail(t *testing.T) {
+	addr, mail := smtpServer(t)
+	email := Email{Server: addr, From: "tizinger@example.org", To: []string{"me@example.org", "you@example.org"}}
+
+	err := email.Notify(outcome)
+
+	assert.Nil(t, err, "shouldn't have errored")
+	got := <-mail
+	assert.Contains(t, got, "RCPT TO:<me@example.org>", "should send to every recipient")
+	assert.Contains(t, got, "RCPT TO:<you@example.org>", "should send to every recipient")
+	assert.Contains(t, got, "Subject: Tizinger: job \"daily\" failed\r\n", "should have the subject")
+	assert.Contains(t, got, "- user1@example.com: https://tidal.com/browse/playlist/mock-uuid\r\n", "should have the outcome")
+}
+
+func TestSend(t *testing.T) {
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		posts++
+	}))
+	defer server.Close()
+	addr, mail := smtpServer(t)
+	cfg := config.Notify{
+		On:       "failure",
+		Webhooks: []string{server.URL, server.URL},
+		Email:    config.Email{Server: addr, From: "tizinger@example.org", To: []string{"me@example.org"}},
+	}
+
+	success := outcome
+	success.Success = true
+	err := Send(cfg, success)
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, 0, posts, "shouldn't notify about successes")
+
+	err = Send(cfg, outcome)
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, 2, posts, "should notify every webhook")
+	assert.Contains(t, <-mail, "failed", "should send the email")
+
+	cfg.Webhooks = []string{"http://127.0.0.1:0"}
+	cfg.Email = config.Email{}
+	err = Send(cfg, outcome)
+	assert.NotNil(t, err, "should tell when notifications fail")
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Webhook posts outcomes as JSON to URL.
+type Webhook struct {
+	URL string
+}
+
+// webhookPayload is what webhooks get. It is understood by Slack's incoming
+// webhooks, which show Text, by Matrix's, which show Body, and by anything
+// reading the Outcome.
+type webhookPayload struct {
+	Text    string  `json:"text"`
+	MsgType string  `json:"msgtype"`
+	Body    string  `json:"body"`
+	Outcome Outcome `json:"outcome"`
+}
+
+// webhookClient makes the requests to webhooks.
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// Notify posts o to the webhook. Responses other than HTTP 2xx are errors.
+// Errors only name the webhook's host, like String.
+func (w Webhook) Notify(o Outcome) (err error) {
+	text := o.Text()
+	payload, err := json.Marshal(webhookPayload{Text: text, MsgType: "m.text", Body: text, Outcome: o})
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(w.URL, "application/json", bytes.NewReader(payload))
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return fmt.Errorf("posting to %v: %v", w, uerr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with HTTP %d", resp.StatusCode)
+	}
+	return err
+}
+
+// String describes the webhook by its host only, since webhook URLs often
+// hold a secret token.
+func (w Webhook) String() string {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return "webhook"
+	}
+	return "webhook to " + u.Host
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/overrides"
+)
+
+// runOverrides adds or lists the overrides deciding what source tracks match
+// on Tidal.
+func runOverrides(args []string) (exitCode int) {
+	flags := flag.NewFlagSet("overrides", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "file with the settings, optional")
+	path := flags.String("file", "", "overrides file (default from -config)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: tizinger overrides [-config config.yaml] [-file overrides.yaml] list|add [add flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath, false)
+	if err != nil {
+		return 1
+	}
+	if *path == "" {
+		*path = cfg.Settings.OverridesFile
+	}
+
+	var store *overrides.Store
+	switch flags.Arg(0) {
+	case "list":
+		store, err = overrides.Open(*path)
+		if err == nil {
+			for _, o := range store.List() {
+				fmt.Println(o)
+			}
+		}
+	case "add":
+		store, err = overrides.Open(*path)
+		if err == nil {
+			err = addOverride(store, flags.Args()[1:])
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		logger.Error("overrides command failed", "path", *path, "err", err)
+		return 1
+	}
+	return 0
+}
+
+// addOverride saves the override args describe to store, replacing the one
+// for the same track if any.
+func addOverride(store *overrides.Store, args []string) (err error) {
+	flags := flag.NewFlagSet("overrides add", flag.ExitOnError)
+	title := flags.String("title", "", "title of the track as FIP aired it")
+	artist := flags.String("artist", "", "artist of the track as FIP aired it")
+	uuid := flags.String("uuid", "", "FIP's uuid of the track, instead of -title and -artist")
+	tidalID := flags.Int("tidal-id", 0, "ID of the Tidal track it matches")
+	skip := flags.Bool("skip", false, "leave the track out of the playlists instead")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: tizinger overrides add -title TITLE -artist ARTIST | -uuid UUID, -tidal-id ID | -skip")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New("unexpected arguments, quote titles and artists containing spaces")
+	}
+
+	o := overrides.Override{Title: *title, Artist: *artist, UUID: *uuid, TidalID: *tidalID, Skip: *skip}
+	err = store.Set(o)
+	if err == nil {
+		logger.Info("override saved", "override", o.String())
+	}
+	return err
+}
@@ -0,0 +1,261 @@
+// Package pipeline runs jobs: it gets the tracks aired on a station during a
+// window of time, filters them, and exports them as a playlist to the job's
+// destinations.
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coaxial/tizinger/archive"
+	"github.com/coaxial/tizinger/exporter"
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/fip"
+	"github.com/coaxial/tizinger/isrc"
+	"github.com/coaxial/tizinger/tidal"
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/metrics"
+	"github.com/coaxial/tizinger/utils/normalize"
+	"github.com/coaxial/tizinger/utils/overrides"
+	"github.com/coaxial/tizinger/utils/state"
+)
+
+// exporters maps the destination names jobs can use to a function returning
+// their exporter set up for the job, filling summary with what it did. It can
+// be overridden when testing.
+var exporters = map[string]func(job config.Job, summary *tidal.Summary) exporter.Client{
+	"tidal": func(job config.Job, summary *tidal.Summary) exporter.Client {
+		return tidal.APIClient{
+			SkipExisting:     job.SkipExisting,
+			DryRun:           dryRun,
+			State:            store,
+			Job:              job.Name,
+			Station:          job.Station,
+			Accounts:         job.Accounts,
+			Summary:          summary,
+			Overrides:        overrideStore,
+			DedupePlaylists:  job.Dedupe.Playlists,
+			DedupeDays:       job.Dedupe.Days,
+			Discovery:        job.Discovery,
+			FavoriteTracks:   job.Favorites.Tracks,
+			FavoriteArtists:  job.Favorites.Artists,
+			FavoriteMinPlays: job.Favorites.MinPlays,
+		}
+	},
+}
+
+// Report is what a run did.
+type Report struct {
+	// Playlist is the name of the playlist created.
+	Playlist string
+	// Tracks are the tracks the filters let through.
+	Tracks extractor.Tracklist
+	// Summaries are what each destination did, by destination name.
+	Summaries map[string]*tidal.Summary
+}
+
+// store records the exporters' progress so that re-running a job after a
+// partial failure completes its playlists instead of duplicating them. It is
+// optional, see UseState.
+var store *state.Store
+
+// UseState makes the exporters record their progress in s.
+func UseState(s *state.Store) {
+	store = s
+}
+
+// overrideStore has the user's corrections to track matches, which the
+// exporters use instead of searching. It is optional, see UseOverrides.
+var overrideStore *overrides.Store
+
+// UseOverrides makes the exporters use the overrides in o.
+func UseOverrides(o *overrides.Store) {
+	overrideStore = o
+}
+
+// dryRun makes the exporters only print the playlists they would create, see
+// UseDryRun.
+var dryRun bool
+
+// UseDryRun makes the exporters search for the tracks and print the playlists
+// instead of creating them when d is true.
+func UseDryRun(d bool) {
+	dryRun = d
+}
+
+// resolver resolves the tracks' ISRCs before they are exported. It is
+// optional, see UseISRC.
+var resolver isrc.Resolver
+
+// UseISRC makes the runs resolve the tracks' ISRCs with r, for the exporters
+// to search for them by ISRC first.
+func UseISRC(r isrc.Resolver) {
+	resolver = r
+}
+
+// exporting ensures only one playlist is exported at a time: the exporters
+// keep the logged in user's session in package state, so jobs can extract
+// tracks concurrently but not export them.
+var exporting sync.Mutex
+
+// newSource returns the extractor for a job. It can be overridden when
+// testing.
+var newSource = func(job config.Job) extractor.Client {
+	if job.Source == "file" {
+		return archive.Client{Path: job.File}
+	}
+	return fip.APIClient{Station: job.Station}
+}
+
+// Run gets the tracks aired on the job's station from `from` up until `to`,
+// or the job's count of tracks aired up until `to`, and creates a playlist
+// with the ones its filters let through on each of the job's destinations.
+func Run(job config.Job, from time.Time, to time.Time) (err error) {
+	_, err = RunReport(job, from, to)
+	return err
+}
+
+// RunReport is Run, also returning what the run did so far, even when it
+// failed.
+func RunReport(job config.Job, from time.Time, to time.Time) (report Report, err error) {
+	log := logger.With("job", job.Name, "station", job.Station)
+	log.Info("running job", "from", from, "to", to)
+	for _, d := range job.Destinations {
+		if _, ok := exporters[d]; !ok {
+			err = fmt.Errorf("job %q has unknown destination %q", job.Name, d)
+			log.Error("unknown destination", "destination", d)
+			return report, err
+		}
+	}
+
+	var tracks extractor.Tracklist
+	if job.Count > 0 {
+		tracks, err = newSource(job).Playlist(to.Unix(), job.Count)
+	} else {
+		tracks, err = newSource(job).Between(from.Unix(), to.Unix())
+	}
+	if err != nil {
+		log.Error("error getting tracks", "err", err)
+		return report, err
+	}
+	metrics.TracksFetched.Add(float64(len(tracks)), job.Station)
+	tracks = filter(tracks, job.Filters)
+	if resolver != nil {
+		tracks = isrc.Enrich(resolver, tracks)
+	}
+	report.Tracks = tracks
+
+	name, err := job.PlaylistName(config.NameData{
+		Job:     job.Name,
+		Station: job.Station,
+		From:    from,
+		To:      to,
+		Count:   len(tracks),
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Playlist = name
+
+	report.Summaries, err = Export(job, name, tracks)
+	if err != nil {
+		return report, err
+	}
+	metrics.LastSuccess.Set(float64(time.Now().Unix()), job.Name)
+	log.Info("done running job")
+	return report, err
+}
+
+// Export creates the playlist name with tracks on each of the job's
+// destinations, as runs do once they have the tracks. It returns what each
+// destination did so far, even when one failed.
+func Export(job config.Job, name string, tracks extractor.Tracklist) (summaries map[string]*tidal.Summary, err error) {
+	log := logger.With("job", job.Name, "station", job.Station)
+	exporting.Lock()
+	defer exporting.Unlock()
+	summaries = make(map[string]*tidal.Summary)
+	for _, d := range job.Destinations {
+		newExporter, ok := exporters[d]
+		if !ok {
+			err = fmt.Errorf("job %q has unknown destination %q", job.Name, d)
+			log.Error("unknown destination", "destination", d)
+			return summaries, err
+		}
+		log.Info("creating playlist", "playlist", name, "destination", d)
+		summaries[d] = &tidal.Summary{}
+		err = newExporter(job, summaries[d]).CreatePlaylist(name, tracks)
+		if err != nil {
+			log.Error("error creating playlist", "playlist", name, "destination", d, "err", err)
+			return summaries, err
+		}
+	}
+	return summaries, err
+}
+
+// filter returns the tracks that f lets through.
+func filter(tracks extractor.Tracklist, f config.Filters) (kept extractor.Tracklist) {
+	included := keySet(f.IncludeKinds)
+	excluded := keySet(f.ExcludeKinds)
+	artists := keySet(f.ExcludeArtists)
+	titles := keySet(f.ExcludeTitles)
+	reasons := make(map[string]int)
+	for _, t := range tracks {
+		reason := ""
+		kind := normalize.Key(t.MusicalKind)
+		switch {
+		case kind != "" && len(included) > 0 && !included[kind]:
+			reason = "kind"
+		case kind != "" && excluded[kind]:
+			reason = "kind"
+		case t.Year > 0 && f.MinYear > 0 && t.Year < f.MinYear:
+			reason = "year"
+		case t.Year > 0 && f.MaxYear > 0 && t.Year > f.MaxYear:
+			reason = "year"
+		case anyIn(artists, append(normalize.Artists(t.Artist), t.Artist)):
+			reason = "artist"
+		case anyIn(titles, []string{t.Title, stripped(t.Title)}):
+			reason = "title"
+		case f.MinDuration > 0 && t.StartTime > 0 && t.EndTime > t.StartTime &&
+			time.Duration(t.EndTime-t.StartTime)*time.Second < f.MinDuration:
+			reason = "duration"
+		}
+		if reason != "" {
+			logger.Trace("filtered out track", "title", t.Title, "artist", t.Artist, "reason", reason)
+			reasons[reason]++
+			continue
+		}
+		kept = append(kept, t)
+	}
+	if len(kept) < len(tracks) {
+		logger.Info("filtered out tracks", "filtered", len(tracks)-len(kept), "tracks", len(tracks), "reasons", reasons)
+	}
+	return kept
+}
+
+// stripped returns title without the parts describing the recording, so
+// that excluding a title also excludes its live or remastered versions.
+func stripped(title string) string {
+	s, _ := normalize.Title(title)
+	return s
+}
+
+// keySet returns the normalized elements as a set.
+func keySet(elements []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, e := range elements {
+		set[normalize.Key(e)] = true
+	}
+	return set
+}
+
+// anyIn returns whether any of the values is in set once normalized.
+func anyIn(set map[string]bool, values []string) bool {
+	for _, v := range values {
+		if set[normalize.Key(v)] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coaxial/tizinger/exporter"
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/tidal"
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// tidalExporter is the tidal exporter, kept before the tests replace the
+// exporters with mocks.
+var tidalExporter = exporters["tidal"]
+
+// mockSource serves canned tracks and records the window it was asked for.
+type mockSource struct {
+	tracks   extractor.Tracklist
+	from, to *int64
+}
+
+func (m mockSource) Playlist(timestampFrom int64, tracksCount int) (extractor.Tracklist, error) {
+	return m.tracks, nil
+}
+
+func (m mockSource) Between(from int64, to int64) (extractor.Tracklist, error) {
+	*m.from, *m.to = from, to
+	return m.tracks, nil
+}
+
+// mockExporter records the playlists it was asked to create.
+type mockExporter struct {
+	created map[string]extractor.Tracklist
+	err     error
+}
+
+func (m mockExporter) CreatePlaylist(name string, tracks extractor.Tracklist) error {
+	m.created[name] = tracks
+	return m.err
+}
+
+func TestRun(t *testing.T) {
+	var from, to int64
+	tracks := extractor.Tracklist{{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}}
+	var station string
+	newSource = func(j config.Job) extractor.Client {
+		station = j.Station
+		return mockSource{tracks: tracks, from: &from, to: &to}
+	}
+	mock := mockExporter{created: make(map[string]extractor.Tracklist)}
+	exporters = map[string]func(config.Job, *tidal.Summary) exporter.Client{
+		"mock": func(config.Job, *tidal.Summary) exporter.Client { return mock },
+	}
+	job := config.Job{
+		Name:         "daily",
+		Station:      "fipJazz",
+		Destinations: []string{"mock"},
+		Playlist:     `{{.Job}} {{.Station}} {{.From.Format "2006-01-02"}}, {{.Count}} tracks`,
+	}
+	start := time.Date(2020, time.June, 22, 6, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	report, err := RunReport(job, start, end)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, "daily fipJazz 2020-06-22, 1 tracks", report.Playlist, "should report the playlist")
+	assert.Contains(t, report.Summaries, "mock", "should report what each destination did")
+	assert.Equal(t, "fipJazz", station, "should have fetched tracks from the job's station")
+	assert.Equal(t, start.Unix(), from, "should have fetched tracks from the window's start")
+	assert.Equal(t, end.Unix(), to, "should have fetched tracks up until the window's end")
+	assert.Equal(t, tracks, mock.created["daily fipJazz 2020-06-22, 1 tracks"], "should have created the playlist")
+}
+
+func TestRunErrors(t *testing.T) {
+	var from, to int64
+	newSource = func(config.Job) extractor.Client {
+		return mockSource{from: &from, to: &to}
+	}
+	failing := mockExporter{created: make(map[string]extractor.Tracklist), err: errors.New("mock error")}
+	exporters = map[string]func(config.Job, *tidal.Summary) exporter.Client{
+		"failing": func(config.Job, *tidal.Summary) exporter.Client { return failing },
+	}
+	now := time.Now()
+
+	err := Run(config.Job{Name: "unknown", Destinations: []string{"nope"}}, now, now)
+	assert.Error(t, err, "should error on unknown destinations")
+
+	err = Run(config.Job{Name: "failing", Destinations: []string{"failing"}, Playlist: "x"}, now, now)
+	assert.Error(t, err, "should error when the exporter does")
+}
+
+func TestUseDryRun(t *testing.T) {
+	defer UseDryRun(false)
+	job := config.Job{Name: "daily", Station: "fipJazz"}
+
+	UseDryRun(true)
+	client := tidalExporter(job, &tidal.Summary{}).(tidal.APIClient)
+	assert.True(t, client.DryRun, "should only print the playlists")
+
+	UseDryRun(false)
+	client = tidalExporter(job, &tidal.Summary{}).(tidal.APIClient)
+	assert.False(t, client.DryRun, "should create the playlists by default")
+}
+
+func TestExport(t *testing.T) {
+	mock := mockExporter{created: make(map[string]extractor.Tracklist)}
+	exporters = map[string]func(config.Job, *tidal.Summary) exporter.Client{
+		"mock": func(config.Job, *tidal.Summary) exporter.Client { return mock },
+	}
+	tracks := extractor.Tracklist{{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}}
+
+	summaries, err := Export(config.Job{Name: "charts", Destinations: []string{"mock"}}, "FIP top 1", tracks)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Contains(t, summaries, "mock", "should report what each destination did")
+	assert.Equal(t, tracks, mock.created["FIP top 1"], "should have created the playlist")
+
+	_, err = Export(config.Job{Name: "charts", Destinations: []string{"nope"}}, "FIP top 1", tracks)
+	assert.Error(t, err, "should error on unknown destinations")
+}
+
+func TestFilter(t *testing.T) {
+	tracks := extractor.Tracklist{
+		{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"},
+		{Title: "Off the wall", Artist: "Jil Is Lucky"},
+		{Title: "Jingle", Artist: "FIP"},
+	}
+	f := config.Filters{ExcludeArtists: []string{"red hot chili peppers"}, ExcludeTitles: []string{"JINGLE"}}
+
+	got := filter(tracks, f)
+
+	assert.Equal(t, tracks[1:2], got, "should have left out the excluded artists and titles")
+}
+
+func TestFilters(t *testing.T) {
+	symphony := extractor.Track{Title: "Serenade nº13", Artist: "I Musici", MusicalKind: "Musique symphonique", Year: 1990}
+	rock := extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", MusicalKind: "Pop / pop rock", Year: 1999}
+	unknown := extractor.Track{Title: "Off the wall", Artist: "Jil Is Lucky"}
+	featuring := extractor.Track{Title: "Sambarilove", Artist: "Chiara Civello feat. Roubinho Jacobina"}
+	live := extractor.Track{Title: "Déshabillez-moi (Live)", Artist: "Juliette Gréco"}
+	jingle := extractor.Track{Title: "Jingle", Artist: "FIP", StartTime: 1592891806, EndTime: 1592891816}
+	tracks := extractor.Tracklist{symphony, rock, unknown, featuring, live, jingle}
+
+	tests := []struct {
+		filters config.Filters
+		want    extractor.Tracklist
+		msg     string
+	}{
+		{config.Filters{}, tracks, "should let everything through without filters"},
+		{config.Filters{ExcludeKinds: []string{"musique symphonique"}}, extractor.Tracklist{rock, unknown, featuring, live, jingle}, "should leave out the excluded kinds"},
+		{config.Filters{IncludeKinds: []string{"Pop/pop rock"}}, extractor.Tracklist{rock, unknown, featuring, live, jingle}, "should only keep the included kinds, and the tracks of unknown kind"},
+		{config.Filters{MinYear: 1995}, extractor.Tracklist{rock, unknown, featuring, live, jingle}, "should leave out the tracks released before the range"},
+		{config.Filters{MaxYear: 1995}, extractor.Tracklist{symphony, unknown, featuring, live, jingle}, "should leave out the tracks released after the range"},
+		{config.Filters{MinYear: 1990, MaxYear: 1990}, extractor.Tracklist{symphony, unknown, featuring, live, jingle}, "should include the range's bounds"},
+		{config.Filters{ExcludeArtists: []string{"Roubinho Jacobina"}}, extractor.Tracklist{symphony, rock, unknown, live, jingle}, "should leave out the tracks excluded artists are featured on"},
+		{config.Filters{ExcludeArtists: []string{"juliette greco"}}, extractor.Tracklist{symphony, rock, unknown, featuring, jingle}, "should ignore accents in artists"},
+		{config.Filters{ExcludeTitles: []string{"Deshabillez moi"}}, extractor.Tracklist{symphony, rock, unknown, featuring, jingle}, "should leave out every version of excluded titles"},
+		{config.Filters{MinDuration: time.Minute}, extractor.Tracklist{symphony, rock, unknown, featuring, live}, "should leave out the tracks which aired too briefly, and keep those of unknown duration"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, filter(tracks, tc.filters), tc.msg)
+	}
+}
@@ -0,0 +1,158 @@
+// Package scheduler runs jobs on their cron schedule, catching up on the runs
+// missed while Tizinger wasn't running.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/metrics"
+	"github.com/coaxial/tizinger/utils/state"
+	"github.com/robfig/cron/v3"
+)
+
+// Runner runs job for the window from `from` up until `to`.
+type Runner func(job config.Job, from time.Time, to time.Time) error
+
+// maxCatchUp is the maximum number of missed runs done for a job at once.
+// Older missed runs are skipped, so that coming back from a long downtime
+// doesn't create hundreds of playlists.
+const maxCatchUp = 30
+
+// scheduledJob is a job along with its parsed cron schedule.
+type scheduledJob struct {
+	config.Job
+	schedule cron.Schedule
+}
+
+// Scheduler runs jobs on their schedule and records their last successful
+// or failed window in a state store.
+type Scheduler struct {
+	jobs  []scheduledJob
+	run   Runner
+	state *state.Store
+	// now can be overridden when testing.
+	now func() time.Time
+	// running serializes runs: the exporters keep the logged in user's
+	// session in package state, so jobs can't run concurrently.
+	running sync.Mutex
+}
+
+// New prepares a scheduler for jobs, which are run with run. Jobs without a
+// schedule, which only run on demand, are left out. It errors if a job's
+// schedule can't be parsed.
+func New(jobs []config.Job, run Runner, store *state.Store) (s *Scheduler, err error) {
+	s = &Scheduler{run: run, state: store, now: time.Now}
+	for _, j := range jobs {
+		if j.Schedule == "" {
+			logger.Info("job has no schedule, only running it on demand", "job", j.Name)
+			continue
+		}
+		schedule, err := cron.ParseStandard(j.Schedule)
+		if err != nil {
+			err = fmt.Errorf("job %q has an invalid schedule %q: %v", j.Name, j.Schedule, err)
+			logger.Error("invalid schedule", "job", j.Name, "schedule", j.Schedule, "err", err)
+			return nil, err
+		}
+		s.jobs = append(s.jobs, scheduledJob{Job: j, schedule: schedule})
+	}
+	return s, err
+}
+
+// Run runs the jobs on their schedule until ctx is cancelled. Missed runs are
+// caught up on first. Upon cancellation, it waits for the run in progress to
+// finish before returning.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func(j scheduledJob) {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+	logger.Info("scheduled jobs", "jobs", len(s.jobs))
+	wg.Wait()
+	logger.Info("scheduler stopped")
+}
+
+// loop catches up on j's missed runs, then waits for its next scheduled time
+// to run it, over and over until ctx is cancelled.
+func (s *Scheduler) loop(ctx context.Context, j scheduledJob) {
+	s.catchUp(ctx, j, time.Time{})
+	for {
+		next := j.schedule.Next(s.now())
+		logger.Info("next run", "job", j.Name, "at", next)
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.catchUp(ctx, j, next)
+	}
+}
+
+// catchUp runs j for every scheduled time since its last successful window
+// up until now, oldest first. It stops at the first failure so that the
+// failed window is retried next time. fired is the scheduled time that
+// triggered the call, if any: a job that never ran has nothing to catch up
+// on, so it only runs for that time, while a job that never succeeded
+// catches up from the window it failed.
+func (s *Scheduler) catchUp(ctx context.Context, j scheduledJob, fired time.Time) {
+	var due []time.Time
+	now := s.now()
+	last, ok := s.state.LastRun(j.Name)
+	failed, hasFailed := s.state.Failed(j.Name)
+	switch {
+	case ok:
+		for t := j.schedule.Next(last); !t.After(now); t = j.schedule.Next(t) {
+			due = append(due, t)
+		}
+	case hasFailed:
+		for t := failed; !t.After(now); t = j.schedule.Next(t) {
+			due = append(due, t)
+		}
+	case !fired.IsZero():
+		due = append(due, fired)
+	}
+	if len(due) > maxCatchUp {
+		logger.Warning("job missed too many runs, only catching up on the last ones", "job", j.Name, "missed", len(due), "catching_up", maxCatchUp)
+		due = due[len(due)-maxCatchUp:]
+	}
+
+	for _, t := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		err := s.runAt(j, t)
+		if err != nil {
+			logger.Error("job failed, will retry on its next run", "job", j.Name, "at", t, "err", err)
+			return
+		}
+	}
+}
+
+// runAt runs j for the window ending at t and records it upon success.
+func (s *Scheduler) runAt(j scheduledJob, t time.Time) (err error) {
+	s.running.Lock()
+	defer s.running.Unlock()
+	if failed, ok := s.state.Failed(j.Name); ok && failed.Equal(t) {
+		metrics.JobRetries.Inc(j.Name)
+	}
+	err = s.run(j.Job, t.Add(-j.Window), t)
+	if err != nil {
+		// Keep the failed window for a job that never succeeded to
+		// catch up from it, and to count retries across restarts.
+		if saveErr := s.state.SetFailed(j.Name, t); saveErr != nil {
+			logger.Error("error recording failed window", "job", j.Name, "at", t, "err", saveErr)
+		}
+		return err
+	}
+	return s.state.SetLastRun(j.Name, t)
+}
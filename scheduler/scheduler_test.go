@@ -0,0 +1,161 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/metrics"
+	"github.com/coaxial/tizinger/utils/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestScheduler returns a scheduler for a daily job at 06:00 UTC with a
+// 24h window, whose clock is frozen at now, recording the windows it runs.
+// Runs for the windows ending at the times in failing error.
+func newTestScheduler(t *testing.T, now time.Time, failing ...time.Time) (s *Scheduler, ran *[]time.Time, cleanup func()) {
+	dir, err := ioutil.TempDir("", "tizinger-scheduler")
+	assert.Nil(t, err)
+	store, err := state.Open(filepath.Join(dir, "state.json"))
+	assert.Nil(t, err)
+	ran = &[]time.Time{}
+	run := func(job config.Job, from time.Time, to time.Time) error {
+		assert.Equal(t, 24*time.Hour, to.Sub(from), "should run for the job's window")
+		for _, f := range failing {
+			if f.Equal(to) {
+				return errors.New("mock error")
+			}
+		}
+		*ran = append(*ran, to)
+		return nil
+	}
+	jobs := []config.Job{{Name: "daily", Schedule: "0 6 * * *", Window: 24 * time.Hour}}
+	s, err = New(jobs, run, store)
+	assert.Nil(t, err)
+	s.now = func() time.Time { return now }
+	return s, ran, func() { os.RemoveAll(dir) }
+}
+
+func TestCatchUp(t *testing.T) {
+	now := time.Date(2020, time.June, 25, 12, 0, 0, 0, time.Local)
+	s, ran, cleanup := newTestScheduler(t, now)
+	defer cleanup()
+	s.state.SetLastRun("daily", time.Date(2020, time.June, 22, 6, 0, 0, 0, time.Local))
+	want := []time.Time{
+		time.Date(2020, time.June, 23, 6, 0, 0, 0, time.Local),
+		time.Date(2020, time.June, 24, 6, 0, 0, 0, time.Local),
+		time.Date(2020, time.June, 25, 6, 0, 0, 0, time.Local),
+	}
+
+	s.catchUp(context.Background(), s.jobs[0], time.Time{})
+	last, _ := s.state.LastRun("daily")
+
+	assert.Equal(t, want, *ran, "should have run the missed windows, oldest first")
+	assert.True(t, want[2].Equal(last), "should have recorded the last successful window")
+}
+
+func TestCatchUpStopsOnFailure(t *testing.T) {
+	now := time.Date(2020, time.June, 25, 12, 0, 0, 0, time.Local)
+	failing := time.Date(2020, time.June, 24, 6, 0, 0, 0, time.Local)
+	s, ran, cleanup := newTestScheduler(t, now, failing)
+	defer cleanup()
+	s.state.SetLastRun("daily", time.Date(2020, time.June, 22, 6, 0, 0, 0, time.Local))
+	want := []time.Time{time.Date(2020, time.June, 23, 6, 0, 0, 0, time.Local)}
+
+	s.catchUp(context.Background(), s.jobs[0], time.Time{})
+	last, _ := s.state.LastRun("daily")
+
+	assert.Equal(t, want, *ran, "should have stopped at the failed window")
+	assert.True(t, want[0].Equal(last), "should not have recorded the failed window")
+}
+
+func TestCatchUpCountsRetries(t *testing.T) {
+	now := time.Date(2020, time.June, 25, 12, 0, 0, 0, time.Local)
+	failing := time.Date(2020, time.June, 24, 6, 0, 0, 0, time.Local)
+	s, _, cleanup := newTestScheduler(t, now, failing)
+	defer cleanup()
+	s.state.SetLastRun("daily", time.Date(2020, time.June, 23, 6, 0, 0, 0, time.Local))
+	before := metrics.JobRetries.Value("daily")
+
+	s.catchUp(context.Background(), s.jobs[0], time.Time{})
+	assert.Equal(t, before, metrics.JobRetries.Value("daily"), "shouldn't count the first attempt")
+	s.catchUp(context.Background(), s.jobs[0], time.Time{})
+	assert.Equal(t, before+1, metrics.JobRetries.Value("daily"), "should count running the failed window again")
+}
+
+func TestCatchUpWithoutState(t *testing.T) {
+	now := time.Date(2020, time.June, 25, 6, 0, 0, 0, time.Local)
+	s, ran, cleanup := newTestScheduler(t, now)
+	defer cleanup()
+
+	s.catchUp(context.Background(), s.jobs[0], time.Time{})
+	assert.Empty(t, *ran, "should not run anything on startup")
+
+	s.catchUp(context.Background(), s.jobs[0], now)
+	assert.Equal(t, []time.Time{now}, *ran, "should run for the scheduled time")
+}
+
+func TestCatchUpRetriesFirstRun(t *testing.T) {
+	first := time.Date(2020, time.June, 24, 6, 0, 0, 0, time.Local)
+	s, ran, cleanup := newTestScheduler(t, first, first)
+	defer cleanup()
+	before := metrics.JobRetries.Value("daily")
+
+	s.catchUp(context.Background(), s.jobs[0], first)
+	assert.Empty(t, *ran, "should have failed the first run")
+	now := first.Add(24 * time.Hour)
+	s.now = func() time.Time { return now }
+	s.run = func(job config.Job, from time.Time, to time.Time) error {
+		*ran = append(*ran, to)
+		return nil
+	}
+	s.catchUp(context.Background(), s.jobs[0], now)
+	last, _ := s.state.LastRun("daily")
+
+	assert.Equal(t, []time.Time{first, now}, *ran, "should have caught up from the failed window")
+	assert.True(t, now.Equal(last), "should have recorded the last successful window")
+	assert.Equal(t, before+1, metrics.JobRetries.Value("daily"), "should count running the failed window again")
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	s, _, cleanup := newTestScheduler(t, time.Now())
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+
+	go func() {
+		s.Run(ctx)
+		done <- true
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("should have stopped after cancellation")
+	}
+}
+
+func TestNewUnscheduled(t *testing.T) {
+	jobs := []config.Job{
+		{Name: "daily", Schedule: "0 6 * * *", Window: 24 * time.Hour},
+		{Name: "on-demand", Window: time.Hour},
+	}
+
+	s, err := New(jobs, nil, nil)
+
+	assert.Nil(t, err, "should not error on jobs without a schedule")
+	assert.Len(t, s.jobs, 1, "should only schedule the jobs with a schedule")
+	assert.Equal(t, "daily", s.jobs[0].Name, "should schedule the jobs with a schedule")
+}
+
+func TestNewInvalidSchedule(t *testing.T) {
+	_, err := New([]config.Job{{Name: "nope", Schedule: "every day"}}, nil, nil)
+
+	assert.Error(t, err, "should error on invalid cron expressions")
+}
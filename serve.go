@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/coaxial/tizinger/api"
+	"github.com/coaxial/tizinger/notify"
+	"github.com/coaxial/tizinger/pipeline"
+	"github.com/coaxial/tizinger/scheduler"
+	"github.com/coaxial/tizinger/utils/config"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/metrics"
+	"github.com/coaxial/tizinger/utils/overrides"
+	"github.com/coaxial/tizinger/utils/state"
+)
+
+// serve runs the jobs from the config file on their schedule until
+// interrupted.
+func serve(args []string) (exitCode int) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "file defining the jobs to run")
+	statePath := flags.String("state", "", "file recording the progress of each job (default from -config)")
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath, true)
+	if err != nil {
+		return 1
+	}
+	if *statePath == "" {
+		*statePath = cfg.Settings.StateFile
+	}
+	store, err := state.Open(*statePath)
+	if err != nil {
+		logger.Error("error loading state", "err", err)
+		return 1
+	}
+	pipeline.UseState(store)
+	corrections, err := overrides.Open(cfg.Settings.OverridesFile)
+	if err != nil {
+		logger.Error("error loading overrides", "err", err)
+		return 1
+	}
+	pipeline.UseOverrides(corrections)
+	_, err = loadISRC(cfg)
+	if err != nil {
+		return 1
+	}
+	// The scheduled runs send notifications, and the API records them as
+	// well, for the web UI to list them.
+	scheduled := notified(cfg, pipeline.RunReport)
+	runner := func(job config.Job, from time.Time, to time.Time) error {
+		_, err := scheduled(job, from, to)
+		return err
+	}
+	apiServer := api.New(cfg.Jobs, pipeline.RunReport, corrections)
+	if cfg.Settings.APIAddress != "" {
+		runner = apiServer.Record(scheduled)
+	}
+	s, err := scheduler.New(cfg.Jobs, runner, store)
+	if err != nil {
+		logger.Error("error scheduling jobs", "err", err)
+		return 1
+	}
+
+	// Let the run in progress, if any, finish before exiting on SIGINT or
+	// SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	muxes := make(map[string]*http.ServeMux)
+	handle := func(addr string, pattern string, h http.Handler) {
+		if addr == "" {
+			return
+		}
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		muxes[addr].Handle(pattern, h)
+	}
+	handle(cfg.Settings.MetricsAddress, "/metrics", metrics.Handler())
+	handle(cfg.Settings.APIAddress, "/", apiServer)
+	var servers []*http.Server
+	for addr, mux := range muxes {
+		servers = append(servers, serveHTTP(addr, mux))
+	}
+	s.Run(ctx)
+	// Stop accepting requests, so that no run is triggered through the API
+	// while waiting for those in progress to finish.
+	for _, srv := range servers {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		err := srv.Shutdown(shutdownCtx)
+		cancel()
+		if err != nil {
+			logger.Error("error shutting down HTTP server", "address", srv.Addr, "err", err)
+			srv.Close()
+		}
+	}
+	apiServer.Wait()
+	logger.Info("shut down")
+	return 0
+}
+
+// shutdownTimeout is how long the HTTP servers get to answer the requests in
+// progress when shutting down.
+const shutdownTimeout = 10 * time.Second
+
+// serveHTTP serves h on addr in the background, e.g. the metrics or the API.
+func serveHTTP(addr string, h http.Handler) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: h}
+	go func() {
+		logger.Info("serving HTTP", "address", addr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("error serving HTTP", "address", addr, "err", err)
+		}
+	}()
+	return srv
+}
+
+// notified returns a function running jobs with run, and sending
+// notifications about their outcome as set up in cfg.
+func notified(cfg config.Config, run api.Runner) api.Runner {
+	return func(job config.Job, from time.Time, to time.Time) (pipeline.Report, error) {
+		report, err := run(job, from, to)
+		notify.Send(cfg.Notify, outcome(job.Name, report, err))
+		return report, err
+	}
+}
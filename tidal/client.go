@@ -4,32 +4,113 @@ package tidal
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"regexp"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coaxial/tizinger/extractor"
 	"github.com/coaxial/tizinger/utils/credentials"
 	"github.com/coaxial/tizinger/utils/helpers"
 	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/metrics"
+	"github.com/coaxial/tizinger/utils/overrides"
+	"github.com/coaxial/tizinger/utils/state"
 )
 
 // APIClient implements exporter.Client.
-type APIClient struct{}
+type APIClient struct {
+	// DryRun only searches for the tracks and prints the playlists that
+	// would be created instead of creating them.
+	DryRun bool
+	// SkipExisting doesn't create the playlist for accounts which already
+	// have a playlist with the same name.
+	SkipExisting bool
+	// State records the progress of each playlist per account, so that
+	// running the same Job again completes the playlist if the previous
+	// run failed part way, rather than creating a duplicate. It is
+	// optional.
+	State *state.Store
+	// Job identifies the job the playlists are created for in State.
+	Job string
+	// Station is the FIP station the tracks come from. Accounts restricted
+	// to other stations don't get the playlist.
+	Station string
+	// Accounts are the names or groups of the accounts to create the
+	// playlist on, all of them if empty. Accounts subscribed to other jobs
+	// or stations are left out either way.
+	Accounts []string
+	// Summary, when set, is filled with what CreatePlaylist did, e.g. to
+	// notify about it.
+	Summary *Summary
+	// Overrides, when set, decide what the tracks they are for match
+	// instead of searching for them.
+	Overrides *overrides.Store
+	// DedupePlaylists and DedupeDays leave out the tracks already added to
+	// the Job's last DedupePlaylists playlists on the same account, or to
+	// those of the DedupeDays days before the new playlist's window. They
+	// need State, whose runs are the history, and tracks with air times.
+	DedupePlaylists int
+	DedupeDays      int
+	// Discovery leaves out the tracks already in the account's favorites
+	// or playlists, for playlists of songs new to the account.
+	Discovery bool
+	// FavoriteTracks and FavoriteArtists add the matched tracks and their
+	// artists to the accounts' favorites, besides creating the playlist.
+	// With FavoriteMinPlays, only those of artists which aired at least
+	// that many times are added.
+	FavoriteTracks   bool
+	FavoriteArtists  bool
+	FavoriteMinPlays int
+}
+
+// Summary is what CreatePlaylist did.
+type Summary struct {
+	// Matched and Unmatched count the tracks found and not found on Tidal.
+	Matched   int
+	Unmatched int
+	// Playlists are the URLs of the playlists created, by account name.
+	Playlists map[string]string
+	// Matches are what each track matched on Tidal.
+	Matches []Match
+}
+
+// playlistURL is where playlists can be opened, followed by their UUID.
+const playlistURL = "https://tidal.com/browse/playlist/"
+
+// tidalAccounts returns the Tidal accounts from the credentials. It can be
+// overridden when testing.
+var tidalAccounts = credentials.Tidal
+
+// dryRunOutput is where dry runs print the would-be playlists. It can be
+// overridden when testing.
+var dryRunOutput io.Writer = os.Stdout
 
 // baseURL can be overridden while testing to avoid live calls.
 var baseURL = "https://api.tidalhifi.com/v1"
 
+// baseURLv2 is the newer API's location, for the few calls the first one
+// doesn't have. It can be overridden while testing as well.
+var baseURLv2 = "https://api.tidal.com/v2"
+
 // jar is the cookie jar for the tidal client.
 var jar http.CookieJar
 
 // tidalClient is the client making requests to the Tidal API. It is defined
-// here so that this client instance is reused.
-var tidalClient = &http.Client{}
+// here so that this client instance is reused. Its requests are recorded in
+// the metrics.
+var tidalClient = &http.Client{Transport: metrics.Transport("tidal", nil)}
+
+// SetTimeout bounds how long requests to the API can take, 0 means no
+// timeout.
+func SetTimeout(d time.Duration) {
+	tidalClient.Timeout = d
+}
 
 // userData represents the data returned upon logging in that is necessary to
 // compose authenticated requests.
@@ -42,6 +123,12 @@ type userData struct {
 // tidalUserData is the instance holding user data after logging in.
 var tidalUserData userData
 
+// session serializes the use of the Tidal API: the token and the logged in
+// user are package state, so exports and searches made concurrently, e.g.
+// from the API while a scheduled run exports, would race for them. The
+// exported functions hold it, those they call don't.
+var session sync.Mutex
+
 // addTidalData adds the necessary headers to the request
 func addTidalData(req *http.Request) {
 	// Set a default country code, to be overridden by the user's value if
@@ -72,66 +159,453 @@ func addTidalData(req *http.Request) {
 
 // CreatePlaylist creates playlists on Tidal.
 func (ac APIClient) CreatePlaylist(name string, tracks extractor.Tracklist) (err error) {
+	session.Lock()
+	defer session.Unlock()
 	err = setToken()
 	if err != nil {
-		logger.Error.Printf("could not fetch tokens: %v", err)
+		logger.Error("could not fetch tokens", "err", err)
 		return err
 	}
 
 	// The credentials file can have more than one Tidal account.
-	accounts, err := credentials.Tidal()
+	accounts, err := ac.targets()
 	if err != nil {
-		logger.Error.Printf("error fetching Tidal account information: %v", err)
+		logger.Error("error fetching Tidal account information", "err", err)
+		return err
+	}
+	if len(accounts) == 0 {
+		logger.Info("no Tidal account wants the playlist, skipping", "playlist", name)
 		return err
 	}
 
+	matches, err := ac.matchAll(tracks)
+	if err != nil {
+		return err
+	}
 	var trackIDs []int
-	// search for tracks now, it only need to be done once for all users as
-	// the track IDs on Tidal don't depend on the user. This makes things
-	// a bit faster when creating playlists on several accounts.
-	for i, t := range tracks {
-		logger.Info.Printf("searching for track %d/%d: %q by %q", i+1, len(tracks), t.Title, t.Artist)
-		ID, err := search(t.Title, t.Artist, t.Album)
-		if err != nil {
-			logger.Error.Printf("error when searching for track %q %q %q", t.Title, t.Artist, t.Album)
-			return err
-		}
+	for _, m := range matches {
+		result := "unmatched"
 		// -1 means track not found.
-		if ID != -1 {
-			trackIDs = append(trackIDs, ID)
+		if m.TidalID != -1 {
+			trackIDs = append(trackIDs, m.TidalID)
+			result = "matched"
+		}
+		// Dry runs are left out of the metrics.
+		if !ac.DryRun {
+			metrics.TracksSearched.Inc("tidal", result)
 		}
 	}
+	if ac.Summary != nil {
+		ac.Summary.Matched, ac.Summary.Unmatched = len(trackIDs), len(matches)-len(trackIDs)
+		ac.Summary.Matches = matches
+	}
+
+	if ac.DryRun {
+		for i, a := range accounts {
+			logger.Info("dry run", "account", a.Name, "index", i+1, "accounts", len(accounts))
+			if ac.Discovery {
+				err = login(a.Username, a.Password)
+				if err != nil {
+					logger.Error("error logging in", "account", a.Name, "err", err)
+					return err
+				}
+			}
+			run := state.Run{Job: ac.Job, Account: a.Username, Playlist: name}
+			run.From, run.To = airedBetween(tracks)
+			var reasons map[int]string
+			reasons, err = ac.leftOut(a, run)
+			if err != nil {
+				return err
+			}
+			printPlaylist(dryRunOutput, a.Name, name, matches, reasons)
+		}
+		return err
+	}
 
+	favoriteTracks, favoriteArtists := ac.favorites(matches)
 	// There can be more than one account, playlists are created and
 	// populated for each.
 	for i, a := range accounts {
-		logger.Info.Printf("processing account %q (%d/%d)", a.Username, i+1, len(accounts))
-		err = login(a.Username, a.Password)
-		if err != nil {
-			logger.Error.Printf("error logging in: %v", err)
+		logger.Info("processing account", "account", a.Name, "index", i+1, "accounts", len(accounts))
+		var skipped bool
+		skipped, err = ac.exportTo(a, name, trackIDs, tracks)
+		switch {
+		case err != nil:
+			metrics.Playlists.Inc("tidal", "failure")
 			return err
+		case skipped:
+			metrics.Playlists.Inc("tidal", "skipped")
+		default:
+			metrics.Playlists.Inc("tidal", "success")
+		}
+		if len(favoriteTracks) > 0 || len(favoriteArtists) > 0 {
+			err = addFavorites(a, favoriteTracks, favoriteArtists)
+			if err != nil {
+				return err
+			}
+		}
+		logger.Info("done with account", "account", a.Name, "index", i+1, "accounts", len(accounts))
+	}
+	return err
+}
+
+// targets returns the accounts the playlist is for: those the client targets
+// which subscribe to its job and station.
+func (ac APIClient) targets() (accounts []credentials.TidalAccount, err error) {
+	all, err := tidalAccounts()
+	if err != nil {
+		return accounts, err
+	}
+	for _, a := range all {
+		switch {
+		case !a.Targeted(a.Name, ac.Accounts):
+			logger.Trace("account isn't targeted, skipping", "account", a.Name, "targets", ac.Accounts)
+		case !a.Wants(ac.Job, ac.Station):
+			logger.Info("account doesn't subscribe to the job or station, skipping", "account", a.Name, "jobs", a.Jobs, "stations", a.Stations)
+		default:
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, err
+}
+
+// exportTo creates the playlist name with the tracks trackIDs on account a.
+// When the client has a State, a playlist created by a previous, unfinished,
+// run is completed instead of creating a new one. tracks are the source tracks
+// the IDs were matched from, for recording the run's window. skipped is true
+// when the account already had the playlist, so that nothing was done.
+func (ac APIClient) exportTo(a credentials.TidalAccount, name string, trackIDs []int, tracks extractor.Tracklist) (skipped bool, err error) {
+	log := logger.With("account", a.Name, "playlist", name)
+	run := state.Run{Job: ac.Job, Account: a.Username, Playlist: name}
+	run.From, run.To = airedBetween(tracks)
+	resumed := false
+	if ac.State != nil {
+		var recorded state.Run
+		recorded, resumed = ac.State.Run(run)
+		if resumed && recorded.Done {
+			log.Info("playlist was already created, skipping")
+			return true, err
+		}
+		if resumed {
+			run = recorded
+		}
+	}
+
+	err = login(a.Username, a.Password)
+	if err != nil {
+		log.Error("error logging in", "err", err)
+		return false, err
+	}
+	if ac.SkipExisting && !resumed {
+		exists, err := playlistExists(tidalUserData.UserID, name)
+		if err != nil {
+			log.Error("error checking for existing playlists", "err", err)
+			return false, err
 		}
-		playlistID, err := createEmptyPlaylist(tidalUserData.UserID, name, "")
+		if exists {
+			log.Info("account already has a playlist with the same name, skipping")
+			return true, err
+		}
+	}
+
+	playlistID := run.PlaylistUUID
+	if playlistID != "" {
+		log.Info("resuming playlist", "uuid", playlistID, "already_added", len(run.TracksAdded))
+	} else {
+		playlistID, err = createEmptyPlaylist(tidalUserData.UserID, name, "")
 		if err != nil {
-			logger.Error.Printf("error creating empty playlist: %v", err)
-			return err
+			log.Error("error creating empty playlist", "err", err)
+			return false, err
 		}
-		countAdded, err := populatePlaylist(trackIDs, playlistID)
+		run.PlaylistUUID = playlistID
+		err = ac.saveRun(run)
 		if err != nil {
-			logger.Error.Printf("error populating playlist: %v", err)
-			return err
+			return false, err
+		}
+	}
+
+	reasons, err := ac.leftOut(a, run)
+	if err != nil {
+		return false, err
+	}
+	added := make(map[int]bool)
+	for _, ID := range run.TracksAdded {
+		added[ID] = true
+	}
+	var remaining []int
+	deduped, discovered := 0, 0
+	for _, ID := range trackIDs {
+		switch {
+		case added[ID]:
+		case reasons[ID] == inPreviousPlaylists:
+			deduped++
+		case reasons[ID] == inLibrary:
+			discovered++
+		default:
+			remaining = append(remaining, ID)
+		}
+	}
+	if deduped > 0 {
+		log.Info("left out tracks already in previous playlists", "deduped", deduped)
+	}
+	if discovered > 0 {
+		log.Info("left out tracks already in the account's library", "known", discovered)
+	}
+	countAdded, err := populatePlaylist(remaining, playlistID, func(ID int) error {
+		run.TracksAdded = append(run.TracksAdded, ID)
+		addToLibrary(tidalUserData.UserID, ID)
+		return ac.saveRun(run)
+	})
+	if err != nil {
+		log.Error("error populating playlist", "err", err)
+		return false, err
+	}
+	log.Info("added tracks to playlist", "uuid", playlistID, "added", countAdded, "tracks", len(remaining))
+	if a.Public {
+		err = setPublic(playlistID)
+		if err != nil {
+			log.Error("error making playlist public", "uuid", playlistID, "err", err)
+			return false, err
+		}
+	}
+	if ac.Summary != nil {
+		if ac.Summary.Playlists == nil {
+			ac.Summary.Playlists = make(map[string]string)
+		}
+		ac.Summary.Playlists[a.Name] = playlistURL + playlistID
+	}
+	run.Done = true
+	return false, ac.saveRun(run)
+}
+
+// The reasons leftOut gives for leaving tracks out of a playlist.
+const (
+	inPreviousPlaylists = "already in a previous playlist"
+	inLibrary           = "already in the account's library"
+)
+
+// leftOut returns why tracks are left out of the playlist of run on account
+// a, by Tidal ID: with dedupe for being in the Job's previous playlists, and
+// with Discovery for being in the account's library. Discovery needs to be
+// logged in as a.
+func (ac APIClient) leftOut(a credentials.TidalAccount, run state.Run) (reasons map[int]string, err error) {
+	reasons = make(map[int]string)
+	if ac.Discovery {
+		known, err := library(tidalUserData.UserID)
+		if err != nil {
+			logger.Error("error listing the account's library", "account", a.Name, "err", err)
+			return reasons, err
+		}
+		for ID := range known {
+			reasons[ID] = inLibrary
+		}
+	}
+	for ID := range ac.seen(a.Username, run) {
+		reasons[ID] = inPreviousPlaylists
+	}
+	return reasons, err
+}
+
+// seen returns the IDs of the tracks added to the Job's playlists on account
+// that the dedupe settings select, among those whose window started before
+// run's.
+func (ac APIClient) seen(account string, run state.Run) (IDs map[int]bool) {
+	IDs = make(map[int]bool)
+	if ac.State == nil || (ac.DedupePlaylists == 0 && ac.DedupeDays == 0) {
+		return IDs
+	}
+	if run.From == 0 {
+		logger.Warning("the tracks' air times are unknown, not deduping", "account", account, "playlist", run.Playlist)
+		return IDs
+	}
+	since := run.From - int64(ac.DedupeDays)*int64(24*time.Hour/time.Second)
+	previous := 0
+	for _, r := range ac.State.Runs(ac.Job, account) {
+		if r.From == 0 || r.From >= run.From {
+			continue
+		}
+		previous++
+		if previous > ac.DedupePlaylists && (ac.DedupeDays == 0 || r.To <= since) {
+			// Runs are sorted by window, the older ones won't be
+			// selected either.
+			break
 		}
-		logger.Info.Printf("added %d/%d tracks to playlist %q", countAdded, len(trackIDs), playlistID)
-		logger.Info.Printf("done with account %q (%d/%d)", a.Username, i+1, len(accounts))
+		for _, ID := range r.TracksAdded {
+			IDs[ID] = true
+		}
+	}
+	return IDs
+}
+
+// saveRun records the run's progress if the client has a State.
+func (ac APIClient) saveRun(run state.Run) (err error) {
+	if ac.State == nil {
+		return err
+	}
+	err = ac.State.SaveRun(run)
+	if err != nil {
+		logger.Error("error saving playlist progress", "playlist", run.Playlist, "account", run.Account, "err", err)
 	}
 	return err
 }
 
+// airedBetween returns the window the tracks aired in, as Unix epochs in
+// seconds. Both are 0 if the air times are unknown.
+func airedBetween(tracks extractor.Tracklist) (from int64, to int64) {
+	for _, t := range tracks {
+		if t.StartTime != 0 && (from == 0 || t.StartTime < from) {
+			from = t.StartTime
+		}
+		if t.EndTime > to {
+			to = t.EndTime
+		}
+	}
+	return from, to
+}
+
+// Match is the outcome of looking up a source track on Tidal.
+type Match struct {
+	Track extractor.Track
+	// TidalID is the matching Tidal track's ID, -1 if none was found.
+	TidalID int
+	// TidalTitle and TidalArtist describe the matching Tidal track, they
+	// are empty if none was found.
+	TidalTitle  string
+	TidalArtist string
+	// TidalArtistID is the matching Tidal track's main artist, 0 if
+	// unknown.
+	TidalArtistID int
+	// Confidence is how similar the matching track is to the source track,
+	// from 0 to 1.
+	Confidence float64
+	// Candidates are the tracks the search found, the most similar first.
+	Candidates []Candidate
+	// Strategy is the name of the search strategy which found the match.
+	Strategy string
+	// Override is set when an override, or the Tidal ID the track came
+	// with, decided the match rather than a search.
+	Override bool
+}
+
+// searchTTL is how long the tracks found on Tidal are cached for before
+// being searched for again.
+const searchTTL = time.Hour
+
+// searchEntry is a cached match.
+type searchEntry struct {
+	match    Match
+	searched time.Time
+}
+
+// searchCache remembers the Tidal IDs recently found, so that exporting the
+// matches and creating the playlist don't search Tidal twice for the same
+// tracks. It is keyed by title, artist, album and ISRC only since the same
+// track can air several times. Tracks not found aren't cached, so that they
+// are searched for again on the next run. It is guarded by session.
+var searchCache = map[extractor.Track]searchEntry{}
+
+// Search looks up every track on Tidal and returns what they matched, in
+// the same order as tracks.
+func (ac APIClient) Search(tracks extractor.Tracklist) (matches []Match, err error) {
+	session.Lock()
+	defer session.Unlock()
+	return ac.matchAll(tracks)
+}
+
+// matchAll is Search, for callers holding session.
+func (ac APIClient) matchAll(tracks extractor.Tracklist) (matches []Match, err error) {
+	if tidalToken == "" {
+		err = setToken()
+		if err != nil {
+			logger.Error("could not fetch tokens", "err", err)
+			return matches, err
+		}
+	}
+
+	for key, e := range searchCache {
+		if time.Since(e.searched) >= searchTTL {
+			delete(searchCache, key)
+		}
+	}
+
+	// search for tracks now, it only need to be done once for all users as
+	// the track IDs on Tidal don't depend on the user. This makes things
+	// a bit faster when creating playlists on several accounts.
+	for i, t := range tracks {
+		if t.TidalID > 0 {
+			logger.Info("track has a Tidal ID already", "index", i+1, "title", t.Title, "artist", t.Artist, "id", t.TidalID)
+			matches = append(matches, Match{Track: t, TidalID: t.TidalID, Confidence: 1, Override: true})
+			continue
+		}
+		if ac.Overrides != nil {
+			if o, ok := ac.Overrides.Lookup(t); ok {
+				logger.Info("track is overridden", "index", i+1, "title", t.Title, "artist", t.Artist, "id", o.TidalID, "skip", o.Skip)
+				m := Match{Track: t, TidalID: o.TidalID, Confidence: 1, Override: true}
+				if o.Skip {
+					m.TidalID = trackNotFound
+				}
+				matches = append(matches, m)
+				continue
+			}
+		}
+		key := extractor.Track{Title: t.Title, Artist: t.Artist, Album: t.Album, ISRC: t.ISRC}
+		e, ok := searchCache[key]
+		m := e.match
+		if !ok {
+			logger.Info("searching for track", "index", i+1, "tracks", len(tracks), "title", t.Title, "artist", t.Artist)
+			best, cs, strategy, err := lookup(key)
+			if err != nil {
+				logger.Error("error when searching for track", "index", i+1, "title", t.Title, "artist", t.Artist, "album", t.Album, "err", err)
+				return matches, err
+			}
+			m = Match{TidalID: best.ID, TidalTitle: best.Title, TidalArtist: best.Artist, TidalArtistID: best.ArtistID, Confidence: best.Confidence, Candidates: cs, Strategy: strategy}
+			if m.TidalID != trackNotFound {
+				searchCache[key] = searchEntry{match: m, searched: time.Now()}
+			}
+		}
+		m.Track = t
+		matches = append(matches, m)
+	}
+	return matches, err
+}
+
+// printPlaylist writes the playlist that would be created for account, along
+// with what each track matched on Tidal, to w. leftOut has the reasons to
+// leave tracks out of the playlist, by Tidal ID.
+func printPlaylist(w io.Writer, account string, name string, matches []Match, leftOut map[int]string) {
+	seen := make(map[int]bool)
+	added := 0
+	var lines strings.Builder
+	for i, m := range matches {
+		fmt.Fprintf(&lines, "  %3d. %q by %q: ", i+1, m.Track.Title, m.Track.Artist)
+		switch {
+		case m.Override && m.TidalID == trackNotFound:
+			fmt.Fprintf(&lines, "skipped by an override\n")
+		case m.TidalID == trackNotFound:
+			fmt.Fprintf(&lines, "not found, skipped\n")
+		case seen[m.TidalID]:
+			fmt.Fprintf(&lines, "duplicate of Tidal track %d, skipped\n", m.TidalID)
+		case leftOut[m.TidalID] != "":
+			fmt.Fprintf(&lines, "Tidal track %d %s, skipped\n", m.TidalID, leftOut[m.TidalID])
+		case m.Override:
+			seen[m.TidalID] = true
+			added++
+			fmt.Fprintf(&lines, "Tidal track %d, from an override\n", m.TidalID)
+		default:
+			seen[m.TidalID] = true
+			added++
+			fmt.Fprintf(&lines, "Tidal track %d %q by %q, confidence %.2f\n", m.TidalID, m.TidalTitle, m.TidalArtist, m.Confidence)
+		}
+	}
+	fmt.Fprintf(w, "[dry run] playlist %q for account %q, %d/%d tracks would be added:\n", name, account, added, len(matches))
+	fmt.Fprint(w, lines.String())
+}
+
 // queryTidal prepares and sends queries to the Tidal API. uri is where to send
 // the request, payload is the JSON to send either in the body for
 // http.MethodGet or as a form for http.MethodPost. method is the HTTP method
 // to use, tidalJSON is a pointer to the struct to which the response will be
-// unmarshalled.
+// unmarshalled, or nil to ignore the response.
 func queryTidal(
 	uri string, // where to send the request
 	headers map[string]string, // extra headers besides the Tidal headers
@@ -140,10 +614,10 @@ func queryTidal(
 	method string, // HTTP method
 	tidalJSON interface{}, // variable to unmarshal the response in
 ) (err error) {
-	logger.Trace.Printf("preparing %q request to %q", method, uri)
+	logger.Trace("preparing request", "method", method, "uri", uri)
 	req, err := http.NewRequest(method, uri, strings.NewReader(payload.Encode()))
 	if err != nil {
-		logger.Error.Printf("error building request: %v", err)
+		logger.Error("error building request", "err", err)
 		return err
 	}
 	addTidalData(req)
@@ -164,62 +638,41 @@ func queryTidal(
 		req.Header.Add(k, v)
 	}
 
-	// Log request payload and query string for debugging
-	body, err := req.GetBody()
-	if err != nil {
-		logger.Error.Printf("error %v", err)
-		return err
-	}
-	debugBody, _ := ioutil.ReadAll(body)
-	debugyBodyString := string(debugBody)
-	qs := req.URL.RawQuery
-	h := req.Header
-	// Remove password from logs
-	re := regexp.MustCompile(`(?P<firstHalf>.*"password":")(?P<passwordValue>.*?)(?P<SecondHalf>".*)`)
-	debugyBodyString = re.ReplaceAllString(debugyBodyString, `$1<redacted>$3`)
-	logger.Trace.Printf("request: body: %#v", string(debugyBodyString))
-	logger.Trace.Printf("qs: %q", string(qs))
-	// Dump headers
-	var hstr strings.Builder
-	for k, v := range h {
-		// Don't log the session ID as it can be used to impersonate
-		// user
-		if k == "X-Tidal-SessionId" {
-			fmt.Fprintf(&hstr, `%q: %q, `, k, `<redacted>`)
-		} else {
-			fmt.Fprintf(&hstr, `%q: %q, `, k, v)
-		}
-	}
-	logger.Trace.Printf("headers: %s", hstr.String())
+	// The logger redacts the secrets in the form, query string and headers,
+	// such as the password, token and session ID.
+	logger.Trace("request", "method", method, "url", req.URL, "form", payload, "headers", req.Header)
 
-	logger.Info.Printf("sending %q request to %q", method, uri)
+	logger.Info("sending request", "method", method, "uri", uri)
 	// Using a global client so that we can reuse connections etc.
 	resp, err := tidalClient.Do(req)
 	if err != nil {
-		logger.Error.Printf("error making request: %v", err)
+		logger.Error("error making request", "err", err)
 		return err
 	}
 	// The Content-Length headers is sometimes missing and shows as -1
 	// length. This is up to the server and there isn't much that can be
 	// done about it.
-	logger.Info.Printf("received response %q, %d bytes", resp.Header.Get("Content-Type"), resp.ContentLength)
+	logger.Info("received response", "content_type", resp.Header.Get("Content-Type"), "bytes", resp.ContentLength)
 	contents, err := ioutil.ReadAll(resp.Body)
-	// The request succeeds only for HTTP 200 OK or HTTP 201 Created (for
-	// playlist creation)
-	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+	// The request succeeds only for HTTP 200 OK, HTTP 201 Created (for
+	// playlist creation) or HTTP 204 No Content (for updates)
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusNoContent {
 		defer resp.Body.Close()
 		if err != nil {
-			logger.Error.Printf("error reading response: %v", err)
+			logger.Error("error reading response", "err", err)
+			return err
+		}
+		if tidalJSON == nil {
 			return err
 		}
 		err = json.Unmarshal(contents, &tidalJSON)
 		if err != nil {
-			logger.Error.Printf("error unmarshalling response: %v", err)
+			logger.Error("error unmarshalling response", "err", err)
 			return err
 		}
 		return err
 	}
-	logger.Error.Printf("tidal API responded with HTTP %d: %q", resp.StatusCode, contents)
+	logger.Error("tidal API responded with an error", "status", resp.StatusCode, "body", logger.JSON(contents))
 	return fmt.Errorf("tidal API responded with HTTP %d: %q", resp.StatusCode, contents)
 }
 
@@ -240,40 +693,42 @@ func setToken() (err error) {
 		TokenPhone string `json:"token_phone"`
 	}
 
-	logger.Trace.Printf("getting tokens manifest at %q", manifestURL)
-	resp, err := http.Get(manifestURL)
-	logger.Trace.Printf(
-		"Received response %q, %d bytes",
-		resp.Header.Get("content-type"),
-		resp.ContentLength,
-	)
+	logger.Trace("getting tokens manifest", "url", manifestURL)
+	resp, err := tidalClient.Get(manifestURL)
 	if err != nil {
-		logger.Error.Printf("error fetching API tokens from %q: %v", manifestURL, err)
+		logger.Error("error fetching API tokens", "url", manifestURL, "err", err)
 		return err
 	}
+	logger.Trace(
+		"received response",
+		"content_type", resp.Header.Get("content-type"),
+		"bytes", resp.ContentLength,
+	)
 
 	tokens, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		logger.Error.Printf("error reading response: %v", err)
+		logger.Error("error reading response", "err", err)
 		return err
 	}
 
 	var JSONTokens tokensResponse
 	err = json.Unmarshal(tokens, &JSONTokens)
 	if err != nil {
-		logger.Error.Printf("error unmarshalling token: %v", err)
+		logger.Error("error unmarshalling token", "err", err)
 		return err
 	}
 	// TokenPhone is probably equally good.
 	tidalToken = JSONTokens.Token
-	logger.Info.Printf("successfully set API token to %q", tidalToken)
+	logger.AddSecret(tidalToken)
+	logger.Info("successfully set API token")
 	return err
 }
 
 // login performs a login with the Tidal API for a given username and password.
 func login(username string, password string) (err error) {
-	logger.Trace.Printf("preparing to log user %q in", username)
+	logger.AddSecret(password)
+	logger.Trace("preparing to log user in", "username", username)
 	endpoint := "/login/username"
 	payload := url.Values{
 		"username": {username},
@@ -283,21 +738,19 @@ func login(username string, password string) (err error) {
 
 	err = queryTidal(uri, nil, nil, payload, http.MethodPost, &tidalUserData)
 	if err != nil {
-		logger.Error.Printf("error logging in: %q", err)
+		logger.Error("error logging in", "username", username, "err", err)
 		return err
 	}
 
-	logger.Info.Printf("successfully logged use %q in", username)
+	logger.AddSecret(tidalUserData.SessionID)
+	logger.Info("successfully logged user in", "username", username)
 	return err
 }
 
 // createEmptyPlaylist creates a new, empty playlist with the supplied title
 // and description for user userID on Tidal.
 func createEmptyPlaylist(userID int, title string, description string) (UUID string, err error) {
-	logger.Trace.Printf(
-		"creating playlist (title: %q, description: %q) for user %q",
-		title, description, strconv.Itoa(userID),
-	)
+	logger.Trace("creating playlist", "title", title, "description", description, "user_id", userID)
 	endpoint := "/users/" + strconv.Itoa(userID) + "/playlists"
 	payload := url.Values{
 		"title":       {title},
@@ -308,60 +761,187 @@ func createEmptyPlaylist(userID int, title string, description string) (UUID str
 	var playlistJSON playlist
 	err = queryTidal(uri, nil, nil, payload, http.MethodPost, &playlistJSON)
 	if err != nil {
-		logger.Error.Printf("error creating empty playlist: %q", err)
+		logger.Error("error creating empty playlist", "err", err)
 		return UUID, err
 	}
 
 	UUID = playlistJSON.UUID
 
-	logger.Info.Printf("successfully created empty playlist (%q)", UUID)
-	logger.Trace.Printf("playlist UUID: %q, title: %q, desc: %q", UUID, playlistJSON.Title, playlistJSON.Description)
+	logger.Info("successfully created empty playlist", "uuid", UUID)
+	logger.Trace("created playlist", "uuid", UUID, "title", playlistJSON.Title, "description", playlistJSON.Description)
 
 	return UUID, err
 }
 
+// setPublic makes the playlist with playlistID public, so that anyone can
+// find and listen to it. The first API has no such call, and Tidal doesn't
+// document the second one: this is the endpoint its web player was seen
+// calling, which hasn't been checked against the live API and may change
+// without notice.
+func setPublic(playlistID string) (err error) {
+	uri := baseURLv2 + "/playlists/" + playlistID + "/set-public"
+	err = queryTidal(uri, nil, nil, nil, http.MethodPut, nil)
+	if err != nil {
+		logger.Error("error making playlist public", "uuid", playlistID, "err", err)
+		return err
+	}
+	logger.Info("playlist is now public", "uuid", playlistID)
+	return err
+}
+
+// trackNotFound is the Tidal ID used for tracks without a match.
+const trackNotFound = -1
+
+// playlistExists checks whether user userID already has a playlist titled
+// title on Tidal.
+func playlistExists(userID int, title string) (exists bool, err error) {
+	endpoint := "/users/" + strconv.Itoa(userID) + "/playlists"
+	uri := baseURL + endpoint
+	const pageSize = 50
+
+	logger.Trace("looking for playlist among the user's playlists", "title", title, "user_id", userID)
+	// The playlists are paginated, go through the pages until the
+	// playlist is found or there are no more pages.
+	for offset := 0; ; offset += pageSize {
+		query := map[string]string{
+			"limit":  strconv.Itoa(pageSize),
+			"offset": strconv.Itoa(offset),
+		}
+		var playlistsJSON playlistsResponse
+		err = queryTidal(uri, nil, query, nil, http.MethodGet, &playlistsJSON)
+		if err != nil {
+			logger.Error("error listing playlists", "err", err)
+			return exists, err
+		}
+		for _, p := range playlistsJSON.Playlists {
+			if p.Title == title {
+				logger.Trace("found playlist", "title", title, "uuid", p.UUID)
+				return true, err
+			}
+		}
+		if len(playlistsJSON.Playlists) == 0 || offset+pageSize >= playlistsJSON.TotalNumberOfItems {
+			return exists, err
+		}
+	}
+}
+
 // search will search for "<track> <artist>" on Tidal and return the track's
 // Tidal ID. The ID is -1 if there are no results for that search.
 func search(track string, artist string, album string) (trackID int, err error) {
-	const trackNotFound = -1
-	trackID = trackNotFound
+	best, _, _, err := lookup(extractor.Track{Title: track, Artist: artist, Album: album})
+	return best.ID, err
+}
+
+// lookup searches for source on Tidal with each of the strategies in turn,
+// until one finds a confident match. It returns the best candidate found,
+// along with the candidates and the name of the strategy which found it. The
+// best candidate's ID is -1 if no strategy found anything at least
+// minMatchConfidence. A strategy which errors is skipped, lookup only errors
+// if every strategy it tried did.
+func lookup(source extractor.Track) (best Candidate, cs []Candidate, strategy string, err error) {
+	best.ID = trackNotFound
+	logger.Info("search for track", "title", source.Title, "artist", source.Artist, "album", source.Album)
+	searched := make(map[string]bool)
+	succeeded := false
+	for _, s := range strategies {
+		var found []Candidate
+		var serr error
+		if s.query != nil {
+			query := s.query(source)
+			// Skip the strategies searching for what was already
+			// searched for, e.g. the primary artist of a single
+			// artist.
+			if query == "" || searched[query] {
+				continue
+			}
+			searched[query] = true
+			found, serr = findCandidates(source, query)
+		} else {
+			if s.applies != nil && !s.applies(source) {
+				continue
+			}
+			found, serr = s.find(source)
+		}
+		if serr != nil {
+			logger.Error("error looking for track, trying the next strategy", "title", source.Title, "artist", source.Artist, "strategy", s.name, "err", serr)
+			err = serr
+			continue
+		}
+		succeeded = true
+		if len(found) == 0 {
+			logger.Trace("strategy found nothing", "strategy", s.name)
+			continue
+		}
+		if best.ID == trackNotFound || found[0].Confidence > best.Confidence {
+			best, cs, strategy = found[0], found, s.name
+		}
+		if best.Confidence >= minConfidence {
+			break
+		}
+	}
+	if succeeded {
+		err = nil
+	}
+	if err != nil {
+		return best, cs, strategy, err
+	}
+	// Check if any strategy found matches.
+	if best.ID == trackNotFound {
+		logger.Warning("no matching track found", "title", source.Title, "artist", source.Artist)
+		return best, cs, strategy, err
+	}
+	if best.Confidence < minMatchConfidence {
+		logger.Warning("no confident enough match found", "title", source.Title, "artist", source.Artist, "id", best.ID, "confidence", best.Confidence, "strategy", strategy)
+		return Candidate{ID: trackNotFound}, cs, strategy, err
+	}
+	logger.Info("found matching track", "id", best.ID, "confidence", best.Confidence, "strategy", strategy, "candidates", len(cs))
+	return best, cs, strategy, err
+}
+
+// Candidates searches Tidal for query and returns the results as candidates
+// for source, the most similar first. It is for searching manually when the
+// automatic search didn't find the right track.
+func Candidates(source extractor.Track, query string) (cs []Candidate, err error) {
+	session.Lock()
+	defer session.Unlock()
+	if tidalToken == "" {
+		err = setToken()
+		if err != nil {
+			logger.Error("could not fetch tokens", "err", err)
+			return cs, err
+		}
+	}
+	return findCandidates(source, query)
+}
+
+// findCandidates searches Tidal for query and returns the results as
+// candidates for source.
+func findCandidates(source extractor.Track, query string) (cs []Candidate, err error) {
 	endpoint := "/search/tracks"
 	uri := baseURL + endpoint
-	searchTerms := fmt.Sprintf("%s %s", track, artist)
 	payload := url.Values{
-		"limit":               {"1"}, // We're only interested in the first match
+		"limit":               {strconv.Itoa(maxCandidates)},
 		"offset":              {"0"},
 		"types":               {"TRACKS"}, // Only search for tracks
 		"includeContributors": {"true"},   // Not sure what this is, but the Tidal client apps have it set to true
 	}
-	query := map[string]string{"query": searchTerms}
 	var searchJSON searchResponse
-
-	logger.Info.Printf("search for track %q from artist %q on album %q", track, artist, album)
-	err = queryTidal(uri, nil, query, payload, http.MethodGet, &searchJSON)
+	err = queryTidal(uri, nil, map[string]string{"query": query}, payload, http.MethodGet, &searchJSON)
 	if err != nil {
-		logger.Error.Printf("error looking for track %q: %v", track+" "+artist, err)
-		return trackID, err
+		return cs, err
 	}
-	// Check if the request returned any matches.
-	if len(searchJSON.Results) == 0 {
-		logger.Warning.Printf("no matching track found for track %q", track+" "+artist)
-		return trackID, err
-	}
-	trackID = searchJSON.Results[0].ID
-	logger.Info.Printf("found matching track with ID %q", trackID)
-	return trackID, err
+	return candidates(source, searchJSON.Results), err
 }
 
 // populatePlaylist adds the tracks with trackID to the playlist with
-// playlistID.
-func populatePlaylist(trackIDs []int, playlistID string) (countAdded int, err error) {
+// playlistID. onAdded, if not nil, is called after each track is added.
+func populatePlaylist(trackIDs []int, playlistID string, onAdded func(ID int) error) (countAdded int, err error) {
 	// Remove duplicate tracks from list
 	uniqIDs := helpers.Uniq(trackIDs)
 	endpoint := "/playlists/" + playlistID + "/items"
 	uri := baseURL + endpoint
 
-	logger.Info.Printf("adding %d unique tracks to playlist %q", len(uniqIDs), playlistID)
+	logger.Info("adding unique tracks to playlist", "tracks", len(uniqIDs), "uuid", playlistID)
 	// TODO: There might be a way to add tracks in bulk since the payload
 	// key trackIds is plural, maybe as an array of trackIDs?
 	for i, ID := range uniqIDs {
@@ -370,10 +950,10 @@ func populatePlaylist(trackIDs []int, playlistID string) (countAdded int, err er
 		// every track add, so refresh it every time.
 		inmVal, err := getLastUpdated(playlistID)
 		if err != nil {
-			logger.Error.Printf("error updating last updated value: %v", err)
+			logger.Error("error updating last updated value", "err", err)
 			return countAdded, err
 		}
-		logger.Info.Printf("adding track %d (%d/%d)", ID, i+1, len(uniqIDs))
+		logger.Info("adding track", "id", ID, "index", i+1, "tracks", len(uniqIDs))
 		payload := url.Values{
 			"onArtifactNotFound": {"FAIL"},
 			"onDupes":            {"FAIL"},
@@ -385,13 +965,19 @@ func populatePlaylist(trackIDs []int, playlistID string) (countAdded int, err er
 		var populateResult populatePlaylistResult
 		err = queryTidal(uri, inmHeader, nil, payload, http.MethodPost, &populateResult)
 		if err != nil {
-			logger.Error.Printf("error adding track %d to playlist %q:%v", ID, playlistID, err)
+			logger.Error("error adding track to playlist", "id", ID, "uuid", playlistID, "err", err)
 			return countAdded, err
 		}
-		logger.Info.Printf("successfully added track %d (%d/%d) to playlist %q", ID, i+1, len(uniqIDs), playlistID)
+		logger.Info("successfully added track to playlist", "id", ID, "index", i+1, "tracks", len(uniqIDs), "uuid", playlistID)
 		countAdded++
+		if onAdded != nil {
+			err = onAdded(ID)
+			if err != nil {
+				return countAdded, err
+			}
+		}
 	}
-	logger.Info.Printf("successfully added %d/%d tracks to playlist %q", countAdded, len(uniqIDs), playlistID)
+	logger.Info("successfully added tracks to playlist", "added", countAdded, "tracks", len(uniqIDs), "uuid", playlistID)
 	return countAdded, err
 }
 
@@ -402,13 +988,13 @@ func getLastUpdated(playlistID string) (lu int64, err error) {
 	uri := baseURL + endpoint
 	var getPlaylistResult playlist
 
-	logger.Trace.Printf("getting last updated for playlist %q", playlistID)
+	logger.Trace("getting last updated", "uuid", playlistID)
 	err = queryTidal(uri, nil, nil, nil, http.MethodGet, &getPlaylistResult)
 	if err != nil {
-		logger.Error.Printf("error getting playlist metadata: %v", err)
+		logger.Error("error getting playlist metadata", "err", err)
 		return lu, err
 	}
 	lu = getPlaylistResult.LastUpdated.UnixNano() / int64(time.Millisecond)
-	logger.Trace.Printf("last updated at %q", lu)
+	logger.Trace("got last updated", "uuid", playlistID, "last_updated", lu)
 	return lu, err
 }
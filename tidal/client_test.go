@@ -1,11 +1,21 @@
 package tidal
 
 import (
+	"bytes"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/credentials"
+	"github.com/coaxial/tizinger/utils/logger"
 	"github.com/coaxial/tizinger/utils/mocks"
+	"github.com/coaxial/tizinger/utils/overrides"
+	"github.com/coaxial/tizinger/utils/state"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
@@ -49,6 +59,38 @@ func TestLogin(t *testing.T) {
 	assert.Equal(t, want, tidalUserData, "should have populated user data")
 }
 
+func TestLoginLogsNoSecrets(t *testing.T) {
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/login_response.json")
+		resp.WriteHeader(http.StatusOK)
+		resp.Write(JSON)
+	}
+	server := mocks.Server(http.HandlerFunc(handler))
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+	originalToken := tidalToken
+	tidalToken = "mock-api-token"
+	defer func() { tidalToken = originalToken }()
+	logger.AddSecret(tidalToken)
+	var out bytes.Buffer
+	logger.SetOutput(&out)
+	defer logger.SetOutput(os.Stdout)
+
+	err := login("mockuser@example.org", "leaky-password")
+	assert.Nil(t, err, "should not have errored")
+	// Another request, now with the session ID.
+	err = queryTidal(baseURL+"/users/1/playlists", nil, nil, nil, http.MethodGet, nil)
+	assert.Nil(t, err, "should not have errored")
+
+	logs := out.String()
+	for _, secret := range []string{"leaky-password", "mock-api-token", "mock-session-id", "mockuser@"} {
+		assert.NotContains(t, logs, secret, "shouldn't log secrets")
+	}
+	assert.Contains(t, logs, "m***@example.org", "should log the masked username")
+}
+
 func TestComposeHeadersNilSessionID(t *testing.T) {
 	want := []struct {
 		header string
@@ -119,7 +161,7 @@ func TestSearch(t *testing.T) {
 	baseURL = server.URL
 	defer func() { baseURL = originalURL }()
 
-	got, err := search("mock track", "mock artist", "mock album")
+	got, err := search("Appletree Boulevard", "Badly Drawn Boy", "Banana Skin Shoes")
 	want := 132616868
 
 	assert.Equal(t, want, got, "should have returned the track's ID")
@@ -140,7 +182,7 @@ func TestSearchNoResult(t *testing.T) {
 	baseURL = server.URL
 	defer func() { baseURL = originalURL }()
 
-	got, err := search("mock track", "mock artist", "mock album")
+	got, err := search("Appletree Boulevard", "Badly Drawn Boy", "Banana Skin Shoes")
 	want := -1
 
 	assert.Equal(t, want, got, "should not have found a track")
@@ -157,7 +199,7 @@ func TestQueryNok(t *testing.T) {
 	baseURL = server.URL
 	defer func() { baseURL = originalURL }()
 
-	got, err := search("mock track", "mock artist", "mock album")
+	got, err := search("Appletree Boulevard", "Badly Drawn Boy", "Banana Skin Shoes")
 
 	assert.Error(t, err, "should have errored")
 	assert.Equal(t, got, -1, "should not have found a track")
@@ -205,7 +247,7 @@ func TestPopulatePlaylist(t *testing.T) {
 	playlist := "mockUUID"
 
 	for _, test := range tests {
-		got, err := populatePlaylist(test.input, playlist)
+		got, err := populatePlaylist(test.input, playlist, nil)
 		assert.Nil(t, err, "should not have errored")
 		assert.Equal(t, test.want, got, test.msg)
 	}
@@ -230,3 +272,424 @@ func TestGetLastUpdated(t *testing.T) {
 	assert.Nil(t, err, "should not have errored")
 	assert.Equal(t, want, got, "should have returned the int64 timestamp")
 }
+
+func TestSearchTracks(t *testing.T) {
+	searches := 0
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/search/tracks" {
+			searches++
+		}
+		length, JSON := mocks.LoadFixture("../fixtures/tidal/search-track_result_response.json")
+		resp.WriteHeader(http.StatusOK)
+		resp.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		resp.Header().Set("Content-Length", strconv.Itoa(length))
+		resp.Write(JSON)
+	}
+	server := mocks.Server(http.HandlerFunc(handler))
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+	defer func() { searchCache = map[extractor.Track]searchEntry{} }()
+	tidalToken = "mock-token"
+	tracks := extractor.Tracklist{
+		{Title: "Appletree Boulevard", Artist: "Badly Drawn Boy", StartTime: 1},
+		{Title: "Appletree Boulevard", Artist: "Badly Drawn Boy", StartTime: 2},
+	}
+	var client APIClient
+
+	got, err := client.Search(tracks)
+	candidates := []Candidate{{ID: 132616868, Title: "Appletree Boulevard", Artist: "Badly Drawn Boy", Album: "Banana Skin Shoes", ArtistID: 9689, Confidence: 1}}
+	want := []Match{
+		{Track: tracks[0], TidalID: 132616868, TidalTitle: "Appletree Boulevard", TidalArtist: "Badly Drawn Boy", TidalArtistID: 9689, Confidence: 1, Candidates: candidates, Strategy: "full"},
+		{Track: tracks[1], TidalID: 132616868, TidalTitle: "Appletree Boulevard", TidalArtist: "Badly Drawn Boy", TidalArtistID: 9689, Confidence: 1, Candidates: candidates, Strategy: "full"},
+	}
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, want, got, "should have returned a match per track")
+	assert.Equal(t, 1, searches, "should only have searched once for the same track")
+}
+
+func TestSearchCache(t *testing.T) {
+	searches := 0
+	found := false
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		searches++
+		fixture := "../fixtures/tidal/search-track_noresult_response.json"
+		if found {
+			fixture = "../fixtures/tidal/search-track_result_response.json"
+		}
+		_, JSON := mocks.LoadFixture(fixture)
+		resp.Write(JSON)
+	}
+	server := mocks.Server(http.HandlerFunc(handler))
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+	defer func() { searchCache = map[extractor.Track]searchEntry{} }()
+	tidalToken = "mock-token"
+	tracks := extractor.Tracklist{{Title: "Appletree Boulevard", Artist: "Badly Drawn Boy"}}
+	var client APIClient
+
+	got, err := client.Search(tracks)
+	assert.Nil(t, err)
+	assert.Equal(t, trackNotFound, got[0].TidalID, "should not have found the track")
+	found = true
+	got, err = client.Search(tracks)
+	assert.Nil(t, err)
+	assert.Equal(t, 132616868, got[0].TidalID, "should not have cached the track not found")
+
+	searches = 0
+	client.Search(tracks)
+	assert.Equal(t, 0, searches, "should have cached the track found")
+	for key, e := range searchCache {
+		e.searched = e.searched.Add(-searchTTL)
+		searchCache[key] = e
+	}
+	client.Search(tracks)
+	assert.NotZero(t, searches, "should search again once the cache expired")
+}
+
+func TestSearchOverrides(t *testing.T) {
+	searches := 0
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/search/tracks" {
+			searches++
+		}
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/search-track_result_response.json")
+		resp.Write(JSON)
+	}
+	server := mocks.Server(http.HandlerFunc(handler))
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+	defer func() { searchCache = map[extractor.Track]searchEntry{} }()
+	tidalToken = "mock-token"
+	dir, err := ioutil.TempDir("", "tizinger-overrides")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := overrides.Open(filepath.Join(dir, "overrides.yaml"))
+	assert.Nil(t, err)
+	store.Set(overrides.Override{Title: "Sous le vent", Artist: "Garou", TidalID: 1337})
+	store.Set(overrides.Override{Title: "Jingle", Artist: "FIP", Skip: true})
+	tracks := extractor.Tracklist{
+		{Title: "sous le vent ", Artist: "Garou"},
+		{Title: "Jingle", Artist: "FIP"},
+		{Title: "Appletree Boulevard", Artist: "Badly Drawn Boy"},
+		{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", TidalID: 42},
+	}
+	client := APIClient{Overrides: store}
+
+	got, err := client.Search(tracks)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, Match{Track: tracks[0], TidalID: 1337, Confidence: 1, Override: true}, got[0], "should use the override's track")
+	assert.Equal(t, Match{Track: tracks[1], TidalID: -1, Confidence: 1, Override: true}, got[1], "should skip the track")
+	assert.Equal(t, 132616868, got[2].TidalID, "should search for tracks without overrides")
+	assert.Equal(t, Match{Track: tracks[3], TidalID: 42, Confidence: 1, Override: true}, got[3], "should use the track's own Tidal ID")
+	assert.Equal(t, 1, searches, "should only search for tracks without overrides")
+}
+
+func TestPrintPlaylist(t *testing.T) {
+	matches := []Match{
+		{Track: extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}, TidalID: 42, TidalTitle: "Scar Tissue", TidalArtist: "Red Hot Chili Peppers", Confidence: 1},
+		{Track: extractor.Track{Title: "Off the wall", Artist: "Jil Is Lucky"}, TidalID: -1},
+		{Track: extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}, TidalID: 42, TidalTitle: "Scar Tissue", TidalArtist: "Red Hot Chili Peppers", Confidence: 1},
+		{Track: extractor.Track{Title: "Sous le vent", Artist: "Garou"}, TidalID: 1337, Override: true},
+		{Track: extractor.Track{Title: "Jingle", Artist: "FIP"}, TidalID: -1, Override: true},
+		{Track: extractor.Track{Title: "Off the wall", Artist: "Jil Is Lucky"}, TidalID: 666, TidalTitle: "Off The Wall", TidalArtist: "Jil Is Lucky", Confidence: 1},
+	}
+	want := `[dry run] playlist "mock playlist" for account "mockuser@example.org", 2/6 tracks would be added:
+    1. "Scar tissue" by "Red Hot Chili Peppers": Tidal track 42 "Scar Tissue" by "Red Hot Chili Peppers", confidence 1.00
+    2. "Off the wall" by "Jil Is Lucky": not found, skipped
+    3. "Scar tissue" by "Red Hot Chili Peppers": duplicate of Tidal track 42, skipped
+    4. "Sous le vent" by "Garou": Tidal track 1337, from an override
+    5. "Jingle" by "FIP": skipped by an override
+    6. "Off the wall" by "Jil Is Lucky": Tidal track 666 already in the account's library, skipped
+`
+	var got bytes.Buffer
+
+	printPlaylist(&got, "mockuser@example.org", "mock playlist", matches, map[int]string{666: inLibrary})
+
+	assert.Equal(t, want, got.String(), "should have printed the match decisions")
+}
+
+func TestPlaylistExists(t *testing.T) {
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		length, JSON := mocks.LoadFixture("../fixtures/tidal/playlists-list_response.json")
+		resp.WriteHeader(http.StatusOK)
+		resp.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		resp.Header().Set("Content-Length", strconv.Itoa(length))
+		resp.Write(JSON)
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/users/133713373/playlists", handler)
+	server := mocks.Server(r)
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+
+	tests := []struct {
+		title string
+		want  bool
+		msg   string
+	}{
+		{"fipRock 2020-06-01", true, "should have found the playlist"},
+		{"fipRock 2020-06-02", false, "should not have found the playlist"},
+	}
+
+	for _, test := range tests {
+		got, err := playlistExists(133713373, test.title)
+		assert.Nil(t, err, "should not have errored")
+		assert.Equal(t, test.want, got, test.msg)
+	}
+}
+
+func TestExportToResumes(t *testing.T) {
+	created, added := 0, 0
+	fixture := func(path string, status int, count *int) http.HandlerFunc {
+		return func(resp http.ResponseWriter, req *http.Request) {
+			if count != nil {
+				*count++
+			}
+			length, JSON := mocks.LoadFixture(path)
+			resp.WriteHeader(status)
+			resp.Header().Set("Content-Type", "application/json;charset=UTF-8")
+			resp.Header().Set("Content-Length", strconv.Itoa(length))
+			resp.Write(JSON)
+		}
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/login/username", fixture("../fixtures/tidal/login_response.json", http.StatusOK, nil))
+	r.HandleFunc("/users/133713373/playlists", fixture("../fixtures/tidal/playlist-create_response.json", http.StatusCreated, &created))
+	r.HandleFunc("/playlists/mock-playlist-uuid/items", fixture("../fixtures/tidal/playlist-add_success_response.json", http.StatusOK, &added))
+	r.HandleFunc("/playlists/mock-playlist-uuid", fixture("../fixtures/tidal/playlist-get_response.json", http.StatusOK, nil))
+	server := mocks.Server(r)
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+	dir, err := ioutil.TempDir("", "tizinger-tidal")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := state.Open(filepath.Join(dir, "state.json"))
+	assert.Nil(t, err)
+	account := credentials.TidalAccount{Username: "mockuser@example.org", Password: "secret"}
+	store.SaveRun(state.Run{
+		Job:          "daily",
+		Account:      account.Username,
+		Playlist:     "mock playlist",
+		PlaylistUUID: "mock-playlist-uuid",
+		TracksAdded:  []int{42},
+	})
+	client := APIClient{State: store, Job: "daily"}
+
+	skipped, err := client.exportTo(account, "mock playlist", []int{42, 666, 1337}, nil)
+	assert.Nil(t, err, "should not have errored")
+	assert.False(t, skipped, "should not have skipped an unfinished playlist")
+	got, _ := store.Run(state.Run{Job: "daily", Account: account.Username, Playlist: "mock playlist"})
+
+	assert.Equal(t, 0, created, "should not have created another playlist")
+	assert.Equal(t, 2, added, "should only have added the remaining tracks")
+	assert.Equal(t, []int{42, 666, 1337}, got.TracksAdded, "should have recorded the added tracks")
+	assert.True(t, got.Done, "should have recorded the run as done")
+
+	skipped, err = client.exportTo(account, "mock playlist", []int{42, 666, 1337}, nil)
+	assert.Nil(t, err, "should not have errored")
+	assert.True(t, skipped, "should tell the completed playlist was skipped")
+	assert.Equal(t, 2, added, "should not touch a playlist that was completed")
+
+	_, err = client.exportTo(account, "other playlist", []int{42}, nil)
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, 1, created, "should have created a playlist for a new run")
+
+	later := extractor.Tracklist{{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", StartTime: 1592891806, EndTime: 1592892022}}
+	_, err = client.exportTo(account, "mock playlist", []int{42}, later)
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, 2, created, "should have created a playlist for another window, even if it is named the same")
+}
+
+func TestSeen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-tidal")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := state.Open(filepath.Join(dir, "state.json"))
+	assert.Nil(t, err)
+	day := int64(24 * 60 * 60)
+	account := "mockuser@example.org"
+	for _, r := range []state.Run{
+		{Job: "daily", Account: account, Playlist: "day 9", From: 9 * day, To: 10 * day, TracksAdded: []int{1}},
+		{Job: "daily", Account: account, Playlist: "day 8", From: 8 * day, To: 9 * day, TracksAdded: []int{2}},
+		{Job: "daily", Account: account, Playlist: "day 5", From: 5 * day, To: 6 * day, TracksAdded: []int{3}},
+		{Job: "daily", Account: account, Playlist: "day 11", From: 11 * day, To: 12 * day, TracksAdded: []int{4}},
+		{Job: "daily", Account: account, Playlist: "unknown", TracksAdded: []int{5}},
+		{Job: "other", Account: account, Playlist: "day 9", From: 9 * day, To: 10 * day, TracksAdded: []int{6}},
+		{Job: "daily", Account: "otheruser@example.org", Playlist: "day 9", From: 9 * day, To: 10 * day, TracksAdded: []int{7}},
+	} {
+		assert.Nil(t, store.SaveRun(r))
+	}
+	run := state.Run{Job: "daily", Account: account, Playlist: "day 10", From: 10 * day, To: 11 * day}
+
+	tests := []struct {
+		client APIClient
+		run    state.Run
+		want   map[int]bool
+		msg    string
+	}{
+		{APIClient{State: store, Job: "daily"}, run, map[int]bool{}, "should not dedupe by default"},
+		{APIClient{State: store, Job: "daily", DedupePlaylists: 1}, run, map[int]bool{1: true}, "should look at the last playlist"},
+		{APIClient{State: store, Job: "daily", DedupePlaylists: 3}, run, map[int]bool{1: true, 2: true, 3: true}, "should look at the last playlists however old"},
+		{APIClient{State: store, Job: "daily", DedupeDays: 1}, run, map[int]bool{1: true}, "should look at the playlists of the day before"},
+		{APIClient{State: store, Job: "daily", DedupeDays: 2}, run, map[int]bool{1: true, 2: true}, "should look at the playlists of the days before"},
+		{APIClient{State: store, Job: "daily", DedupePlaylists: 1, DedupeDays: 2}, run, map[int]bool{1: true, 2: true}, "should look at the playlists either selects"},
+		{APIClient{State: store, Job: "daily", DedupePlaylists: 1}, state.Run{Job: "daily", Account: account, Playlist: "unknown"}, map[int]bool{}, "should not dedupe when the air times are unknown"},
+		{APIClient{Job: "daily", DedupePlaylists: 1}, run, map[int]bool{}, "should not dedupe without a history"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, tc.client.seen(account, tc.run), tc.msg)
+	}
+}
+
+func TestLeftOut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-tidal")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := state.Open(filepath.Join(dir, "state.json"))
+	assert.Nil(t, err)
+	account := credentials.TidalAccount{Name: "me", Username: "mockuser@example.org"}
+	assert.Nil(t, store.SaveRun(state.Run{Job: "daily", Account: account.Username, Playlist: "day 1", From: 1, To: 2, TracksAdded: []int{42, 7}}))
+	run := state.Run{Job: "daily", Account: account.Username, Playlist: "day 2", From: 2, To: 3}
+	requests := 0
+	defer libraryServer(&requests)()
+	originalUserID := tidalUserData.UserID
+	tidalUserData.UserID = 133713373
+	defer func() { tidalUserData.UserID = originalUserID }()
+
+	got, err := APIClient{State: store, Job: "daily", DedupePlaylists: 1}.leftOut(account, run)
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, map[int]string{42: inPreviousPlaylists, 7: inPreviousPlaylists}, got, "should leave out the tracks in previous playlists")
+	assert.Equal(t, 0, requests, "should not list the library without discovery")
+
+	got, err = APIClient{State: store, Job: "daily", DedupePlaylists: 1, Discovery: true}.leftOut(account, run)
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, map[int]string{42: inPreviousPlaylists, 7: inPreviousPlaylists, 100: inLibrary, 666: inLibrary}, got, "should also leave out the tracks in the account's library")
+}
+
+func TestExportToPublic(t *testing.T) {
+	published := 0
+	fixture := func(path string, status int) http.HandlerFunc {
+		return func(resp http.ResponseWriter, req *http.Request) {
+			length, JSON := mocks.LoadFixture(path)
+			resp.WriteHeader(status)
+			resp.Header().Set("Content-Type", "application/json;charset=UTF-8")
+			resp.Header().Set("Content-Length", strconv.Itoa(length))
+			resp.Write(JSON)
+		}
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/login/username", fixture("../fixtures/tidal/login_response.json", http.StatusOK))
+	r.HandleFunc("/users/133713373/playlists", fixture("../fixtures/tidal/playlist-create_response.json", http.StatusCreated))
+	r.HandleFunc("/playlists/mock-playlist-uuid/items", fixture("../fixtures/tidal/playlist-add_success_response.json", http.StatusOK))
+	r.HandleFunc("/playlists/mock-playlist-uuid", fixture("../fixtures/tidal/playlist-get_response.json", http.StatusOK))
+	r.HandleFunc("/playlists/mock-playlist-uuid/set-public", func(resp http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, http.MethodPut, req.Method, "should PUT to make the playlist public")
+		published++
+		resp.WriteHeader(http.StatusNoContent)
+	})
+	server := mocks.Server(r)
+	defer server.Close()
+	originalURL, originalURLv2 := baseURL, baseURLv2
+	baseURL, baseURLv2 = server.URL, server.URL
+	defer func() { baseURL, baseURLv2 = originalURL, originalURLv2 }()
+	summary := &Summary{}
+	client := APIClient{Summary: summary}
+
+	private := credentials.TidalAccount{Name: "mockuser@example.org", Username: "mockuser@example.org", Password: "secret"}
+	_, err := client.exportTo(private, "mock playlist", []int{42}, nil)
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, 0, published, "should leave the playlist private by default")
+
+	public := credentials.TidalAccount{Name: "mockuser@example.org", Username: "mockuser@example.org", Password: "secret", Options: credentials.Options{Public: true}}
+	_, err = client.exportTo(public, "mock playlist", []int{42}, nil)
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, 1, published, "should make the playlist public")
+	assert.Equal(t, map[string]string{"mockuser@example.org": "https://tidal.com/browse/playlist/mock-playlist-uuid"}, summary.Playlists, "should sum up the playlists created")
+}
+
+func TestCreatePlaylistTargets(t *testing.T) {
+	searches := 0
+	r := mux.NewRouter()
+	r.HandleFunc("/tokens.json", func(resp http.ResponseWriter, req *http.Request) {
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/tokens.json")
+		resp.Write(JSON)
+	})
+	r.HandleFunc("/search/tracks", func(resp http.ResponseWriter, req *http.Request) {
+		searches++
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/search-track_result_response.json")
+		resp.Write(JSON)
+	})
+	r.HandleFunc("/search/artists", func(resp http.ResponseWriter, req *http.Request) {
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/search-track_noresult_response.json")
+		resp.Write(JSON)
+	})
+	created := 0
+	r.HandleFunc("/users/{id}/playlists", func(resp http.ResponseWriter, req *http.Request) {
+		created++
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/playlist-create_response.json")
+		resp.Write(JSON)
+	})
+	server := mocks.Server(r)
+	defer server.Close()
+	originalURL, originalManifestURL := baseURL, manifestURL
+	baseURL, manifestURL = server.URL, server.URL+"/tokens.json"
+	defer func() { baseURL, manifestURL = originalURL, originalManifestURL }()
+	defer func() { searchCache = map[extractor.Track]searchEntry{} }()
+	defer func() { tidalAccounts = credentials.Tidal }()
+	tidalAccounts = func() ([]credentials.TidalAccount, error) {
+		return []credentials.TidalAccount{
+			{Name: "me", Options: credentials.Options{Stations: []string{"fipRock"}}},
+			{Name: "colleague", Options: credentials.Options{Stations: []string{"fipJazz"}, Groups: []string{"jazz"}}},
+			{Name: "friend", Options: credentials.Options{Jobs: []string{"weekly"}, Groups: []string{"jazz"}}},
+			{Name: "everyone"},
+		}, nil
+	}
+	tracks := extractor.Tracklist{
+		{Title: "Appletree Boulevard", Artist: "Badly Drawn Boy"},
+		{Title: "Appletree Boulevard (Live)", Artist: "Badly Drawn Boy"},
+		{Title: "Appletree Boulevard", Artist: "Badly Drawn Boy"},
+	}
+	var out bytes.Buffer
+	dryRunOutput = &out
+	defer func() { dryRunOutput = os.Stdout }()
+	tests := []struct {
+		client APIClient
+		want   []string
+		msg    string
+	}{
+		{APIClient{Job: "daily", Station: "fipJazz"}, []string{"colleague", "everyone"}, "should create the playlist on the accounts subscribed to the station"},
+		{APIClient{Job: "daily", Station: "fipRock"}, []string{"me", "everyone"}, "should create the playlist on the accounts subscribed to the station"},
+		{APIClient{Job: "weekly", Station: "fipJazz", Accounts: []string{"jazz"}}, []string{"colleague", "friend"}, "should only create the playlist on the targeted group"},
+		{APIClient{Job: "daily", Station: "fip", Accounts: []string{"me"}}, nil, "should skip the playlist when no account wants it"},
+	}
+
+	for _, test := range tests {
+		out.Reset()
+		test.client.DryRun = true
+
+		err := test.client.CreatePlaylist("mock playlist", tracks)
+
+		assert.Nil(t, err, "should not have errored")
+		var got []string
+		for _, line := range strings.Split(out.String(), "\n") {
+			if strings.HasPrefix(line, "[dry run]") {
+				got = append(got, strings.Split(line, `"`)[3])
+			}
+		}
+		assert.Equal(t, test.want, got, test.msg)
+	}
+	assert.Equal(t, 2, searches, "should have searched each unique track only once across accounts and playlists")
+	assert.Equal(t, 0, created, "should not create playlists in a dry run")
+}
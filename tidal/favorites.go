@@ -0,0 +1,121 @@
+package tidal
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coaxial/tizinger/utils/credentials"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/normalize"
+)
+
+// favoritesBatchSize is how many tracks or artists are added to the
+// favorites per request.
+const favoritesBatchSize = 50
+
+// favorites returns the IDs of the matched tracks and artists to add to the
+// accounts' favorites, according to the client's settings, in the order they
+// aired.
+func (ac APIClient) favorites(matches []Match) (trackIDs []int, artistIDs []int) {
+	if !ac.FavoriteTracks && !ac.FavoriteArtists {
+		return trackIDs, artistIDs
+	}
+	// Artists are counted as the source credits them, whether their
+	// tracks were found or not.
+	plays := make(map[string]int)
+	for _, m := range matches {
+		plays[artistKey(m.Track.Artist)]++
+	}
+	seenTracks, seenArtists := make(map[int]bool), make(map[int]bool)
+	for _, m := range matches {
+		if m.TidalID == trackNotFound || plays[artistKey(m.Track.Artist)] < ac.FavoriteMinPlays {
+			continue
+		}
+		if ac.FavoriteTracks && !seenTracks[m.TidalID] {
+			seenTracks[m.TidalID] = true
+			trackIDs = append(trackIDs, m.TidalID)
+		}
+		// Overrides only know the track, not its artist.
+		if ac.FavoriteArtists && m.TidalArtistID != 0 && !seenArtists[m.TidalArtistID] {
+			seenArtists[m.TidalArtistID] = true
+			artistIDs = append(artistIDs, m.TidalArtistID)
+		}
+	}
+	logger.Info("picked favorites", "tracks", len(trackIDs), "artists", len(artistIDs), "min_plays", ac.FavoriteMinPlays)
+	return trackIDs, artistIDs
+}
+
+// artistKey identifies a source track's main artist when counting plays.
+func artistKey(artist string) string {
+	return normalize.Key(normalize.PrimaryArtist(artist))
+}
+
+// addFavorites adds the tracks trackIDs and the artists artistIDs to account
+// a's favorites. Those already in its favorites are left alone, so that
+// running it again doesn't change when they were favorited.
+func addFavorites(a credentials.TidalAccount, trackIDs []int, artistIDs []int) (err error) {
+	log := logger.With("account", a.Name)
+	err = login(a.Username, a.Password)
+	if err != nil {
+		log.Error("error logging in", "err", err)
+		return err
+	}
+	userID := tidalUserData.UserID
+	uri := baseURL + "/users/" + strconv.Itoa(userID) + "/favorites/"
+
+	added, err := addMissing(uri+"tracks", "trackIds", trackIDs)
+	if err != nil {
+		log.Error("error adding favorite tracks", "err", err)
+		return err
+	}
+	for _, ID := range added {
+		addToLibrary(userID, ID)
+	}
+	log.Info("added favorite tracks", "added", len(added), "tracks", len(trackIDs))
+
+	added, err = addMissing(uri+"artists", "artistIds", artistIDs)
+	if err != nil {
+		log.Error("error adding favorite artists", "err", err)
+		return err
+	}
+	log.Info("added favorite artists", "added", len(added), "artists", len(artistIDs))
+	return err
+}
+
+// addMissing adds the IDs which aren't listed at uri, a list of favorites,
+// by POSTing them to it as the field named key, and returns those it added.
+func addMissing(uri string, key string, IDs []int) (added []int, err error) {
+	if len(IDs) == 0 {
+		return added, err
+	}
+	existing := make(map[int]bool)
+	err = pageItems(uri, existing)
+	if err != nil {
+		return added, err
+	}
+	var missing []string
+	for _, ID := range IDs {
+		if !existing[ID] {
+			existing[ID] = true
+			missing = append(missing, strconv.Itoa(ID))
+			added = append(added, ID)
+		}
+	}
+	for start := 0; start < len(missing); start += favoritesBatchSize {
+		end := start + favoritesBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		payload := url.Values{
+			key:                  {strings.Join(missing[start:end], ",")},
+			"onArtifactNotFound": {"SKIP"},
+		}
+		err = queryTidal(uri, nil, nil, payload, http.MethodPost, nil)
+		if err != nil {
+			return added[:start], err
+		}
+	}
+	return added, err
+}
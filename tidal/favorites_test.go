@@ -0,0 +1,78 @@
+package tidal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/credentials"
+	"github.com/coaxial/tizinger/utils/mocks"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFavorites(t *testing.T) {
+	matches := []Match{
+		{Track: extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}, TidalID: 42, TidalArtistID: 8992},
+		{Track: extractor.Track{Title: "Off the wall", Artist: "Jil Is Lucky"}, TidalID: 666, TidalArtistID: 1337},
+		{Track: extractor.Track{Title: "Otherside", Artist: "Red Hot Chili Peppers feat. Someone"}, TidalID: 100, TidalArtistID: 8992},
+		{Track: extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}, TidalID: 42, TidalArtistID: 8992},
+		{Track: extractor.Track{Title: "Not found", Artist: "Red Hot Chili Peppers"}, TidalID: trackNotFound},
+		{Track: extractor.Track{Title: "Overridden", Artist: "Keiko Mari"}, TidalID: 2020, Override: true},
+	}
+
+	tests := []struct {
+		client  APIClient
+		tracks  []int
+		artists []int
+		msg     string
+	}{
+		{APIClient{}, nil, nil, "should not favorite anything by default"},
+		{APIClient{FavoriteTracks: true}, []int{42, 666, 100, 2020}, nil, "should favorite every track found once"},
+		{APIClient{FavoriteArtists: true}, nil, []int{8992, 1337}, "should favorite every known artist once"},
+		{APIClient{FavoriteTracks: true, FavoriteArtists: true, FavoriteMinPlays: 3}, []int{42, 100}, []int{8992}, "should only favorite the artists aired often enough and their tracks"},
+	}
+	for _, tc := range tests {
+		tracks, artists := tc.client.favorites(matches)
+		assert.Equal(t, tc.tracks, tracks, tc.msg)
+		assert.Equal(t, tc.artists, artists, tc.msg)
+	}
+}
+
+func TestAddFavorites(t *testing.T) {
+	posted := make(map[string][]string)
+	favorites := func(path string, key string) http.HandlerFunc {
+		return func(resp http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost {
+				req.ParseForm()
+				posted[key] = append(posted[key], req.PostForm.Get(key))
+				return
+			}
+			_, JSON := mocks.LoadFixture(path)
+			resp.Write(JSON)
+		}
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/login/username", func(resp http.ResponseWriter, req *http.Request) {
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/login_response.json")
+		resp.Write(JSON)
+	})
+	r.HandleFunc("/users/133713373/favorites/tracks", favorites("../fixtures/tidal/favorites-tracks_response.json", "trackIds"))
+	r.HandleFunc("/users/133713373/favorites/artists", favorites("../fixtures/tidal/favorites-artists_response.json", "artistIds"))
+	server := mocks.Server(r)
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+	account := credentials.TidalAccount{Username: "mockuser@example.org", Password: "secret"}
+
+	err := addFavorites(account, []int{42, 1337, 2020}, []int{8992, 1337})
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, map[string][]string{"trackIds": {"1337,2020"}, "artistIds": {"1337"}}, posted, "should only have added what isn't a favorite yet")
+
+	posted = make(map[string][]string)
+	err = addFavorites(account, []int{42}, nil)
+	assert.Nil(t, err, "should not have errored")
+	assert.Empty(t, posted, "should not add anything when everything is a favorite already")
+}
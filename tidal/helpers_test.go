@@ -11,26 +11,26 @@ var originalURL = baseURL
 // the live one, and arbitrary responses or failures can be served as needed.
 func SetBaseURL(url string) {
 	baseURL = url
-	logger.Trace.Printf("base URL overridden to %q", url)
+	logger.Trace("base URL overridden", "url", url)
 }
 
 // ResetBaseURL resets the endpointURL to its original value, hitting the
 // live API
 func ResetBaseURL() {
 	baseURL = originalURL
-	logger.Trace.Printf("base URL reset to %q", originalURL)
+	logger.Trace("base URL reset", "url", originalURL)
 }
 
 // SetToken is a wrapper for the fetchToken method so that the token
 // manifest URL can be overridden.
 func SetToken(url string) (err error) {
 	originalURL := manifestURL
-	logger.Trace.Printf("tokens manifest URL overridden to %q", url)
+	logger.Trace("tokens manifest URL overridden", "url", url)
 	manifestURL = url
 	err = setToken()
 	defer func() {
 		manifestURL = originalURL
-		logger.Trace.Printf("tokens manifest URL reset to %q", originalURL)
+		logger.Trace("tokens manifest URL reset", "url", originalURL)
 	}()
 	return err
 }
@@ -0,0 +1,123 @@
+package tidal
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coaxial/tizinger/utils/logger"
+)
+
+// libraryTTL is how long the tracks in an account's library are cached for
+// before being listed again.
+const libraryTTL = time.Hour
+
+// libraryPageSize is how many items are listed per request when going
+// through an account's library.
+const libraryPageSize = 100
+
+// libraryEntry is an account's cached library.
+type libraryEntry struct {
+	IDs     map[int]bool
+	fetched time.Time
+}
+
+var (
+	// libraryMu guards libraries.
+	libraryMu sync.Mutex
+	// libraries caches the IDs of the tracks in each account's library, by
+	// user ID, since listing them takes a request per page of every
+	// playlist.
+	libraries = map[int]libraryEntry{}
+)
+
+// library returns the IDs of the tracks in user userID's favorites and
+// playlists, from the cache if it was listed less than libraryTTL ago.
+func library(userID int) (IDs map[int]bool, err error) {
+	libraryMu.Lock()
+	defer libraryMu.Unlock()
+	if e, ok := libraries[userID]; ok && time.Since(e.fetched) < libraryTTL {
+		logger.Trace("using cached library", "user_id", userID, "tracks", len(e.IDs))
+		return e.IDs, err
+	}
+
+	IDs = make(map[int]bool)
+	logger.Info("listing the tracks in the user's library", "user_id", userID)
+	err = pageItems(baseURL+"/users/"+strconv.Itoa(userID)+"/favorites/tracks", IDs)
+	if err != nil {
+		logger.Error("error listing favorite tracks", "err", err)
+		return IDs, err
+	}
+	playlists, err := userPlaylists(userID)
+	if err != nil {
+		return IDs, err
+	}
+	for _, p := range playlists {
+		err = pageItems(baseURL+"/playlists/"+p.UUID+"/items", IDs)
+		if err != nil {
+			logger.Error("error listing playlist items", "uuid", p.UUID, "err", err)
+			return IDs, err
+		}
+	}
+	logger.Info("listed the tracks in the user's library", "user_id", userID, "tracks", len(IDs), "playlists", len(playlists))
+	libraries[userID] = libraryEntry{IDs: IDs, fetched: time.Now()}
+	return IDs, err
+}
+
+// addToLibrary records that the track ID was added to one of user userID's
+// playlists, so that the cached library stays up to date.
+func addToLibrary(userID int, ID int) {
+	libraryMu.Lock()
+	defer libraryMu.Unlock()
+	if e, ok := libraries[userID]; ok {
+		e.IDs[ID] = true
+	}
+}
+
+// userPlaylists returns every playlist of user userID.
+func userPlaylists(userID int) (playlists []playlist, err error) {
+	uri := baseURL + "/users/" + strconv.Itoa(userID) + "/playlists"
+	for offset := 0; ; offset += libraryPageSize {
+		query := map[string]string{
+			"limit":  strconv.Itoa(libraryPageSize),
+			"offset": strconv.Itoa(offset),
+		}
+		var playlistsJSON playlistsResponse
+		err = queryTidal(uri, nil, query, nil, http.MethodGet, &playlistsJSON)
+		if err != nil {
+			logger.Error("error listing playlists", "err", err)
+			return playlists, err
+		}
+		playlists = append(playlists, playlistsJSON.Playlists...)
+		if len(playlistsJSON.Playlists) == 0 || offset+libraryPageSize >= playlistsJSON.TotalNumberOfItems {
+			return playlists, err
+		}
+	}
+}
+
+// pageItems goes through the pages of items at uri, a list of favorite tracks
+// or artists or a playlist's items, adding their IDs to IDs. Videos are left
+// out.
+func pageItems(uri string, IDs map[int]bool) (err error) {
+	for offset := 0; ; offset += libraryPageSize {
+		query := map[string]string{
+			"limit":  strconv.Itoa(libraryPageSize),
+			"offset": strconv.Itoa(offset),
+		}
+		var itemsJSON itemsResponse
+		err = queryTidal(uri, nil, query, nil, http.MethodGet, &itemsJSON)
+		if err != nil {
+			return err
+		}
+		for _, i := range itemsJSON.Items {
+			// Playlists can have videos too, favorites have no type.
+			if i.Type == "" || i.Type == "track" {
+				IDs[i.Item.ID] = true
+			}
+		}
+		if len(itemsJSON.Items) == 0 || offset+libraryPageSize >= itemsJSON.TotalNumberOfItems {
+			return err
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package tidal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/coaxial/tizinger/utils/credentials"
+	"github.com/coaxial/tizinger/utils/mocks"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// libraryServer serves an account's favorites and playlists, counting the
+// requests in requests, and returns a function shutting it down.
+func libraryServer(requests *int) func() {
+	fixture := func(path string) http.HandlerFunc {
+		return func(resp http.ResponseWriter, req *http.Request) {
+			*requests++
+			_, JSON := mocks.LoadFixture(path)
+			resp.Write(JSON)
+		}
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/users/133713373/favorites/tracks", fixture("../fixtures/tidal/favorites-tracks_response.json"))
+	r.HandleFunc("/users/133713373/playlists", fixture("../fixtures/tidal/playlists-list_response.json"))
+	r.HandleFunc("/playlists/{uuid}/items", fixture("../fixtures/tidal/playlist-items_response.json"))
+	server := mocks.Server(r)
+	originalURL := baseURL
+	baseURL = server.URL
+	return func() {
+		baseURL = originalURL
+		server.Close()
+		libraries = map[int]libraryEntry{}
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	requests := 0
+	defer libraryServer(&requests)()
+
+	got, err := library(133713373)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, map[int]bool{42: true, 100: true, 666: true}, got, "should have listed the favorite tracks and the playlists' tracks, without videos")
+	assert.Equal(t, 4, requests, "should have listed the favorites, the playlists and each playlist's items")
+
+	addToLibrary(133713373, 1337)
+	got, err = library(133713373)
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, 4, requests, "should have cached the library")
+	assert.True(t, got[1337], "should have cached the tracks added since")
+}
+
+func TestExportToDiscovery(t *testing.T) {
+	var posted []string
+	r := mux.NewRouter()
+	r.HandleFunc("/login/username", func(resp http.ResponseWriter, req *http.Request) {
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/login_response.json")
+		resp.Write(JSON)
+	})
+	r.HandleFunc("/users/133713373/playlists", func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			_, JSON := mocks.LoadFixture("../fixtures/tidal/playlists-list_response.json")
+			resp.Write(JSON)
+			return
+		}
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/playlist-create_response.json")
+		resp.WriteHeader(http.StatusCreated)
+		resp.Write(JSON)
+	})
+	r.HandleFunc("/users/133713373/favorites/tracks", func(resp http.ResponseWriter, req *http.Request) {
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/favorites-tracks_response.json")
+		resp.Write(JSON)
+	})
+	r.HandleFunc("/playlists/mock-playlist-uuid/items", func(resp http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		posted = append(posted, req.PostForm.Get("trackIds"))
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/playlist-add_success_response.json")
+		resp.Write(JSON)
+	})
+	r.HandleFunc("/playlists/{uuid}/items", func(resp http.ResponseWriter, req *http.Request) {
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/playlist-items_response.json")
+		resp.Write(JSON)
+	})
+	r.HandleFunc("/playlists/mock-playlist-uuid", func(resp http.ResponseWriter, req *http.Request) {
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/playlist-get_response.json")
+		resp.Write(JSON)
+	})
+	server := mocks.Server(r)
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() {
+		baseURL = originalURL
+		libraries = map[int]libraryEntry{}
+	}()
+	account := credentials.TidalAccount{Username: "mockuser@example.org", Password: "secret"}
+
+	_, err := APIClient{Discovery: true}.exportTo(account, "mock playlist", []int{42, 666, 1337, 2020}, nil)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, []string{"1337", "2020"}, posted, "should only have added the tracks new to the account")
+}
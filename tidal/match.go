@@ -0,0 +1,95 @@
+package tidal
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/normalize"
+)
+
+// Candidate is a Tidal track a source track could match.
+type Candidate struct {
+	ID     int
+	Title  string
+	Artist string
+	Album  string
+	// ArtistID is the Tidal ID of the track's main artist.
+	ArtistID int
+	// Confidence is how similar the candidate is to the source track, from
+	// 0 to 1.
+	Confidence float64
+}
+
+// maxCandidates is how many search results are scored for each track.
+const maxCandidates = 5
+
+// candidates returns the search results as candidates for source, the most
+// similar first. Equally similar results keep Tidal's order.
+func candidates(source extractor.Track, results []track) (cs []Candidate) {
+	for _, t := range results {
+		cs = append(cs, Candidate{
+			ID:         t.ID,
+			Title:      t.Title,
+			Artist:     t.Artist.Name,
+			Album:      t.Album.Title,
+			ArtistID:   t.Artist.ID,
+			Confidence: confidence(source, t),
+		})
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].Confidence > cs[j].Confidence })
+	return cs
+}
+
+// confidence scores how similar t is to source, from 0 to 1, once both are
+// normalized. The title counts for 60% and the artist for 40%: the most
+// similar pair of the source's artists, as credited together or separately,
+// and the track's.
+func confidence(source extractor.Track, t track) float64 {
+	sourceTitle, featured := normalize.Title(source.Title)
+	title, _ := normalize.Title(t.Title)
+	sourceArtists := append([]string{source.Artist}, normalize.Artists(source.Artist)...)
+	sourceArtists = append(sourceArtists, featured...)
+	var artists []string
+	for _, a := range append([]artist{t.Artist}, t.Artists...) {
+		artists = append(artists, a.Name)
+		artists = append(artists, normalize.Artists(a.Name)...)
+	}
+	best := 0.0
+	for _, sa := range sourceArtists {
+		for _, a := range artists {
+			if s := similarity(sa, a); s > best {
+				best = s
+			}
+		}
+	}
+	score := 0.6*similarity(sourceTitle, title) + 0.4*best
+	return math.Round(score*100) / 100
+}
+
+// similarity is the Dice coefficient of the words in a and b, ignoring case,
+// accents and punctuation: 1 when they have the same words, 0 when they have
+// none in common.
+func similarity(a string, b string) float64 {
+	wa, wb := words(a), words(b)
+	if len(wa) == 0 || len(wb) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range wa {
+		if wb[w] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(wa)+len(wb))
+}
+
+// words returns the set of normalized words in s.
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(normalize.Key(s)) {
+		set[w] = true
+	}
+	return set
+}
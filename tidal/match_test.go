@@ -0,0 +1,46 @@
+package tidal
+
+import (
+	"testing"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfidence(t *testing.T) {
+	source := extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}
+	tests := []struct {
+		name string
+		t    track
+		want float64
+	}{
+		{"same", track{Title: "Scar Tissue", Artist: artist{Name: "Red Hot Chili Peppers"}}, 1},
+		{"featured artist", track{Title: "Scar Tissue", Artist: artist{Name: "Someone"}, Artists: []artist{{Name: "Someone"}, {Name: "Red Hot Chili Peppers"}}}, 1},
+		{"other artist", track{Title: "Scar Tissue", Artist: artist{Name: "Someone"}}, 0.6},
+		{"live version", track{Title: "Scar Tissue (Live)", Artist: artist{Name: "Red Hot Chili Peppers"}}, 1},
+		{"remix", track{Title: "Scar Tissue (Flute mix)", Artist: artist{Name: "Red Hot Chili Peppers"}}, 0.8},
+		{"accents", track{Title: "Scar Tissué", Artist: artist{Name: "Red Hot Chili Peppers"}}, 1},
+		{"split artists", track{Title: "Scar Tissue", Artist: artist{Name: "Red Hot Chili Peppers & Someone"}}, 1},
+		{"unrelated", track{Title: "Appletree Boulevard", Artist: artist{Name: "Badly Drawn Boy"}}, 0},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, confidence(source, tc.t), "should score how similar the tracks are: "+tc.name)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	source := extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}
+	results := []track{
+		{ID: 1, Title: "Scar Tissue (Flute mix)", Artist: artist{Name: "Red Hot Chili Peppers"}},
+		{ID: 2, Title: "Scar Tissue", Artist: artist{Name: "Red Hot Chili Peppers"}},
+		{ID: 3, Title: "Other", Artist: artist{Name: "Other"}},
+		{ID: 4, Title: "Another", Artist: artist{Name: "Other"}},
+	}
+
+	var got []int
+	for _, c := range candidates(source, results) {
+		got = append(got, c.ID)
+	}
+
+	assert.Equal(t, []int{2, 1, 3, 4}, got, "should sort the candidates, most similar first")
+}
@@ -0,0 +1,146 @@
+package tidal
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/coaxial/tizinger/utils/normalize"
+)
+
+// minConfidence is the confidence from which a strategy's best candidate is
+// taken without trying the next strategies.
+const minConfidence = 0.75
+
+// minMatchConfidence is the confidence below which the best candidate isn't
+// taken at all, the track being left out as not found rather than matching
+// whatever a search returned, e.g. a track with a similar title by someone
+// else.
+const minMatchConfidence = 0.5
+
+// strategy is a way of searching for a source track on Tidal.
+type strategy struct {
+	// name identifies the strategy in the run reports.
+	name string
+	// query returns what to search for, or "" when the strategy doesn't
+	// apply to the track.
+	query func(source extractor.Track) string
+	// find is used instead of query by strategies which don't search for
+	// tracks directly. It returns no candidates when the strategy doesn't
+	// apply to the track.
+	find func(source extractor.Track) ([]Candidate, error)
+	// applies tells whether a find strategy applies to the track, so that
+	// the strategies which didn't run aren't taken as having succeeded.
+	applies func(source extractor.Track) bool
+}
+
+// strategies are tried in order until one finds a candidate with at least
+// minConfidence. It can be overridden when testing.
+var strategies = []strategy{
+	{name: "isrc", find: byISRC, applies: func(source extractor.Track) bool {
+		return source.ISRC != ""
+	}},
+	{name: "full", query: func(source extractor.Track) string {
+		return normalize.Query(source.Title, source.Artist)
+	}},
+	{name: "primary_artist", query: func(source extractor.Track) string {
+		title, _ := normalize.Title(source.Title)
+		return join(title, normalize.PrimaryArtist(source.Artist))
+	}},
+	{name: "bare_title", query: func(source extractor.Track) string {
+		title, _ := normalize.Title(source.Title)
+		return join(normalize.StripParentheticals(title), normalize.PrimaryArtist(source.Artist))
+	}},
+	{name: "album", query: func(source extractor.Track) string {
+		if strings.TrimSpace(source.Album) == "" {
+			return ""
+		}
+		title, _ := normalize.Title(source.Title)
+		return join(title, source.Album)
+	}},
+	{name: "top_tracks", find: topTracks, applies: func(source extractor.Track) bool {
+		return normalize.PrimaryArtist(source.Artist) != ""
+	}},
+}
+
+// join returns the normalized parts of a query, separated by spaces.
+func join(parts ...string) string {
+	var keys []string
+	for _, p := range parts {
+		if k := normalize.Key(p); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return strings.Join(keys, " ")
+}
+
+// byISRC looks up the tracks with the source track's ISRC, if known. The ISRC
+// identifies the recording, so they are fully confident matches however they
+// are titled.
+func byISRC(source extractor.Track) (cs []Candidate, err error) {
+	if source.ISRC == "" {
+		return cs, err
+	}
+	var tracksJSON searchResponse
+	err = queryTidal(baseURL+"/tracks", nil, map[string]string{"isrc": source.ISRC}, nil, http.MethodGet, &tracksJSON)
+	if err != nil {
+		return cs, err
+	}
+	var same []track
+	for _, t := range tracksJSON.Results {
+		if strings.EqualFold(t.Isrc, source.ISRC) {
+			same = append(same, t)
+		}
+	}
+	cs = candidates(source, same)
+	for i := range cs {
+		cs[i].Confidence = 1
+	}
+	return cs, err
+}
+
+// topTracksLimit is how many of an artist's top tracks are looked through.
+const topTracksLimit = 50
+
+// minTitleSimilarity is how similar to the source track's title a top track's
+// title must be to be a candidate.
+const minTitleSimilarity = 0.5
+
+// topTracks looks for source among the top tracks of its primary artist on
+// Tidal, for when searching for the title doesn't find it.
+func topTracks(source extractor.Track) (cs []Candidate, err error) {
+	name := normalize.PrimaryArtist(source.Artist)
+	if name == "" {
+		return cs, err
+	}
+	var artistsJSON artistSearchResponse
+	err = queryTidal(baseURL+"/search/artists", nil, map[string]string{"query": normalize.Key(name)}, url.Values{"limit": {"1"}, "offset": {"0"}}, http.MethodGet, &artistsJSON)
+	if err != nil || len(artistsJSON.Results) == 0 {
+		return cs, err
+	}
+	a := artistsJSON.Results[0]
+	logger.Trace("looking through the artist's top tracks", "artist", a.Name, "artist_id", a.ID)
+
+	var tracksJSON searchResponse
+	endpoint := "/artists/" + strconv.Itoa(a.ID) + "/toptracks"
+	err = queryTidal(baseURL+endpoint, nil, nil, url.Values{"limit": {strconv.Itoa(topTracksLimit)}, "offset": {"0"}}, http.MethodGet, &tracksJSON)
+	if err != nil {
+		return cs, err
+	}
+	title, _ := normalize.Title(source.Title)
+	var kept []track
+	for _, t := range tracksJSON.Results {
+		candidate, _ := normalize.Title(t.Title)
+		if similarity(title, candidate) >= minTitleSimilarity {
+			kept = append(kept, t)
+		}
+	}
+	cs = candidates(source, kept)
+	if len(cs) > maxCandidates {
+		cs = cs[:maxCandidates]
+	}
+	return cs, err
+}
@@ -0,0 +1,175 @@
+package tidal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// strategyServer serves the search results for the queries in results, no
+// results for other queries, and the Red Hot Chili Peppers' top tracks. It
+// records the queries searched for in queries.
+func strategyServer(results map[string]string, queries *[]string) (restore func()) {
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		fixture := "../fixtures/tidal/search-track_noresult_response.json"
+		switch req.URL.Path {
+		case "/search/tracks":
+			query := req.URL.Query().Get("query")
+			*queries = append(*queries, query)
+			if f, ok := results[query]; ok {
+				fixture = f
+			}
+		case "/search/artists":
+			*queries = append(*queries, "artist: "+req.URL.Query().Get("query"))
+			fixture = "../fixtures/tidal/search-artist_result_response.json"
+		case "/artists/8992/toptracks":
+			fixture = "../fixtures/tidal/artist-toptracks_response.json"
+		}
+		_, JSON := mocks.LoadFixture(fixture)
+		resp.Write(JSON)
+	}
+	server := mocks.Server(http.HandlerFunc(handler))
+	originalURL = baseURL
+	baseURL = server.URL
+	return func() {
+		baseURL = originalURL
+		server.Close()
+	}
+}
+
+func TestLookupStrategies(t *testing.T) {
+	source := extractor.Track{Title: "Scar tissue (Remastered)", Artist: "Red Hot Chili Peppers feat. Someone", Album: "Californication"}
+	var queries []string
+	defer strategyServer(map[string]string{
+		"scar tissue californication": "../fixtures/tidal/artist-toptracks_response.json",
+	}, &queries)()
+
+	best, cs, strategy, err := lookup(source)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, []string{
+		"scar tissue red hot chili peppers someone",
+		"scar tissue red hot chili peppers",
+		"scar tissue californication",
+	}, queries, "should have tried the strategies in order, skipping the queries already searched for")
+	assert.Equal(t, "album", strategy, "should tell which strategy found the track")
+	assert.Equal(t, 1041722, best.ID, "should have found the track")
+	assert.Equal(t, 1041722, cs[0].ID, "should return the candidates the strategy found")
+}
+
+func TestLookupTopTracks(t *testing.T) {
+	source := extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers"}
+	var queries []string
+	defer strategyServer(nil, &queries)()
+
+	best, cs, strategy, err := lookup(source)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, []string{"scar tissue red hot chili peppers", "artist: red hot chili peppers"}, queries, "should have looked for the artist")
+	assert.Equal(t, "top_tracks", strategy, "should tell which strategy found the track")
+	assert.Equal(t, 1041722, best.ID, "should have found the track among the top tracks")
+	assert.Len(t, cs, 2, "should only keep the top tracks with a similar title")
+}
+
+func TestLookupNotConfident(t *testing.T) {
+	source := extractor.Track{Title: "Appletree", Artist: "Someone else"}
+	var queries []string
+	defer strategyServer(map[string]string{
+		"appletree someone else": "../fixtures/tidal/search-track_result_response.json",
+	}, &queries)()
+
+	best, cs, strategy, err := lookup(source)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Len(t, queries, 2, "should have tried the other strategies")
+	assert.Equal(t, trackNotFound, best.ID, "should not take a candidate below the floor")
+	assert.Len(t, cs, 1, "should still return the candidates to pick from")
+	assert.Equal(t, "full", strategy, "should tell which strategy found them")
+}
+
+func TestLookupFallback(t *testing.T) {
+	source := extractor.Track{Title: "Appletree Boulevard", Artist: "Someone else"}
+	var queries []string
+	defer strategyServer(map[string]string{
+		"appletree boulevard someone else": "../fixtures/tidal/search-track_result_response.json",
+	}, &queries)()
+
+	best, _, strategy, err := lookup(source)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Len(t, queries, 2, "should have tried the other strategies")
+	assert.Equal(t, 132616868, best.ID, "should fall back to the best candidate found above the floor")
+	assert.Equal(t, 0.6, best.Confidence, "should be less than confident")
+	assert.Equal(t, "full", strategy, "should tell which strategy found it")
+}
+
+func TestLookupStrategyError(t *testing.T) {
+	source := extractor.Track{Title: "Appletree Boulevard", Artist: "Someone else"}
+	failing := map[string]bool{"/search/artists": true}
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		if failing[req.URL.Path] {
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/search-track_result_response.json")
+		resp.Write(JSON)
+	}
+	server := mocks.Server(http.HandlerFunc(handler))
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+
+	best, _, strategy, err := lookup(source)
+
+	assert.Nil(t, err, "should not error when another strategy ran")
+	assert.Equal(t, 132616868, best.ID, "should keep what the other strategies found")
+	assert.Equal(t, "full", strategy, "should tell which strategy found it")
+
+	failing["/search/tracks"] = true
+	best, _, _, err = lookup(source)
+
+	assert.Error(t, err, "should error when every strategy did")
+	assert.Equal(t, trackNotFound, best.ID, "should not have found the track")
+}
+
+func TestLookupISRC(t *testing.T) {
+	source := extractor.Track{Title: "Appletree Boulevard (Remastered)", Artist: "Someone else", ISRC: "GBKPL2090196"}
+	var isrcs []string
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/tracks", req.URL.Path, "should only have looked up the ISRC")
+		isrcs = append(isrcs, req.URL.Query().Get("isrc"))
+		_, JSON := mocks.LoadFixture("../fixtures/tidal/search-track_result_response.json")
+		resp.Write(JSON)
+	}
+	server := mocks.Server(http.HandlerFunc(handler))
+	defer server.Close()
+	originalURL = baseURL
+	baseURL = server.URL
+	defer func() { baseURL = originalURL }()
+
+	best, _, strategy, err := lookup(source)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, []string{"GBKPL2090196"}, isrcs, "should have looked up the ISRC")
+	assert.Equal(t, 132616868, best.ID, "should have found the track")
+	assert.Equal(t, 1.0, best.Confidence, "should be confident whatever the title and artist")
+	assert.Equal(t, "isrc", strategy, "should tell the ISRC found the track")
+}
+
+func TestLookupISRCFallback(t *testing.T) {
+	source := extractor.Track{Title: "Scar tissue", Artist: "Red Hot Chili Peppers", ISRC: "USWB19900690"}
+	var queries []string
+	defer strategyServer(map[string]string{
+		"scar tissue red hot chili peppers": "../fixtures/tidal/artist-toptracks_response.json",
+	}, &queries)()
+
+	best, _, strategy, err := lookup(source)
+
+	assert.Nil(t, err, "should not have errored")
+	assert.Equal(t, 1041722, best.ID, "should have found the track")
+	assert.Equal(t, "full", strategy, "should have fallen back to searching for the title and artist")
+}
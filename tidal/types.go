@@ -99,6 +99,32 @@ type playlist struct {
 	LastItemAddedAt interface{}    `json:"lastItemAddedAt"`
 }
 
+// playlistsResponse is the JSON object returned when listing a user's
+// playlists.
+type playlistsResponse struct {
+	Limit              int        `json:"limit"`
+	Offset             int        `json:"offset"`
+	TotalNumberOfItems int        `json:"totalNumberOfItems"`
+	Playlists          []playlist `json:"items"`
+}
+
+// itemsResponse is the JSON object returned when listing a user's favorite
+// tracks or artists, or a playlist's items.
+type itemsResponse struct {
+	Limit              int `json:"limit"`
+	Offset             int `json:"offset"`
+	TotalNumberOfItems int `json:"totalNumberOfItems"`
+	Items              []struct {
+		// Item is the track, video or artist, only its ID is needed.
+		Item struct {
+			ID int `json:"id"`
+		} `json:"item"`
+		// Type is "track" or "video" for playlist items, and empty for
+		// favorites.
+		Type string `json:"type"`
+	} `json:"items"`
+}
+
 type searchResponse struct {
 	Results            []track `json:"items"`
 	Limit              int     `json:"limit"`
@@ -106,6 +132,13 @@ type searchResponse struct {
 	TotalNumberOfItems int     `json:"TotalNumberOfItems"`
 }
 
+type artistSearchResponse struct {
+	Results            []artist `json:"items"`
+	Limit              int      `json:"limit"`
+	Offset             int      `json:"offset"`
+	TotalNumberOfItems int      `json:"TotalNumberOfItems"`
+}
+
 type populatePlaylistResult struct {
 	LastUpdated  int64 `json:"lastUpdated"`
 	AddedItemIds []int `json:"addedItemIds"`
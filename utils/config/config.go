@@ -0,0 +1,495 @@
+// Package config abstracts away access to the settings and jobs defined in the
+// config.yaml file.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/coaxial/tizinger/utils/logger"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Config represents the config.yaml file's YAML structure.
+type Config struct {
+	Settings Settings `yaml:"settings"`
+	Notify   Notify   `yaml:"notify"`
+	Jobs     []Job    `yaml:"jobs"`
+}
+
+// Settings apply to every job and command.
+type Settings struct {
+	// LogLevel is one of logger.Levels.
+	LogLevel string `yaml:"log_level"`
+	// LogFormat is one of logger.Formats.
+	LogFormat string `yaml:"log_format"`
+	// StateFile is where the progress of jobs and playlists is kept.
+	StateFile string `yaml:"state_file"`
+	// CheckpointFile is where backfills record the playlists done.
+	CheckpointFile string `yaml:"checkpoint_file"`
+	// OverridesFile is where the user's corrections to track matches are
+	// kept.
+	OverridesFile string `yaml:"overrides_file"`
+	// ISRCSource is where to resolve the tracks' ISRCs from, a JSON lookup
+	// file or an HTTP service's URL. They aren't resolved when empty.
+	ISRCSource string `yaml:"isrc_source"`
+	// HTTPTimeout bounds how long requests to FIP and the exporters can
+	// take, 0 means no timeout.
+	HTTPTimeout time.Duration `yaml:"http_timeout"`
+	// MetricsAddress is the address `serve` exposes the Prometheus metrics
+	// on, e.g. ":9090", at /metrics. They aren't exposed when empty.
+	MetricsAddress string `yaml:"metrics_address"`
+	// APIAddress is the address `serve` serves the HTTP API on, e.g.
+	// "127.0.0.1:8080". It isn't served when empty.
+	APIAddress string `yaml:"api_address"`
+}
+
+// Notify is who to tell about the outcome of runs, and when.
+type Notify struct {
+	// On is "always", or "failure" to only notify about failed runs.
+	On string `yaml:"on"`
+	// Webhooks are URLs to POST the outcome to as JSON, such as Slack or
+	// Matrix incoming webhooks.
+	Webhooks []string `yaml:"webhooks"`
+	// Email sends the outcome by email, when it has recipients.
+	Email Email `yaml:"email"`
+}
+
+// Email is how to send notifications by email.
+type Email struct {
+	// Server is the SMTP server's host:port.
+	Server string `yaml:"server"`
+	// From is the sender's address.
+	From string `yaml:"from"`
+	// To are the recipients' addresses.
+	To []string `yaml:"to"`
+	// Account is the name of the smtp account in the credentials file to
+	// log in to the server with, if it requires it.
+	Account string `yaml:"account"`
+}
+
+// Job describes a playlist to create on a schedule.
+type Job struct {
+	// Name identifies the job, it must be unique.
+	Name string `yaml:"name"`
+	// Schedule is a cron expression (e.g. "0 6 * * *" or "@daily") for when
+	// the job runs.
+	Schedule string `yaml:"schedule"`
+	// Source is where the tracks come from: "fip", or "file" to read them
+	// from File.
+	Source string `yaml:"source"`
+	// File is the CSV or JSON tracklist to read for the "file" source.
+	File string `yaml:"file"`
+	// Station is the FIP station to get tracks from.
+	Station string `yaml:"station"`
+	// Window is how far back from the scheduled time to get tracks from,
+	// e.g. "24h".
+	Window time.Duration `yaml:"window"`
+	// Count is how many tracks to get up until the scheduled time, it can
+	// be used instead of Window.
+	Count int `yaml:"count"`
+	// Filters leave out some of the tracks from the playlist.
+	Filters Filters `yaml:"filters"`
+	// Dedupe leaves out the tracks already in the job's previous
+	// playlists.
+	Dedupe Dedupe `yaml:"dedupe"`
+	// Destinations are the exporters to create the playlist on.
+	Destinations []string `yaml:"destinations"`
+	// Accounts are the names or groups of the destinations' accounts to
+	// create the playlist on, every account subscribed to the job and its
+	// station if empty.
+	Accounts []string `yaml:"accounts"`
+	// Playlist is the text/template for the playlist's name, see
+	// NameData for what it can refer to.
+	Playlist string `yaml:"playlist"`
+	// SkipExisting doesn't create the playlist on accounts that already
+	// have one with the same name.
+	SkipExisting bool `yaml:"skip_existing"`
+	// Discovery leaves out the tracks already in each account's favorites
+	// or playlists.
+	Discovery bool `yaml:"discovery"`
+	// Favorites adds some of the tracks to the accounts' favorites too.
+	Favorites Favorites `yaml:"favorites"`
+}
+
+// Filters select which tracks make it to a job's playlist. Matching ignores
+// case, accents and punctuation. Tracks whose musical kind, year or airing
+// times are unknown aren't filtered on them.
+type Filters struct {
+	// IncludeKinds are the musical kinds, as named by FIP, whose tracks are
+	// kept. Every kind is kept when empty.
+	IncludeKinds []string `yaml:"include_kinds"`
+	// ExcludeKinds are the musical kinds whose tracks are left out, e.g.
+	// "Musique symphonique".
+	ExcludeKinds []string `yaml:"exclude_kinds"`
+	// MinYear and MaxYear are the range of release years whose tracks are
+	// kept, both included. 0 leaves the range open on that end.
+	MinYear int `yaml:"min_year"`
+	MaxYear int `yaml:"max_year"`
+	// ExcludeArtists are artists whose tracks are left out, including the
+	// tracks they are featured on.
+	ExcludeArtists []string `yaml:"exclude_artists"`
+	// ExcludeTitles are track titles which are left out.
+	ExcludeTitles []string `yaml:"exclude_titles"`
+	// MinDuration leaves out the tracks which aired for less than it, such
+	// as jingles, e.g. "1m".
+	MinDuration time.Duration `yaml:"min_duration"`
+}
+
+// Dedupe is which of a job's previous playlists, on the same account, a new
+// playlist leaves out the tracks of. Either or both can be set, a track is
+// left out if any of the playlists they select has it.
+type Dedupe struct {
+	// Playlists is how many of the last playlists to look at.
+	Playlists int `yaml:"playlists"`
+	// Days is how many days before the new playlist's window to look at.
+	Days int `yaml:"days"`
+}
+
+// Favorites is what a job adds to the accounts' favorites besides creating
+// the playlist.
+type Favorites struct {
+	// Tracks adds the tracks found.
+	Tracks bool `yaml:"tracks"`
+	// Artists adds the artists of the tracks found.
+	Artists bool `yaml:"artists"`
+	// MinPlays only adds the tracks and artists of artists which aired at
+	// least that many times in the job's window, every one when 0.
+	MinPlays int `yaml:"min_plays"`
+}
+
+// NameData is what a job's playlist naming template can refer to.
+type NameData struct {
+	Job     string
+	Station string
+	From    time.Time
+	To      time.Time
+	Count   int
+}
+
+// defaultPlaylist is the naming template for jobs that don't set one.
+const defaultPlaylist = `{{.Station}} {{.To.Format "2006-01-02"}}, {{.Count}} tracks`
+
+// Default returns the configuration used when there is no config file: no
+// jobs and the default settings.
+func Default() (c Config) {
+	c.applyDefaults()
+	return c
+}
+
+// Load reads, unmarshalls and validates the config file at path, then applies
+// the overrides from the environment (see applyEnv). Settings and jobs missing
+// optional values get their default values. Validation problems are returned
+// together as Errors, each with its line in the file. stations are the FIP
+// stations jobs can get tracks from.
+func Load(path string, stations []string) (c Config, err error) {
+	logger.Trace("reading config", "path", path)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Error("could not read config", "path", path, "err", err)
+		return c, err
+	}
+
+	// Decoding strictly catches unknown keys and values of the wrong
+	// type, with their line number.
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	err = dec.Decode(&c)
+	if err != nil && err != io.EOF {
+		logger.Error("could not parse config", "path", path, "err", err)
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	// The document is decoded a second time as nodes to know where each
+	// value is when reporting problems.
+	var root yaml.Node
+	err = yaml.Unmarshal(content, &root)
+	if err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+
+	errs := c.applyEnv(os.Environ())
+	c.applyDefaults()
+	errs = append(errs, c.validate(newLocator(path, &root), stations)...)
+	if len(errs) > 0 {
+		logger.Error("invalid config", "path", path, "errors", len(errs))
+		return c, errs
+	}
+	logger.Info("loaded config", "jobs", len(c.Jobs), "path", path)
+	return c, nil
+}
+
+// applyDefaults fills in the settings and job values left empty.
+func (c *Config) applyDefaults() {
+	if c.Settings.LogLevel == "" {
+		c.Settings.LogLevel = "info"
+	}
+	if c.Settings.LogFormat == "" {
+		c.Settings.LogFormat = "text"
+	}
+	if c.Settings.StateFile == "" {
+		c.Settings.StateFile = "state.json"
+	}
+	if c.Settings.CheckpointFile == "" {
+		c.Settings.CheckpointFile = "backfill.json"
+	}
+	if c.Settings.OverridesFile == "" {
+		c.Settings.OverridesFile = "overrides.yaml"
+	}
+	if c.Notify.On == "" {
+		c.Notify.On = "always"
+	}
+	for i := range c.Jobs {
+		j := &c.Jobs[i]
+		if j.Source == "" {
+			j.Source = "fip"
+		}
+		if j.Station == "" && j.Source == "fip" {
+			j.Station = "fip"
+		}
+		if len(j.Destinations) == 0 {
+			j.Destinations = []string{"tidal"}
+		}
+		if j.Playlist == "" {
+			j.Playlist = defaultPlaylist
+		}
+	}
+}
+
+// Prepare applies the defaults to the missing values of j, a job made outside
+// of the config file such as through the API, and checks them against
+// stations like Load. Problems are returned as Errors whose Path is
+// "request".
+func Prepare(j Job, stations []string) (job Job, err error) {
+	c := Config{Jobs: []Job{j}}
+	c.applyDefaults()
+	errs := c.validate(newLocator("request", nil), stations)
+	if len(errs) > 0 {
+		return j, errs
+	}
+	return c.Jobs[0], nil
+}
+
+// validate checks the values make sense together, and that the jobs get
+// tracks from one of stations. l locates the values in the file for the error
+// messages.
+func (c Config) validate(l locator, stations []string) (errs Errors) {
+	settings := l.key(l.doc(), "settings")
+	if !contains(logger.Levels, c.Settings.LogLevel) {
+		errs = append(errs, l.errorf(l.field(settings, "log_level"), "unknown log level %q, valid levels are %v", c.Settings.LogLevel, logger.Levels))
+	}
+	if !contains(logger.Formats, c.Settings.LogFormat) {
+		errs = append(errs, l.errorf(l.field(settings, "log_format"), "unknown log format %q, valid formats are %v", c.Settings.LogFormat, logger.Formats))
+	}
+	if c.Settings.HTTPTimeout < 0 {
+		errs = append(errs, l.errorf(l.field(settings, "http_timeout"), "http_timeout can't be negative"))
+	}
+
+	notify := l.key(l.doc(), "notify")
+	if c.Notify.On != "always" && c.Notify.On != "failure" {
+		errs = append(errs, l.errorf(l.field(notify, "on"), "unknown notify.on %q, use always or failure", c.Notify.On))
+	}
+	for i, w := range c.Notify.Webhooks {
+		if u, err := url.Parse(w); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, l.errorf(l.item(l.key(notify, "webhooks"), i), "webhook %d isn't an http or https URL", i+1))
+		}
+	}
+	if email := c.Notify.Email; len(email.To) > 0 && (email.Server == "" || email.From == "") {
+		errs = append(errs, l.errorf(l.key(notify, "email"), "email notifications need a server and a from address"))
+	}
+
+	names := make(map[string]bool)
+	for i, j := range c.Jobs {
+		node := l.item(l.key(l.doc(), "jobs"), i)
+		switch {
+		case j.Name == "":
+			errs = append(errs, l.errorf(node, "job %d has no name", i+1))
+		case names[j.Name]:
+			errs = append(errs, l.errorf(l.field(node, "name"), "job %q is defined more than once", j.Name))
+		}
+		names[j.Name] = true
+
+		if j.Schedule != "" {
+			if _, err := cron.ParseStandard(j.Schedule); err != nil {
+				errs = append(errs, l.errorf(l.field(node, "schedule"), "job %q has an invalid schedule %q: %v", j.Name, j.Schedule, err))
+			}
+		}
+		switch j.Source {
+		case "fip":
+			if !contains(stations, j.Station) {
+				errs = append(errs, l.errorf(l.field(node, "station"), "job %q has unknown station %q, valid stations are %v", j.Name, j.Station, stations))
+			}
+		case "file":
+			if j.File == "" {
+				errs = append(errs, l.errorf(node, "job %q reads from a file but has no file", j.Name))
+			}
+		default:
+			errs = append(errs, l.errorf(l.field(node, "source"), "job %q has unknown source %q, use fip or file", j.Name, j.Source))
+		}
+		switch {
+		case j.Window < 0 || j.Count < 0:
+			errs = append(errs, l.errorf(node, "job %q can't have a negative window or count", j.Name))
+		case j.Window == 0 && j.Count == 0:
+			errs = append(errs, l.errorf(node, "job %q needs either a window or a count", j.Name))
+		case j.Window > 0 && j.Count > 0:
+			errs = append(errs, l.errorf(l.field(node, "count"), "job %q can't have both a window and a count", j.Name))
+		}
+		filters := l.key(node, "filters")
+		if f := j.Filters; f.MinYear < 0 || f.MaxYear < 0 || f.MinDuration < 0 {
+			errs = append(errs, l.errorf(filters, "job %q can't have a negative year or duration filter", j.Name))
+		}
+		if f := j.Filters; f.MinYear > 0 && f.MaxYear > 0 && f.MinYear > f.MaxYear {
+			errs = append(errs, l.errorf(l.field(filters, "min_year"), "job %q has a min_year after its max_year", j.Name))
+		}
+		if j.Dedupe.Playlists < 0 || j.Dedupe.Days < 0 {
+			errs = append(errs, l.errorf(l.key(node, "dedupe"), "job %q can't dedupe against a negative number of playlists or days", j.Name))
+		}
+		if j.Favorites.MinPlays < 0 {
+			errs = append(errs, l.errorf(l.field(l.key(node, "favorites"), "min_plays"), "job %q can't have a negative favorites.min_plays", j.Name))
+		}
+		if _, err := template.New(j.Name).Parse(j.Playlist); err != nil {
+			errs = append(errs, l.errorf(l.field(node, "playlist"), "job %q has an invalid playlist template: %v", j.Name, err))
+		}
+	}
+	return errs
+}
+
+// envPrefix prefixes the environment variables overriding the config file.
+const envPrefix = "TIZINGER_"
+
+// applyEnv overrides the config file's values with environment variables,
+// e.g. TIZINGER_LOG_LEVEL=info for settings or TIZINGER_JOB_DAILY_STATION=
+// fipJazz for the "daily" job. Job names are upper-cased and their
+// characters other than letters and digits replaced with underscores. List
+// values are comma-separated. env is a list of "key=value" pairs as returned
+// by os.Environ.
+func (c *Config) applyEnv(env []string) (errs Errors) {
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		key, value := parts[0], parts[1]
+		if !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, envPrefix)
+
+		var err error
+		switch name {
+		case "LOG_LEVEL":
+			c.Settings.LogLevel = value
+		case "LOG_FORMAT":
+			c.Settings.LogFormat = value
+		case "STATE_FILE":
+			c.Settings.StateFile = value
+		case "CHECKPOINT_FILE":
+			c.Settings.CheckpointFile = value
+		case "OVERRIDES_FILE":
+			c.Settings.OverridesFile = value
+		case "ISRC_SOURCE":
+			c.Settings.ISRCSource = value
+		case "HTTP_TIMEOUT":
+			c.Settings.HTTPTimeout, err = time.ParseDuration(value)
+		case "METRICS_ADDRESS":
+			c.Settings.MetricsAddress = value
+		case "API_ADDRESS":
+			c.Settings.APIAddress = value
+		case "NOTIFY_ON":
+			c.Notify.On = value
+		case "NOTIFY_WEBHOOKS":
+			c.Notify.Webhooks = strings.Split(value, ",")
+		case "NOTIFY_EMAIL_SERVER":
+			c.Notify.Email.Server = value
+		case "NOTIFY_EMAIL_FROM":
+			c.Notify.Email.From = value
+		case "NOTIFY_EMAIL_TO":
+			c.Notify.Email.To = strings.Split(value, ",")
+		case "NOTIFY_EMAIL_ACCOUNT":
+			c.Notify.Email.Account = value
+		default:
+			if !strings.HasPrefix(name, "JOB_") {
+				continue
+			}
+			err = c.applyJobEnv(strings.TrimPrefix(name, "JOB_"), value)
+		}
+		if err != nil {
+			errs = append(errs, Error{Path: "environment variable " + key, Msg: err.Error()})
+		}
+	}
+	return errs
+}
+
+// nonAlphanumeric matches what job names can't have in environment variables.
+var nonAlphanumeric = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// applyJobEnv overrides the job setting name, which is the environment
+// variable's name without its prefix, e.g. DAILY_STATION.
+func (c *Config) applyJobEnv(name string, value string) (err error) {
+	for i := range c.Jobs {
+		j := &c.Jobs[i]
+		prefix := nonAlphanumeric.ReplaceAllString(strings.ToUpper(j.Name), "_") + "_"
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		switch strings.TrimPrefix(name, prefix) {
+		case "SCHEDULE":
+			j.Schedule = value
+		case "SOURCE":
+			j.Source = value
+		case "FILE":
+			j.File = value
+		case "STATION":
+			j.Station = value
+		case "WINDOW":
+			j.Window, err = time.ParseDuration(value)
+		case "COUNT":
+			j.Count, err = strconv.Atoi(value)
+		case "DESTINATIONS":
+			j.Destinations = strings.Split(value, ",")
+		case "ACCOUNTS":
+			j.Accounts = strings.Split(value, ",")
+		case "PLAYLIST":
+			j.Playlist = value
+		case "SKIP_EXISTING":
+			j.SkipExisting, err = strconv.ParseBool(value)
+		case "DISCOVERY":
+			j.Discovery, err = strconv.ParseBool(value)
+		default:
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("no job setting matches %q", name)
+}
+
+// PlaylistName renders the job's playlist naming template.
+func (j Job) PlaylistName(data NameData) (name string, err error) {
+	tmpl, err := template.New(j.Name).Parse(j.Playlist)
+	if err != nil {
+		return name, err
+	}
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, data)
+	if err != nil {
+		logger.Error("could not render playlist name", "job", j.Name, "err", err)
+		return name, err
+	}
+	return b.String(), err
+}
+
+// contains returns whether list has s.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
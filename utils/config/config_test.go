@@ -0,0 +1,154 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stations are the FIP stations jobs can get tracks from.
+var stations = []string{"fip", "fipElectro", "fipGroove", "fipJazz", "fipMonde", "fipPop", "fipReggae", "fipRock", "fipToutNouveau"}
+
+func TestLoad(t *testing.T) {
+	want := Config{
+		Settings: Settings{
+			LogLevel:       "info",
+			LogFormat:      "json",
+			StateFile:      "state.json",
+			CheckpointFile: "backfill.json",
+			OverridesFile:  "overrides.yaml",
+			HTTPTimeout:    30 * time.Second,
+			MetricsAddress: ":9090",
+			APIAddress:     "127.0.0.1:8080",
+		},
+		Notify: Notify{
+			On:       "failure",
+			Webhooks: []string{"https://hooks.example.org/mock"},
+			Email:    Email{Server: "smtp.example.org:587", From: "tizinger@example.org", To: []string{"me@example.org"}, Account: "notifications"},
+		},
+		Jobs: []Job{
+			{
+				Name:         "daily",
+				Schedule:     "0 6 * * *",
+				Source:       "fip",
+				Station:      "fip",
+				Window:       24 * time.Hour,
+				Destinations: []string{"tidal"},
+				Playlist:     `FIP {{.To.Format "2006-01-02"}}, {{.Count}} tracks`,
+			},
+			{
+				Name:         "rock-evenings",
+				Schedule:     "@hourly",
+				Source:       "fip",
+				Station:      "fipRock",
+				Count:        50,
+				Filters:      Filters{ExcludeArtists: []string{"Nickelback"}, ExcludeKinds: []string{"Musique symphonique"}, MinYear: 1970, MinDuration: time.Minute},
+				Dedupe:       Dedupe{Days: 7},
+				Favorites:    Favorites{Artists: true, MinPlays: 3},
+				Destinations: []string{"tidal"},
+				Playlist:     defaultPlaylist,
+			},
+		},
+	}
+
+	got, err := Load("../../fixtures/config/mock-config.yaml", stations)
+
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, want, got, "should return the settings and jobs with defaults applied")
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := "../../fixtures/config/invalid-config.yaml"
+	want := Errors{
+		{Path: path, Line: 2, Msg: `unknown log level "loud", valid levels are [trace info warning error]`},
+		{Path: path, Line: 15, Msg: `unknown notify.on "sometimes", use always or failure`},
+		{Path: path, Line: 16, Msg: `webhook 1 isn't an http or https URL`},
+		{Path: path, Line: 18, Msg: `email notifications need a server and a from address`},
+		{Path: path, Line: 5, Msg: `job "daily" has an invalid schedule "every day": expected exactly 5 fields, found 2: [every day]`},
+		{Path: path, Line: 7, Msg: `job "daily" is defined more than once`},
+		{Path: path, Line: 8, Msg: `job "daily" has unknown station "fipNope", valid stations are [fip fipElectro fipGroove fipJazz fipMonde fipPop fipReggae fipRock fipToutNouveau]`},
+		{Path: path, Line: 10, Msg: `job "daily" can't have both a window and a count`},
+		{Path: path, Line: 12, Msg: `job "daily" has a min_year after its max_year`},
+	}
+
+	_, err := Load(path, stations)
+
+	assert.Equal(t, want, err, "should report every problem with its line")
+}
+
+func TestPrepare(t *testing.T) {
+	job, err := Prepare(Job{Name: "api", Window: time.Hour}, stations)
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, "fip", job.Station, "should apply the defaults")
+	assert.Equal(t, []string{"tidal"}, job.Destinations, "should apply the defaults")
+
+	_, err = Prepare(Job{Name: "api", Station: "fipNope"}, stations)
+	assert.Equal(t, Errors{
+		{Path: "request", Msg: `job "api" has unknown station "fipNope", valid stations are [fip fipElectro fipGroove fipJazz fipMonde fipPop fipReggae fipRock fipToutNouveau]`},
+		{Path: "request", Msg: `job "api" needs either a window or a count`},
+	}, err, "should check the job")
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	f, err := ioutil.TempFile("", "tizinger-config-*.yaml")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("jobs:\n  - name: a\n    windw: 1h\n")
+	f.Close()
+
+	_, err = Load(f.Name(), stations)
+
+	assert.Error(t, err, "should error on unknown keys")
+	assert.Contains(t, err.Error(), "line 3", "should tell where the unknown key is")
+}
+
+func TestApplyEnv(t *testing.T) {
+	c := Config{Jobs: []Job{{Name: "daily"}, {Name: "rock-evenings"}}}
+	env := []string{
+		"HOME=/root",
+		"TIZINGER_LOG_LEVEL=error",
+		"TIZINGER_HTTP_TIMEOUT=1m",
+		"TIZINGER_JOB_DAILY_STATION=fipJazz",
+		"TIZINGER_JOB_ROCK_EVENINGS_COUNT=20",
+		"TIZINGER_JOB_ROCK_EVENINGS_DESTINATIONS=tidal,other",
+		"TIZINGER_JOB_ROCK_EVENINGS_ACCOUNTS=me,jazz-lovers",
+	}
+
+	errs := c.applyEnv(env)
+
+	assert.Empty(t, errs, "shouldn't have errored")
+	assert.Equal(t, "error", c.Settings.LogLevel, "should override settings")
+	assert.Equal(t, time.Minute, c.Settings.HTTPTimeout, "should parse durations")
+	assert.Equal(t, "fipJazz", c.Jobs[0].Station, "should override the job's settings")
+	assert.Equal(t, 20, c.Jobs[1].Count, "should parse numbers")
+	assert.Equal(t, []string{"tidal", "other"}, c.Jobs[1].Destinations, "should split lists")
+	assert.Equal(t, []string{"me", "jazz-lovers"}, c.Jobs[1].Accounts, "should split lists")
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	c := Config{Jobs: []Job{{Name: "daily"}}}
+	env := []string{"TIZINGER_JOB_DAILY_WINDOW=forever", "TIZINGER_JOB_NOPE_STATION=fip"}
+
+	errs := c.applyEnv(env)
+
+	assert.Equal(t, 2, len(errs), "should report every invalid variable")
+	assert.Contains(t, errs[0].Error(), "TIZINGER_JOB_DAILY_WINDOW", "should tell which variable is invalid")
+}
+
+func TestPlaylistName(t *testing.T) {
+	j := Job{Name: "daily", Playlist: defaultPlaylist}
+	data := NameData{
+		Station: "fipJazz",
+		From:    time.Date(2020, time.June, 22, 6, 0, 0, 0, time.UTC),
+		To:      time.Date(2020, time.June, 23, 6, 0, 0, 0, time.UTC),
+		Count:   312,
+	}
+
+	got, err := j.PlaylistName(data)
+
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, "fipJazz 2020-06-23, 312 tracks", got, "should render the template")
+}
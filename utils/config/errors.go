@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a problem with the configuration, along with where it comes from.
+type Error struct {
+	// Path is the config file, or the environment variable, the problem is
+	// in.
+	Path string
+	// Line is the line in the config file, 0 if unknown.
+	Line int
+	Msg  string
+}
+
+func (e Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// Errors are all the problems found with the configuration.
+type Errors []Error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// locator finds the YAML nodes values were decoded from, to tell where they
+// are in the file. Its methods return nil when there is no such node, so that
+// they can be chained.
+type locator struct {
+	path string
+	root *yaml.Node
+}
+
+func newLocator(path string, root *yaml.Node) locator {
+	return locator{path: path, root: root}
+}
+
+// doc returns the document's top-level node.
+func (l locator) doc() *yaml.Node {
+	if l.root == nil || len(l.root.Content) == 0 {
+		return nil
+	}
+	return l.root.Content[0]
+}
+
+// key returns the value for key in the mapping node.
+func (l locator) key(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// field returns the value for key in the mapping node, or the mapping node
+// itself if it doesn't have key, e.g. when the value is a default.
+func (l locator) field(node *yaml.Node, key string) *yaml.Node {
+	if value := l.key(node, key); value != nil {
+		return value
+	}
+	return node
+}
+
+// item returns the i-th element of the sequence node.
+func (l locator) item(node *yaml.Node, i int) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+		return nil
+	}
+	return node.Content[i]
+}
+
+// errorf returns an error located at node. A nil node, e.g. for values set
+// from the environment or defaults, has no line.
+func (l locator) errorf(node *yaml.Node, format string, args ...interface{}) Error {
+	e := Error{Path: l.path, Msg: fmt.Sprintf(format, args...)}
+	if node != nil {
+		e.Line = node.Line
+	}
+	return e
+}
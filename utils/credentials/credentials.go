@@ -2,30 +2,203 @@
 package credentials
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
 	"sync"
 
 	"github.com/coaxial/tizinger/utils/logger"
 	"gopkg.in/yaml.v3"
 )
 
-// credentialsYAML represents the credentials.yml file's YAML structure.
-type credentialsYAML struct {
-	Tidal []TidalAccount `yaml:"tidal,omitempty"`
+// Account is an account on a service, such as an exporter's destination. Each
+// service declares its own account type, which Decode fills in.
+type Account struct {
+	// Service is the service the account is for, e.g. "tidal".
+	Service string
+	// Name identifies the account within its service. It is the account's
+	// name, or its username or user if it doesn't have one.
+	Name string
+	Options
+	// node is the account's YAML definition, with its secrets resolved.
+	node *yaml.Node
+}
+
+// Options are per-account settings common to every service.
+type Options struct {
+	// Stations restricts the account to playlists of tracks from these FIP
+	// stations, every station if empty.
+	Stations []string `yaml:"stations,omitempty"`
+	// Jobs subscribes the account to the playlists of these jobs only,
+	// every job if empty.
+	Jobs []string `yaml:"jobs,omitempty"`
+	// Groups are the groups of accounts the account is in, for jobs to
+	// target several accounts at once.
+	Groups []string `yaml:"groups,omitempty"`
+	// Public makes the account's playlists public, if the service
+	// supports it.
+	Public bool `yaml:"public,omitempty"`
+}
+
+// Wants returns whether the account subscribes to the playlists job creates
+// from station's tracks.
+func (o Options) Wants(job string, station string) bool {
+	return (len(o.Jobs) == 0 || contains(o.Jobs, job)) &&
+		(len(o.Stations) == 0 || contains(o.Stations, station))
+}
+
+// Targeted returns whether the account named name is one of targets, either
+// by name or by being in one of the groups in targets. Every account is
+// targeted when targets is empty.
+func (o Options) Targeted(name string, targets []string) bool {
+	if len(targets) == 0 || contains(targets, name) {
+		return true
+	}
+	for _, g := range o.Groups {
+		if contains(targets, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns whether list has s.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode unmarshalls the account's definition into v, a pointer to the
+// service's account type.
+func (a Account) Decode(v interface{}) (err error) {
+	err = a.node.Decode(v)
+	if err != nil {
+		return fmt.Errorf("%s account %q: %v", a.Service, a.Name, err)
+	}
+	return err
 }
 
 // TidalAccount represents credentials for the Tidal streaming service.
 type TidalAccount struct {
+	// Name identifies the account, see Account.
+	Name     string `yaml:"name,omitempty"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password" secret:"true"`
+	Options  `yaml:",inline"`
+}
+
+// schemas are the account types of the services whose accounts can't have
+// other keys, by service, see Register. Other services' accounts can have any
+// keys.
+var schemas = map[string]reflect.Type{
+	"tidal": reflect.TypeOf(TidalAccount{}),
+}
+
+// Register declares v, a value of a service's account type, as the schema of
+// the service's accounts, so that keys it doesn't have, e.g. typos, are
+// rejected when loading or checking the credentials. Only the fields tagged
+// `secret:"true"` can be set with secret references, e.g. password_env. It is
+// meant to be called from the service's package init.
+func Register(service string, v interface{}) {
+	schemas[service] = reflect.TypeOf(v)
+}
+
+// keys returns the YAML keys of the struct type t, including those of its
+// inlined fields, and whether each holds a secret, as the fields tagged
+// `secret:"true"` do.
+func keys(t reflect.Type) (ks map[string]bool) {
+	ks = make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		if len(tag) > 1 && tag[1] == "inline" && f.Type.Kind() == reflect.Struct {
+			for k, secret := range keys(f.Type) {
+				ks[k] = secret
+			}
+			continue
+		}
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		ks[name] = f.Tag.Get("secret") == "true"
+	}
+	return ks
+}
+
+// isSecret returns whether the key of service's accounts holds a secret,
+// which can then be set with a secret reference instead. Without a schema,
+// the keys named like secrets, e.g. token or api_key, do.
+func isSecret(service string, key string) bool {
+	schema, ok := schemas[service]
+	if !ok {
+		return logger.IsSecret(key)
+	}
+	return keys(schema)[key]
+}
+
+// checkKeys returns an error for the first key of the account's mapping node
+// which neither the service's schema nor every account has, references to
+// the schema's secrets such as password_env counting as the secret's key.
+func checkKeys(service string, i int, node *yaml.Node) (err error) {
+	schema, ok := schemas[service]
+	if !ok {
+		return err
+	}
+	known := keys(schema)
+	known["name"] = false
+	for k, secret := range keys(reflect.TypeOf(Options{})) {
+		known[k] = secret
+	}
+	for j := 0; j+1 < len(node.Content); j += 2 {
+		key := node.Content[j]
+		name := key.Value
+		for _, s := range secretSuffixes {
+			if strings.HasSuffix(name, s) && known[strings.TrimSuffix(name, s)] {
+				name = strings.TrimSuffix(name, s)
+				break
+			}
+		}
+		if _, ok := known[name]; !ok {
+			return fmt.Errorf("line %d: %s account %d has unknown key %q", key.Line, service, i+1, key.Value)
+		}
+	}
+	return err
+}
+
+// accountYAML is what every account's YAML definition has in common.
+type accountYAML struct {
+	Name     string `yaml:"name"`
 	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	User     string `yaml:"user"`
+	Options  `yaml:",inline"`
 }
 
-// credentials holds the unmarshalled credentials.yml file contents.
-var accounts credentialsYAML
+// services holds the accounts from the credentials file, by service.
+var services map[string][]Account
+
+// loadErr is the error loading the credentials, if any.
+var loadErr error
 
 // credentialsFile is the path to the credentials.yml file.
 var credentialsFile = "credentials.yaml"
 
+// envPrefix prefixes the environment variables defining an account when there
+// is no credentials file.
+const envPrefix = "TIZINGER_TIDAL_"
+
 // once ensures the credentials file is loaded and parsed from disk
 // only once, to avoid reading and parsing it every time credentials are
 // requested.
@@ -33,22 +206,263 @@ var once sync.Once
 
 // loadConfig reads and unmarshalls the credentials file.
 func loadConfig() {
-	logger.Trace.Printf("reading credentials from %q", credentialsFile)
-	content, err := ioutil.ReadFile(credentialsFile)
+	services, loadErr = load(credentialsFile)
+}
+
+// load reads, decrypts if need be, and unmarshalls the credentials file at
+// path, and resolves the secrets. Without a credentials file, a single Tidal
+// account is read from the TIZINGER_TIDAL_USERNAME and TIZINGER_TIDAL_PASSWORD
+// (or TIZINGER_TIDAL_PASSWORD_FILE) environment variables.
+func load(path string) (accounts map[string][]Account, err error) {
+	logger.Trace("reading credentials", "path", path)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.Info("no credentials file, looking for "+envPrefix+"* environment variables", "path", path)
+		return fromEnv()
+	}
 	if err != nil {
-		logger.Error.Fatalf("could not read %q: %v", credentialsFile, err)
-		return
+		logger.Error("could not read credentials", "path", path, "err", err)
+		return accounts, err
+	}
+	if IsEncrypted(content) {
+		content, err = unlock(content)
+		if err != nil {
+			logger.Error("could not decrypt credentials", "path", path, "err", err)
+			return accounts, fmt.Errorf("could not decrypt %q: %v", path, err)
+		}
+	} else {
+		warnIfReadable(path)
+	}
+
+	accounts, err = parse(content)
+	if err != nil {
+		logger.Error("could not parse credentials", "path", path, "err", err)
+		return accounts, fmt.Errorf("could not parse %q: %v", path, err)
+	}
+	for service, list := range accounts {
+		for _, a := range list {
+			err = resolveSecrets(service, a.node)
+			if err != nil {
+				logger.Error("could not get the account's secrets", "service", service, "account", a.Name, "err", err)
+				return accounts, fmt.Errorf("%s account %q: %v", service, a.Name, err)
+			}
+		}
 	}
+	return accounts, err
+}
+
+// parse unmarshalls the credentials file's content: a list of accounts for
+// each service.
+func parse(content []byte) (accounts map[string][]Account, err error) {
+	var doc map[string][]yaml.Node
+	err = yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return accounts, err
+	}
+
+	accounts = make(map[string][]Account)
+	for service, nodes := range doc {
+		names := make(map[string]bool)
+		for i := range nodes {
+			node := &nodes[i]
+			if node.Kind != yaml.MappingNode {
+				return accounts, fmt.Errorf("line %d: %s account %d should be keys and values", node.Line, service, i+1)
+			}
+			err = checkKeys(service, i, node)
+			if err != nil {
+				return accounts, err
+			}
+			var common accountYAML
+			err = node.Decode(&common)
+			if err != nil {
+				return accounts, fmt.Errorf("%s account %d: %v", service, i+1, err)
+			}
+			a := Account{Service: service, Name: common.Name, Options: common.Options, node: node}
+			if a.Name == "" {
+				a.Name = common.Username
+			}
+			if a.Name == "" {
+				a.Name = common.User
+			}
+			switch {
+			case a.Name == "":
+				return accounts, fmt.Errorf("line %d: %s account %d has no name, username or user", node.Line, service, i+1)
+			case names[a.Name]:
+				return accounts, fmt.Errorf("line %d: %s account %q is defined more than once", node.Line, service, a.Name)
+			}
+			names[a.Name] = true
+			accounts[service] = append(accounts[service], a)
+		}
+	}
+	return accounts, err
+}
+
+// Check returns whether content is a valid, unencrypted, credentials file.
+func Check(content []byte) (err error) {
+	_, err = parse(content)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// unlock decrypts the encrypted credentials content with the passphrase from
+// the environment or the terminal.
+func unlock(content []byte) (plaintext []byte, err error) {
+	passphrase, err := Passphrase(false)
+	if err != nil {
+		return plaintext, err
+	}
+	return Decrypt(content, passphrase)
+}
+
+// fromEnv reads a single Tidal account from the environment.
+func fromEnv() (accounts map[string][]Account, err error) {
+	username := os.Getenv(envPrefix + "USERNAME")
+	if username == "" {
+		return accounts, errors.New("no credentials file and no " + envPrefix + "USERNAME environment variable")
+	}
+	password := os.Getenv(envPrefix + "PASSWORD")
+	if file := os.Getenv(envPrefix + "PASSWORD_FILE"); file != "" {
+		if password != "" {
+			return accounts, fmt.Errorf("both %sPASSWORD and %sPASSWORD_FILE are set", envPrefix, envPrefix)
+		}
+		password, err = secret("_file", file)
+		if err != nil {
+			logger.Error("could not get the account's password", "service", "tidal", "account", username, "err", err)
+			return accounts, fmt.Errorf("tidal account %q: %v", username, err)
+		}
+	}
+
+	var node yaml.Node
+	err = node.Encode(TidalAccount{Username: username, Password: password})
+	if err != nil {
+		return accounts, err
+	}
+	accounts = map[string][]Account{
+		"tidal": {{Service: "tidal", Name: username, node: &node}},
+	}
+	return accounts, err
+}
 
-	err = yaml.Unmarshal(content, &accounts)
+// secretSuffixes are the suffixes of the keys whose value refers to where the
+// secret for the key without the suffix is, e.g. password_file for password,
+// when that key holds a secret.
+var secretSuffixes = []string{"_env", "_file", "_command"}
+
+// resolveSecrets replaces the secret references in the mapping node of an
+// account of service with the secrets they refer to, e.g. `token_env: TOKEN`
+// with `token: <value of $TOKEN>`. Only the keys holding secrets, see
+// isSecret, are references, others such as `playlist_file` are left as they
+// are. Each secret can only be set in one way.
+func resolveSecrets(service string, node *yaml.Node) (err error) {
+	set := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name, suffix := key.Value, ""
+		for _, s := range secretSuffixes {
+			if strings.HasSuffix(name, s) && isSecret(service, strings.TrimSuffix(name, s)) {
+				name, suffix = strings.TrimSuffix(name, s), s
+				break
+			}
+		}
+		if set[name] {
+			return fmt.Errorf("line %d: %s is set more than once", key.Line, name)
+		}
+		set[name] = true
+		if suffix != "" {
+			s, err := secret(suffix, value.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %v", key.Line, key.Value, err)
+			}
+			key.Value = name
+			value.SetString(s)
+		}
+		// Keep the secrets out of the logs, whatever field they end
+		// up in.
+		if isSecret(service, name) || logger.IsSecret(name) {
+			logger.AddSecret(value.Value)
+		}
+	}
+	return err
+}
+
+// secret returns the secret ref refers to, as an environment variable, a file
+// or a command depending on suffix. Trailing newlines are trimmed from files
+// and command outputs.
+func secret(suffix string, ref string) (s string, err error) {
+	switch suffix {
+	case "_env":
+		s = os.Getenv(ref)
+		if s == "" {
+			err = fmt.Errorf("environment variable %s is empty", ref)
+		}
+	case "_file":
+		var content []byte
+		content, err = ioutil.ReadFile(ref)
+		s = strings.TrimRight(string(content), "\r\n")
+	case "_command":
+		var output []byte
+		output, err = exec.Command("sh", "-c", ref).Output()
+		if err != nil {
+			err = fmt.Errorf("command failed: %v", err)
+		}
+		s = strings.TrimRight(string(output), "\r\n")
+	}
+	return s, err
+}
+
+// warnIfReadable warns when the file at path can be read by users other than
+// its owner, since it may contain clear-text passwords.
+func warnIfReadable(path string) {
+	info, err := os.Stat(path)
 	if err != nil {
-		logger.Error.Fatalf("could not parse %q: %v", credentialsFile, err)
 		return
 	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		logger.Warning("credentials can be read by other users, run `chmod 600` on the file so that only you can read it", "path", path, "permissions", fmt.Sprintf("%#o", perm))
+	}
+}
+
+// Accounts returns the accounts set for service in credentials.yaml.
+func Accounts(service string) (accounts []Account, err error) {
+	once.Do(loadConfig)
+	return services[service], loadErr
+}
+
+// Lookup returns service's account named name.
+func Lookup(service string, name string) (account Account, err error) {
+	accounts, err := Accounts(service)
+	if err != nil {
+		return account, err
+	}
+	for _, a := range accounts {
+		if a.Name == name {
+			return a, err
+		}
+	}
+	return account, fmt.Errorf("no %s account named %q", service, name)
 }
 
 // Tidal exposes the tidal accounts credentials set in credentials.yaml.
 func Tidal() (tc []TidalAccount, err error) {
-	once.Do(loadConfig)
-	return accounts.Tidal, err
+	accounts, err := Accounts("tidal")
+	if err != nil {
+		return tc, err
+	}
+	return tidalAccounts(accounts)
+}
+
+// tidalAccounts decodes accounts as Tidal accounts.
+func tidalAccounts(accounts []Account) (tc []TidalAccount, err error) {
+	for _, a := range accounts {
+		var t TidalAccount
+		err = a.Decode(&t)
+		if err != nil {
+			return tc, err
+		}
+		t.Name = a.Name
+		tc = append(tc, t)
+	}
+	return tc, err
 }
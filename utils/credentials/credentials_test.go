@@ -1,13 +1,14 @@
 package credentials
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestTidal(t *testing.T) {
-	want := []TidalAccount{{Username: "mockuser@example.org", Password: "secret"}}
+	want := []TidalAccount{{Name: "mockuser@example.org", Username: "mockuser@example.org", Password: "secret"}}
 	credentialsFile = "../../fixtures/credentials/mock-credentials.yaml"
 	defer func() { credentialsFile = "credentials.yml" }()
 
@@ -16,3 +17,172 @@ func TestTidal(t *testing.T) {
 	assert.Nil(t, err, "shouldn't have errored")
 	assert.Equal(t, want, got, "should return the Tidal credentials")
 }
+
+func TestLoadSources(t *testing.T) {
+	os.Setenv("MOCK_TIDAL_PASSWORD", "from-env")
+	defer os.Unsetenv("MOCK_TIDAL_PASSWORD")
+	want := []TidalAccount{
+		{Name: "env@example.org", Username: "env@example.org", Password: "from-env"},
+		{Name: "file@example.org", Username: "file@example.org", Password: "from-file"},
+		{Name: "command@example.org", Username: "command@example.org", Password: "from-command"},
+	}
+
+	accounts, err := load("../../fixtures/credentials/mock-credentials-sources.yaml")
+	assert.Nil(t, err, "shouldn't have errored")
+	got, err := tidalAccounts(accounts["tidal"])
+
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, want, got, "should resolve the passwords from each source")
+}
+
+func TestLoadServices(t *testing.T) {
+	os.Setenv("MOCK_SERVICE_TOKEN", "mocktoken")
+	defer os.Unsetenv("MOCK_SERVICE_TOKEN")
+	type mockAccount struct {
+		URL   string `yaml:"url"`
+		User  string `yaml:"user"`
+		Token string `yaml:"token"`
+	}
+
+	accounts, err := load("../../fixtures/credentials/mock-credentials-services.yaml")
+	assert.Nil(t, err, "shouldn't have errored")
+
+	tidal, err := tidalAccounts(accounts["tidal"])
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, []TidalAccount{{
+		Name:     "mockuser@example.org",
+		Username: "mockuser@example.org",
+		Password: "secret",
+		Options:  Options{Stations: []string{"fipJazz", "fipRock"}, Public: true},
+	}}, tidal, "should decode the Tidal account with its options")
+
+	mock := accounts["mockservice"]
+	assert.Len(t, mock, 1, "should have the other service's account")
+	assert.Equal(t, "home", mock[0].Name, "should name the account")
+	var got mockAccount
+	err = mock[0].Decode(&got)
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, mockAccount{URL: "https://music.example.org", User: "mockuser", Token: "mocktoken"}, got, "should decode into the service's account type")
+}
+
+func TestLookup(t *testing.T) {
+	credentialsFile = "../../fixtures/credentials/mock-credentials.yaml"
+	defer func() { credentialsFile = "credentials.yml" }()
+
+	a, err := Lookup("tidal", "mockuser@example.org")
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, "mockuser@example.org", a.Name, "should find the account by name")
+
+	_, err = Lookup("tidal", "nobody@example.org")
+	assert.NotNil(t, err, "should error for an unknown account")
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	os.Setenv("TIZINGER_TIDAL_USERNAME", "envuser@example.org")
+	os.Setenv("TIZINGER_TIDAL_PASSWORD_FILE", "../../fixtures/credentials/mock-password")
+	defer os.Unsetenv("TIZINGER_TIDAL_USERNAME")
+	defer os.Unsetenv("TIZINGER_TIDAL_PASSWORD_FILE")
+	want := []TidalAccount{{Name: "envuser@example.org", Username: "envuser@example.org", Password: "from-file"}}
+
+	accounts, err := load("../../fixtures/credentials/nonexistent.yaml")
+	assert.Nil(t, err, "shouldn't have errored")
+	got, err := tidalAccounts(accounts["tidal"])
+
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, want, got, "should read the account from the environment")
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := load("../../fixtures/credentials/nonexistent.yaml")
+	assert.NotNil(t, err, "should error without a file nor environment variables")
+
+	_, err = parse([]byte("tidal:\n  - password: secret\n"))
+	assert.NotNil(t, err, "should error for an account without a name")
+
+	_, err = parse([]byte("tidal:\n  - username: u\n    pasword: secret\n"))
+	assert.NotNil(t, err, "should error for keys the service's accounts don't have")
+
+	_, err = parse([]byte("tidal:\n  - username: u\n    pasword_env: PASSWORD\n"))
+	assert.NotNil(t, err, "should error for secret references to keys the service's accounts don't have")
+
+	_, err = parse([]byte("tidal:\n  - username_env: USERNAME\n    password: secret\n"))
+	assert.NotNil(t, err, "should error for secret references to keys which aren't secrets")
+
+	_, err = parse([]byte("tidal:\n  - username: u\n  - username: u\n"))
+	assert.NotNil(t, err, "should error for accounts with the same name")
+
+	accounts, err := parse([]byte("tidal:\n  - username: u\n    password: secret\n    password_command: \"false\"\n"))
+	assert.Nil(t, err, "shouldn't have errored")
+	err = resolveSecrets("tidal", accounts["tidal"][0].node)
+	assert.NotNil(t, err, "should error when a secret is set more than once")
+
+	accounts, err = parse([]byte("tidal:\n  - username: u\n    password_command: \"false\"\n"))
+	assert.Nil(t, err, "shouldn't have errored")
+	err = resolveSecrets("tidal", accounts["tidal"][0].node)
+	assert.NotNil(t, err, "should error when the command fails")
+
+	accounts, err = parse([]byte("tidal:\n  - username: u\n    password_env: MOCK_UNSET_PASSWORD\n"))
+	assert.Nil(t, err, "shouldn't have errored")
+	err = resolveSecrets("tidal", accounts["tidal"][0].node)
+	assert.NotNil(t, err, "should error when the environment variable is empty")
+}
+
+func TestResolveSecretsOnlySecrets(t *testing.T) {
+	os.Setenv("MOCK_SERVICE_TOKEN", "mocktoken")
+	defer os.Unsetenv("MOCK_SERVICE_TOKEN")
+	type mockAccount struct {
+		User         string `yaml:"user"`
+		Token        string `yaml:"token"`
+		PlaylistFile string `yaml:"playlist_file"`
+	}
+	accounts, err := parse([]byte("mockservice:\n  - user: u\n    token_env: MOCK_SERVICE_TOKEN\n    playlist_file: /nonexistent/playlist.m3u\n"))
+	assert.Nil(t, err, "shouldn't have errored")
+
+	err = resolveSecrets("mockservice", accounts["mockservice"][0].node)
+	assert.Nil(t, err, "shouldn't have errored")
+	var got mockAccount
+	err = accounts["mockservice"][0].Decode(&got)
+	assert.Nil(t, err, "shouldn't have errored")
+
+	assert.Equal(t, mockAccount{User: "u", Token: "mocktoken", PlaylistFile: "/nonexistent/playlist.m3u"}, got, "should only resolve the references to secrets")
+}
+
+func TestWants(t *testing.T) {
+	tests := []struct {
+		options Options
+		job     string
+		station string
+		want    bool
+		msg     string
+	}{
+		{Options{}, "daily", "fip", true, "should want everything without jobs nor stations"},
+		{Options{Stations: []string{"fipJazz"}}, "daily", "fipJazz", true, "should want a listed station"},
+		{Options{Stations: []string{"fipJazz"}}, "daily", "fipRock", false, "shouldn't want other stations"},
+		{Options{Jobs: []string{"daily"}}, "daily", "fip", true, "should want a listed job"},
+		{Options{Jobs: []string{"daily"}}, "weekly", "fip", false, "shouldn't want other jobs"},
+		{Options{Jobs: []string{"daily"}, Stations: []string{"fipJazz"}}, "daily", "fip", false, "should want both the job and the station"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, test.options.Wants(test.job, test.station), test.msg)
+	}
+}
+
+func TestTargeted(t *testing.T) {
+	tests := []struct {
+		options Options
+		targets []string
+		want    bool
+		msg     string
+	}{
+		{Options{}, nil, true, "should target every account without targets"},
+		{Options{}, []string{"me"}, true, "should target the account by name"},
+		{Options{}, []string{"colleague"}, false, "shouldn't target other accounts"},
+		{Options{Groups: []string{"jazz"}}, []string{"jazz"}, true, "should target the account by group"},
+		{Options{Groups: []string{"jazz"}}, []string{"rock"}, false, "shouldn't target other groups"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, test.options.Targeted("me", test.targets), test.msg)
+	}
+}
@@ -0,0 +1,154 @@
+package credentials
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// header starts encrypted credentials files, followed by the base64 encoded
+// salt, nonce and ciphertext.
+const header = "# tizinger encrypted credentials v1\n"
+
+// PassphraseEnv is the environment variable holding the passphrase to the
+// encrypted credentials file. The passphrase is prompted for when it isn't
+// set.
+const PassphraseEnv = "TIZINGER_CREDENTIALS_PASSPHRASE"
+
+// scrypt parameters, as recommended for interactive logins in 2017.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	keyLen  = 32
+	saltLen = 16
+)
+
+// ErrWrongPassphrase is returned when decrypting with the wrong passphrase, or
+// when the encrypted file was tampered with.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted file")
+
+// IsEncrypted returns whether content is an encrypted credentials file.
+func IsEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(header))
+}
+
+// Encrypt encrypts plaintext with AES-GCM, using a key derived from passphrase
+// with scrypt.
+func Encrypt(plaintext []byte, passphrase string) (encrypted []byte, err error) {
+	salt := make([]byte, saltLen)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return encrypted, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return encrypted, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return encrypted, err
+	}
+
+	sealed := append(append(salt, nonce...), gcm.Seal(nil, nonce, plaintext, []byte(header))...)
+	encoded := base64.StdEncoding.EncodeToString(sealed)
+	var b bytes.Buffer
+	b.WriteString(header)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\n")
+	return b.Bytes(), err
+}
+
+// Decrypt decrypts content encrypted with Encrypt.
+func Decrypt(content []byte, passphrase string) (plaintext []byte, err error) {
+	if !IsEncrypted(content) {
+		return plaintext, errors.New("not an encrypted credentials file")
+	}
+	encoded := bytes.Join(bytes.Fields(content[len(header):]), nil)
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(sealed, encoded)
+	if err != nil {
+		return plaintext, fmt.Errorf("could not decode encrypted credentials: %v", err)
+	}
+	sealed = sealed[:n]
+
+	if len(sealed) < saltLen {
+		return plaintext, ErrWrongPassphrase
+	}
+	gcm, err := newGCM(passphrase, sealed[:saltLen])
+	if err != nil {
+		return plaintext, err
+	}
+	sealed = sealed[saltLen:]
+	if len(sealed) < gcm.NonceSize() {
+		return plaintext, ErrWrongPassphrase
+	}
+	plaintext, err = gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(header))
+	if err != nil {
+		return plaintext, ErrWrongPassphrase
+	}
+	return plaintext, err
+}
+
+// newGCM derives the key from passphrase and salt and returns the AES-GCM
+// cipher using it.
+func newGCM(passphrase string, salt []byte) (gcm cipher.AEAD, err error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keyLen)
+	if err != nil {
+		return gcm, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return gcm, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Passphrase returns the passphrase from the PassphraseEnv environment
+// variable, or prompts for it on the terminal. When confirm is set, the
+// passphrase is prompted for twice and both must match.
+func Passphrase(confirm bool) (passphrase string, err error) {
+	if passphrase = os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, err
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return passphrase, fmt.Errorf("the credentials are encrypted, set %s or run from a terminal to enter the passphrase", PassphraseEnv)
+	}
+
+	passphrase, err = prompt(fd, "Credentials passphrase: ")
+	if err != nil || !confirm {
+		return passphrase, err
+	}
+	if passphrase == "" {
+		return passphrase, errors.New("the passphrase can't be empty")
+	}
+	again, err := prompt(fd, "Confirm passphrase: ")
+	if err != nil {
+		return passphrase, err
+	}
+	if again != passphrase {
+		return "", errors.New("the passphrases don't match")
+	}
+	return passphrase, err
+}
+
+// prompt reads a line from the terminal fd without echoing it.
+func prompt(fd int, message string) (line string, err error) {
+	fmt.Fprint(os.Stderr, message)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(b), err
+}
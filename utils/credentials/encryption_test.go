@@ -0,0 +1,49 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	plaintext := []byte("tidal:\n  - username: mockuser@example.org\n    password: secret\n")
+
+	encrypted, err := Encrypt(plaintext, "passphrase")
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.True(t, IsEncrypted(encrypted), "should be recognized as encrypted")
+	assert.NotContains(t, string(encrypted), "secret", "shouldn't contain the plaintext")
+
+	got, err := Decrypt(encrypted, "passphrase")
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, plaintext, got, "should decrypt to the plaintext")
+
+	_, err = Decrypt(encrypted, "wrong")
+	assert.Equal(t, ErrWrongPassphrase, err, "should refuse the wrong passphrase")
+}
+
+func TestLoadEncrypted(t *testing.T) {
+	encrypted, err := Encrypt([]byte("tidal:\n  - username: mockuser@example.org\n    password: secret\n"), "passphrase")
+	assert.Nil(t, err, "shouldn't have errored")
+	path := filepath.Join(t.TempDir(), "credentials.yaml")
+	err = ioutil.WriteFile(path, encrypted, 0600)
+	assert.Nil(t, err, "shouldn't have errored")
+	os.Setenv(PassphraseEnv, "passphrase")
+	defer os.Unsetenv(PassphraseEnv)
+	want := []TidalAccount{{Name: "mockuser@example.org", Username: "mockuser@example.org", Password: "secret"}}
+
+	accounts, err := load(path)
+	assert.Nil(t, err, "shouldn't have errored")
+	got, err := tidalAccounts(accounts["tidal"])
+
+	assert.Nil(t, err, "shouldn't have errored")
+	assert.Equal(t, want, got, "should decrypt the credentials with the passphrase from the environment")
+}
+
+func TestCheck(t *testing.T) {
+	assert.Nil(t, Check([]byte("tidal:\n  - username: u\n    password: p\n")), "should accept valid credentials")
+	assert.NotNil(t, Check([]byte("tidal:\n  - usename: u\n")), "should refuse unknown keys")
+}
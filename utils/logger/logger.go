@@ -1,31 +1,277 @@
+// Package logger provides levelled, structured logging on top of log/slog.
+//
+// Messages are logged with key-value pairs of fields, e.g.
+//
+//	logger.Info("added track", "track", 42, "playlist", UUID)
+//
+// and loggers carrying fields for every message they log are made with With.
 package logger
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/bclicn/color"
+	"golang.org/x/term"
 )
 
+// LevelTrace is for verbose debug/troubleshooting information, below
+// slog.LevelDebug.
+const LevelTrace = slog.Level(-8)
+
+// Levels lists the log levels from the most to the least verbose.
+var Levels = []string{"trace", "info", "warning", "error"}
+
+// Formats lists the output formats.
+var Formats = []string{"text", "json"}
+
+// levels maps Levels to their slog levels.
+var levels = map[string]slog.Level{
+	"trace":   LevelTrace,
+	"info":    slog.LevelInfo,
+	"warning": slog.LevelWarn,
+	"error":   slog.LevelError,
+}
+
+// Logger logs messages along with its fields.
+type Logger struct {
+	handler slog.Handler
+}
+
 var (
-	// Trace logs verbose debug/troubleshooting information.
-	Trace *log.Logger
-	// Info logs information that helps understand what the code is doing.
-	Info *log.Logger
-	// Warning logs information worth noting, but that doesn't prevent code
-	// from running further.
-	Warning *log.Logger
-	// Error logs information about errors which prevent code from running
-	// further.
-	Error *log.Logger
+	// mu guards the output settings and root while they change.
+	mu     sync.RWMutex
+	output io.Writer = os.Stdout
+	format           = "text"
+	// level is the minimum level logged, shared by every Logger so that
+	// changing it applies to those already made.
+	level = new(slog.LevelVar)
+	// root is the handler of the package level functions. Loggers made with
+	// With keep the output and format set when they are made.
+	root slog.Handler
 )
 
 func init() {
-	flags := log.Ldate | log.LUTC | log.Lshortfile | log.Lmicroseconds | log.Lmsgprefix
+	level.Set(slog.LevelInfo)
+	root = newHandler(output, format)
+}
+
+// SetLevel logs only the messages at level or above, level being one of
+// Levels.
+func SetLevel(l string) (err error) {
+	lvl, ok := levels[l]
+	if !ok {
+		return fmt.Errorf("unknown log level %q, valid levels are %v", l, Levels)
+	}
+	level.Set(lvl)
+	return err
+}
+
+// SetFormat logs messages as "text" or "json", one message per line.
+func SetFormat(f string) (err error) {
+	if f != "text" && f != "json" {
+		return fmt.Errorf("unknown log format %q, valid formats are %v", f, Formats)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	format = f
+	root = newHandler(output, format)
+	return err
+}
+
+// SetOutput logs messages to w.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+	root = newHandler(output, format)
+}
+
+// newHandler returns the handler writing to w in format f. Text levels are
+// coloured when w is a terminal. Secrets are redacted from the messages and
+// fields, see redact.
+func newHandler(w io.Writer, f string) slog.Handler {
+	if file, ok := w.(*os.File); ok && f == "text" && term.IsTerminal(int(file.Fd())) {
+		w = colorWriter{w: w}
+	}
+	opts := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return redact(a)
+			}
+			switch a.Key {
+			case slog.TimeKey:
+				return a
+			case slog.MessageKey:
+				return slog.String(a.Key, scrub(a.Value.String()))
+			case slog.LevelKey:
+				return slog.String(slog.LevelKey, levelName(a.Value.Any().(slog.Level)))
+			case slog.SourceKey:
+				// The file and line are enough to find the call,
+				// the full path only makes lines longer.
+				if src, ok := a.Value.Any().(*slog.Source); ok {
+					return slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", shortFile(src.File), src.Line))
+				}
+				return a
+			}
+			return redact(a)
+		},
+	}
+	if f == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// levelName returns the name of the level l.
+func levelName(l slog.Level) string {
+	switch {
+	case l < slog.LevelDebug:
+		return "TRACE"
+	case l < slog.LevelWarn:
+		return "INFO"
+	case l < slog.LevelError:
+		return "WARNING"
+	default:
+		return "ERROR"
+	}
+}
+
+// paints maps the level names to their colour.
+var paints = map[string]func(string) string{
+	"TRACE":   color.BWhite,
+	"INFO":    color.BLightCyan,
+	"WARNING": color.BYellow,
+	"ERROR":   color.BRed,
+}
+
+// colorWriter colours the level of the text lines written to w. It colours
+// the output rather than the level attribute, which the text handler would
+// quote along with its escape codes.
+type colorWriter struct {
+	w io.Writer
+}
+
+// Write implements io.Writer, the text handler writing one line per call.
+func (cw colorWriter) Write(p []byte) (n int, err error) {
+	const key = "level="
+	i := bytes.Index(p, []byte(key))
+	if i < 0 || (i > 0 && p[i-1] != ' ') {
+		return cw.w.Write(p)
+	}
+	start := i + len(key)
+	end := start + bytes.IndexAny(p[start:], " \n")
+	if end < start {
+		return cw.w.Write(p)
+	}
+	paint, ok := paints[string(p[start:end])]
+	if !ok {
+		return cw.w.Write(p)
+	}
+	line := make([]byte, 0, len(p)+16)
+	line = append(line, p[:start]...)
+	line = append(line, paint(string(p[start:end]))...)
+	line = append(line, p[end:]...)
+	_, err = cw.w.Write(line)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// shortFile returns the file's name and its directory, e.g. tidal/client.go.
+func shortFile(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return path
+	}
+	if j := strings.LastIndex(path[:i], "/"); j >= 0 {
+		return path[j+1:]
+	}
+	return path
+}
+
+// With returns a logger adding the key-value pairs args to every message.
+func With(args ...interface{}) Logger {
+	return Logger{}.With(args...)
+}
+
+// With returns a logger adding the key-value pairs args to the logger's
+// fields.
+func (l Logger) With(args ...interface{}) Logger {
+	if l.handler == nil {
+		l.handler = rootHandler()
+	}
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return Logger{handler: l.handler.WithAttrs(attrs)}
+}
+
+// Trace logs verbose debug/troubleshooting information.
+func (l Logger) Trace(msg string, args ...interface{}) { l.log(LevelTrace, msg, args) }
+
+// Info logs information that helps understand what the code is doing.
+func (l Logger) Info(msg string, args ...interface{}) { l.log(slog.LevelInfo, msg, args) }
+
+// Warning logs information worth noting, but that doesn't prevent code from
+// running further.
+func (l Logger) Warning(msg string, args ...interface{}) { l.log(slog.LevelWarn, msg, args) }
+
+// Error logs information about errors which prevent code from running
+// further.
+func (l Logger) Error(msg string, args ...interface{}) { l.log(slog.LevelError, msg, args) }
+
+// Trace logs verbose debug/troubleshooting information.
+func Trace(msg string, args ...interface{}) { Logger{}.log(LevelTrace, msg, args) }
+
+// Info logs information that helps understand what the code is doing.
+func Info(msg string, args ...interface{}) { Logger{}.log(slog.LevelInfo, msg, args) }
+
+// Warning logs information worth noting, but that doesn't prevent code from
+// running further.
+func Warning(msg string, args ...interface{}) { Logger{}.log(slog.LevelWarn, msg, args) }
+
+// Error logs information about errors which prevent code from running
+// further.
+func Error(msg string, args ...interface{}) { Logger{}.log(slog.LevelError, msg, args) }
+
+// log logs msg and args at level lvl, with the location of the call to the
+// exported function or method.
+func (l Logger) log(lvl slog.Level, msg string, args []interface{}) {
+	h := l.handler
+	if h == nil {
+		h = rootHandler()
+	}
+	ctx := context.Background()
+	if !h.Enabled(ctx, lvl) {
+		return
+	}
+	var pcs [1]uintptr
+	// Skip runtime.Callers, log and the exported function.
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), lvl, msg, pcs[0])
+	r.Add(args...)
+	_ = h.Handle(ctx, r)
+}
 
-	Trace = log.New(os.Stdout, fmt.Sprintf("%-8s: ", color.BWhite("TRACE")), flags)
-	Info = log.New(os.Stdout, fmt.Sprintf("%-8s: ", color.BLightCyan("INFO")), flags)
-	Warning = log.New(os.Stdout, fmt.Sprintf("%-8s: ", color.BYellow("WARNING")), flags)
-	Error = log.New(os.Stdout, fmt.Sprintf("%-8s: ", color.BRed("ERROR")), flags)
+// rootHandler returns the handler of the package level functions.
+func rootHandler() slog.Handler {
+	mu.RLock()
+	defer mu.RUnlock()
+	return root
 }
@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bclicn/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSON(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+	err := SetFormat("json")
+	assert.Nil(t, err, "shouldn't have errored")
+	defer SetFormat("text")
+
+	With("station", "fipJazz").Info("received tracks", "tracks", 42)
+
+	var got map[string]interface{}
+	err = json.Unmarshal(out.Bytes(), &got)
+	assert.Nil(t, err, "should have logged JSON")
+	assert.Equal(t, "INFO", got["level"], "should have the level")
+	assert.Equal(t, "received tracks", got["msg"], "should have the message")
+	assert.Equal(t, "fipJazz", got["station"], "should have the logger's fields")
+	assert.Equal(t, float64(42), got["tracks"], "should have the message's fields")
+	assert.Equal(t, "logger/logger_test.go:22", got["source"], "should point to the call")
+}
+
+func TestText(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+
+	Warning("no matching track found", "title", "Scar tissue")
+
+	got := out.String()
+	assert.Contains(t, got, "level=WARNING", "should name the level without colours")
+	assert.Contains(t, got, `msg="no matching track found" title="Scar tissue"`, "should have the message and its fields")
+}
+
+func TestColor(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(colorWriter{w: &out})
+	defer SetOutput(os.Stdout)
+
+	Error("no matching track found", "title", "level=ERROR")
+
+	got := out.String()
+	assert.Contains(t, got, "level="+color.BRed("ERROR")+" ", "should colour the level as is")
+	assert.NotContains(t, got, `\x1b`, "should not escape the colours")
+	assert.Contains(t, got, `title="level=ERROR"`, "should only colour the level")
+}
+
+func TestSetLevel(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+	defer SetLevel("info")
+
+	err := SetLevel("warning")
+	assert.Nil(t, err, "shouldn't have errored")
+	Trace("hidden")
+	Info("hidden")
+	Warning("shown")
+	Error("shown")
+
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"), "should only log warnings and errors")
+	assert.NotContains(t, out.String(), "hidden", "shouldn't log below the level")
+
+	err = SetLevel("loud")
+	assert.NotNil(t, err, "should refuse unknown levels")
+}
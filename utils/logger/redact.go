@@ -0,0 +1,219 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in the logs.
+const Redacted = "REDACTED"
+
+// secretKeys are what the names of fields, form values, query parameters,
+// headers and JSON keys holding secrets contain, once lower-cased and without
+// dashes and underscores.
+var secretKeys = []string{"password", "passwd", "passphrase", "token", "secret", "sessionid", "authorization", "apikey", "cookie"}
+
+// identityKeys are the names of the fields identifying users, which are
+// partially masked.
+var identityKeys = []string{"username", "user", "login", "email", "account"}
+
+// IsSecret returns whether the field, form value, query parameter, header or
+// JSON key named key holds a secret.
+func IsSecret(key string) bool {
+	k := normalizeKey(key)
+	for _, s := range secretKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdentity returns whether the field named key identifies a user.
+func isIdentity(key string) bool {
+	k := normalizeKey(key)
+	for _, i := range identityKeys {
+		if k == i {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeKey lower-cases key and removes its dashes and underscores.
+func normalizeKey(key string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(key))
+}
+
+var (
+	// secretsMu guards secrets.
+	secretsMu sync.RWMutex
+	// secrets are the values scrubbed from everything logged, whatever
+	// field they are in.
+	secrets = map[string]bool{}
+)
+
+// minSecretLen is the length under which values aren't scrubbed, so that
+// short ones don't garble the logs.
+const minSecretLen = 4
+
+// AddSecret scrubs s from everything logged from now on, be it in a message,
+// a field or an error.
+func AddSecret(s string) {
+	if len(s) < minSecretLen {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets[s] = true
+}
+
+// scrub replaces the secrets added with AddSecret in s.
+func scrub(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+// mask partially hides the user identifier s, keeping its first character and
+// its domain if it is an email address, e.g. j***@example.org.
+func mask(s string) string {
+	if s == "" {
+		return s
+	}
+	domain := ""
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s, domain = s[:i], s[i:]
+	}
+	if s == "" {
+		return "***" + domain
+	}
+	return s[:1] + "***" + domain
+}
+
+// redact returns the field a without secrets. It is used as the handlers'
+// ReplaceAttr, so it applies to the message and every field, including those
+// added with With.
+func redact(a slog.Attr) slog.Attr {
+	if IsSecret(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	if a.Value.Kind() == slog.KindAny {
+		switch v := a.Value.Any().(type) {
+		case url.Values:
+			return slog.Attr{Key: a.Key, Value: valuesGroup(v)}
+		case http.Header:
+			return slog.Attr{Key: a.Key, Value: valuesGroup(url.Values(v))}
+		case *url.URL:
+			return slog.String(a.Key, redactURL(v))
+		case url.URL:
+			return slog.String(a.Key, redactURL(&v))
+		case error:
+			return slog.String(a.Key, scrub(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, scrub(v.String()))
+		}
+	}
+	if a.Value.Kind() != slog.KindString {
+		return a
+	}
+	s := scrub(a.Value.String())
+	if isIdentity(a.Key) {
+		s = mask(s)
+	}
+	return slog.String(a.Key, s)
+}
+
+// valuesGroup returns form values, query parameters or headers as a group of
+// fields, with their secrets redacted.
+func valuesGroup(values url.Values) slog.Value {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, redact(slog.String(k, strings.Join(values[k], ","))))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// redactURL returns u with the secrets in its query string and user info
+// redacted.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	r := *u
+	if r.User != nil {
+		r.User = url.User(mask(r.User.Username()))
+	}
+	q := r.Query()
+	for k, vs := range q {
+		for i := range vs {
+			switch {
+			case IsSecret(k):
+				vs[i] = Redacted
+			case isIdentity(k):
+				vs[i] = mask(vs[i])
+			}
+		}
+	}
+	r.RawQuery = q.Encode()
+	return scrub(r.String())
+}
+
+// JSON returns body, a JSON document such as a request's or a response's body,
+// ready to be logged with its secrets redacted. Bodies which aren't JSON are
+// logged as is, save for the secrets added with AddSecret.
+func JSON(body []byte) slog.LogValuer {
+	return jsonBody(body)
+}
+
+// jsonBody is a JSON document to log.
+type jsonBody []byte
+
+// LogValue implements slog.LogValuer.
+func (b jsonBody) LogValue() slog.Value {
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return slog.StringValue(scrub(string(b)))
+	}
+	redacted, err := json.Marshal(redactJSON(doc))
+	if err != nil {
+		return slog.StringValue(Redacted)
+	}
+	return slog.StringValue(scrub(string(redacted)))
+}
+
+// redactJSON redacts the secrets in the decoded JSON value v.
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			switch s, isString := e.(string); {
+			case IsSecret(k):
+				v[k] = Redacted
+			case isIdentity(k) && isString:
+				v[k] = mask(s)
+			default:
+				v[k] = redactJSON(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactJSON(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,108 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	AddSecret("registered-secret")
+	u, _ := url.Parse("https://api.example.org/v1/login?countryCode=FR&token=query-token&sessionId=query-session")
+
+	tests := []struct {
+		name   string
+		args   []interface{}
+		leaks  []string
+		reveal []string
+	}{
+		{
+			name:   "fields",
+			args:   []interface{}{"password", "field-password", "refresh_token", "field-refresh", "passphrase", "field-passphrase"},
+			leaks:  []string{"field-password", "field-refresh", "field-passphrase"},
+			reveal: []string{"password=REDACTED", "refresh_token=REDACTED"},
+		},
+		{
+			name:   "form",
+			args:   []interface{}{"form", url.Values{"username": {"mockuser@example.org"}, "password": {"form-password"}}},
+			leaks:  []string{"form-password", "mockuser@"},
+			reveal: []string{"form.password=REDACTED", "form.username=m***@example.org"},
+		},
+		{
+			name:   "query string",
+			args:   []interface{}{"url", u},
+			leaks:  []string{"query-token", "query-session"},
+			reveal: []string{"countryCode=FR", "token=REDACTED"},
+		},
+		{
+			name: "headers",
+			args: []interface{}{"headers", http.Header{
+				"X-Tidal-Sessionid": {"header-session"},
+				"Authorization":     {"Bearer header-token"},
+				"Content-Type":      {"application/json"},
+			}},
+			leaks:  []string{"header-session", "header-token"},
+			reveal: []string{"headers.X-Tidal-Sessionid=REDACTED", "headers.Content-Type=application/json"},
+		},
+		{
+			name:   "JSON",
+			args:   []interface{}{"body", JSON([]byte(`{"user":{"sessionId":"json-session","access_token":"json-access"},"items":[{"refreshToken":"json-refresh"}],"status":401}`))},
+			leaks:  []string{"json-session", "json-access", "json-refresh"},
+			reveal: []string{`\"status\":401`, `\"sessionId\":\"REDACTED\"`},
+		},
+		{
+			name:  "registered secrets",
+			args:  []interface{}{"err", errors.New("HTTP 401: registered-secret is invalid"), "detail", "registered-secret"},
+			leaks: []string{"registered-secret"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			SetOutput(&out)
+			defer SetOutput(os.Stdout)
+
+			With("token", "logger-token").Info("request registered-secret", tc.args...)
+
+			got := out.String()
+			for _, l := range append(tc.leaks, "logger-token") {
+				assert.NotContains(t, got, l, "shouldn't leak secrets")
+			}
+			for _, r := range tc.reveal {
+				assert.Contains(t, got, r, "should keep what isn't secret")
+			}
+		})
+	}
+}
+
+func TestRedactJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+	err := SetFormat("json")
+	assert.Nil(t, err, "shouldn't have errored")
+	defer SetFormat("text")
+
+	Info("logging in", "username", "mockuser@example.org", "form", url.Values{"password": {"json-format-password"}})
+
+	assert.NotContains(t, out.String(), "json-format-password", "shouldn't leak secrets")
+	assert.NotContains(t, out.String(), "mockuser@", "shouldn't leak usernames")
+	assert.Contains(t, out.String(), `"form":{"password":"REDACTED"}`, "should redact form values")
+}
+
+func TestMask(t *testing.T) {
+	tests := map[string]string{
+		"mockuser@example.org": "m***@example.org",
+		"home":                 "h***",
+		"@example.org":         "***@example.org",
+		"":                     "",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, mask(in), "should keep the first character and domain")
+	}
+}
@@ -0,0 +1,290 @@
+// Package metrics counts what Tizinger does, such as the tracks fetched and
+// the requests made, and exposes it in the Prometheus text format for
+// scraping, see Handler.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The metrics exposed by Tizinger.
+var (
+	TracksFetched = NewCounter("tizinger_tracks_fetched_total",
+		"Tracks fetched from the sources.", "station")
+	TracksSearched = NewCounter("tizinger_tracks_searched_total",
+		"Tracks looked up on the exporters, by whether they matched.", "exporter", "result")
+	HTTPRequests = NewCounter("tizinger_http_requests_total",
+		"HTTP requests made to the upstream APIs, by status code or error.", "upstream", "status")
+	HTTPDuration = NewHistogram("tizinger_http_request_duration_seconds",
+		"How long HTTP requests to the upstream APIs took.", DefBuckets, "upstream", "status")
+	JobRetries = NewCounter("tizinger_job_retries_total",
+		"Runs of a job's window which had failed before.", "job")
+	Playlists = NewCounter("tizinger_playlists_created_total",
+		"Playlists created on the exporters' accounts, by whether it succeeded, failed, or was skipped since the account already had it.", "exporter", "result")
+	LastSuccess = NewGauge("tizinger_job_last_success_timestamp_seconds",
+		"When each job last ran successfully, as a Unix epoch.", "job")
+)
+
+// DefBuckets are the upper bounds of the buckets for histograms of HTTP
+// request durations, in seconds.
+var DefBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// metric is a family of series, one per combination of label values.
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+	// buckets are the histograms' upper bounds, +Inf excluded.
+	buckets []float64
+}
+
+// series holds the values for one combination of label values.
+type series struct {
+	values []string
+	// value is the counter's or gauge's value, or the histogram's sum.
+	value float64
+	// counts are the histogram's cumulative counts per bucket, the last
+	// one being +Inf, i.e. the total count.
+	counts []uint64
+}
+
+var (
+	// registryMu guards registry.
+	registryMu sync.Mutex
+	// registry has every metric made, for Handler to expose.
+	registry = map[string]*metric{}
+)
+
+// register adds a new metric to the registry. It panics if a metric with the
+// same name was already registered, as that is a programming error.
+func register(name string, help string, kind string, labels []string) *metric {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("metric %q is already registered", name))
+	}
+	m := &metric{name: name, help: help, kind: kind, labels: labels, series: map[string]*series{}}
+	registry[name] = m
+	return m
+}
+
+// get returns the series for the label values, making it if need be. The
+// caller must hold m.mu. It panics if there aren't as many values as labels,
+// as that is a programming error.
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %q has labels %v, got values %v", m.name, m.labels, values))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets)+1)
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// find returns the series for the label values, or an empty one if there
+// isn't any. The caller must hold m.mu.
+func (m *metric) find(values []string) *series {
+	if s, ok := m.series[strings.Join(values, "\xff")]; ok {
+		return s
+	}
+	return &series{}
+}
+
+// Counter is a value which only goes up, e.g. a number of requests.
+type Counter struct{ m *metric }
+
+// NewCounter registers a counter with help describing it and the names of
+// its labels.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{register(name, help, "counter", labels)}
+}
+
+// Add adds v, which must not be negative, to the counter for the label
+// values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.get(values).value += v
+}
+
+// Inc adds one to the counter for the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Value returns the counter's value for the label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	return c.m.find(values).value
+}
+
+// Gauge is a value which can go up and down, e.g. a timestamp.
+type Gauge struct{ m *metric }
+
+// NewGauge registers a gauge with help describing it and the names of its
+// labels.
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{register(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge to v for the label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(values).value = v
+}
+
+// Value returns the gauge's value for the label values.
+func (g *Gauge) Value(values ...string) float64 {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	return g.m.find(values).value
+}
+
+// Histogram counts observations, e.g. durations, in buckets.
+type Histogram struct{ m *metric }
+
+// NewHistogram registers a histogram with help describing it, the buckets'
+// upper bounds in increasing order and the names of its labels.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	m := register(name, help, "histogram", labels)
+	m.buckets = buckets
+	return &Histogram{m}
+}
+
+// Observe counts v in the histogram for the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(values)
+	s.value += v
+	for i, upper := range h.m.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.counts[len(h.m.buckets)]++
+}
+
+// Count returns how many values were observed for the label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.find(values)
+	if s.counts == nil {
+		return 0
+	}
+	return s.counts[len(h.m.buckets)]
+}
+
+// Handler serves every metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// Write writes every metric to w in the Prometheus text format, sorted by
+// name and label values.
+func Write(w io.Writer) {
+	registryMu.Lock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryMu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		registryMu.Lock()
+		m := registry[name]
+		registryMu.Unlock()
+		m.write(w)
+	}
+}
+
+// write writes m's help, type and series to w.
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+	var keys []string
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelSet(s.values, ""), formatFloat(s.value))
+			continue
+		}
+		for i, upper := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelSet(s.values, formatFloat(upper)), s.counts[i])
+		}
+		count := s.counts[len(m.buckets)]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelSet(s.values, "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelSet(s.values, ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.labelSet(s.values, ""), count)
+	}
+}
+
+// labelSet formats the label values, along with the histogram bucket's upper
+// bound le if any, e.g. {upstream="fip",le="0.5"}.
+func (m *metric) labelSet(values []string, le string) string {
+	var pairs []string
+	for i, l := range m.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, escapeLabel(values[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabel escapes backslashes, double quotes and line feeds in label
+// values.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// escapeHelp escapes backslashes and line feeds in help texts.
+func escapeHelp(h string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(h)
+}
+
+// formatFloat formats v the way Prometheus does, e.g. +Inf or 0.5.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
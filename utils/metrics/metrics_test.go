@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_counter_total", "A counter.", "station")
+	c.Inc("fip")
+	c.Add(2, `fip"Rock`)
+	g := NewGauge("test_gauge", "A gauge.")
+	g.Set(1592978400)
+	h := NewHistogram("test_histogram_seconds", "A histogram.", []float64{.1, 1}, "upstream")
+	h.Observe(.05, "tidal")
+	h.Observe(.5, "tidal")
+	h.Observe(3, "tidal")
+
+	var out bytes.Buffer
+	Write(&out)
+	got := out.String()
+
+	for _, want := range []string{
+		"# HELP test_counter_total A counter.\n# TYPE test_counter_total counter\n",
+		`test_counter_total{station="fip"} 1` + "\n",
+		`test_counter_total{station="fip\"Rock"} 2` + "\n",
+		"# TYPE test_gauge gauge\ntest_gauge 1.5929784e+09\n",
+		`test_histogram_seconds_bucket{upstream="tidal",le="0.1"} 1` + "\n",
+		`test_histogram_seconds_bucket{upstream="tidal",le="1"} 2` + "\n",
+		`test_histogram_seconds_bucket{upstream="tidal",le="+Inf"} 3` + "\n",
+		`test_histogram_seconds_sum{upstream="tidal"} 3.55` + "\n",
+		`test_histogram_seconds_count{upstream="tidal"} 3` + "\n",
+	} {
+		assert.Contains(t, got, want, "should be in the Prometheus text format")
+	}
+	assert.True(t, strings.Index(got, "test_counter_total") < strings.Index(got, "test_gauge"), "should sort metrics by name")
+	assert.Equal(t, float64(0), c.Value("fipJazz"), "should be 0 for unseen label values")
+	assert.NotContains(t, got, "fipJazz", "shouldn't make series when reading them")
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport("test", nil)}
+
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err, "shouldn't have errored")
+	resp.Body.Close()
+	_, err = client.Get("http://127.0.0.1:0")
+	assert.NotNil(t, err, "should have failed to connect")
+
+	assert.Equal(t, float64(1), HTTPRequests.Value("test", "418"), "should count requests by status")
+	assert.Equal(t, float64(1), HTTPRequests.Value("test", "error"), "should count failed requests")
+	assert.Equal(t, uint64(1), HTTPDuration.Count("test", "418"), "should time requests")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `tizinger_http_requests_total{upstream="test",status="418"} 1`, "should serve the metrics")
+}
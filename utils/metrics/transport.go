@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// transport counts and times the requests made to an upstream API.
+type transport struct {
+	upstream string
+	next     http.RoundTripper
+}
+
+// Transport returns a RoundTripper making requests to upstream, e.g. "fip",
+// with next, or http.DefaultTransport if nil, and recording them in
+// HTTPRequests and HTTPDuration by status code, or "error" when there was no
+// response.
+func Transport(upstream string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return transport{upstream: upstream, next: next}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	start := time.Now()
+	resp, err = t.next.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	HTTPRequests.Inc(t.upstream, status)
+	HTTPDuration.Observe(time.Since(start).Seconds(), t.upstream, status)
+	return resp, err
+}
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 
 	"github.com/coaxial/tizinger/utils/logger"
 )
@@ -21,7 +22,8 @@ func LoadFixture(path string) (length int, content []byte) {
 	content, err := ioutil.ReadFile(path)
 	length = len(content)
 	if err != nil {
-		logger.Error.Fatalf("Could not load %q: %v", path, err)
+		logger.Error("could not load fixture", "path", path, "err", err)
+		os.Exit(1)
 	}
 
 	return length, content
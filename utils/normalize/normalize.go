@@ -0,0 +1,200 @@
+// Package normalize cleans up track titles and artists before searching for
+// them and comparing them: FIP's metadata has stray spaces, inconsistent
+// casing and accents, French featuring markers and remaster or live
+// suffixes, none of which help find the track.
+package normalize
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ligatures are the letters which don't decompose into a base letter and a
+// diacritic, spelled out in ASCII.
+var ligatures = strings.NewReplacer(
+	"œ", "oe", "Œ", "OE",
+	"æ", "ae", "Æ", "AE",
+	"ß", "ss",
+	"ø", "o", "Ø", "O",
+	"ł", "l", "Ł", "L",
+	"đ", "d", "Đ", "D",
+)
+
+// Fold lower-cases s and removes its diacritics, e.g. "Déshabillez-moi"
+// becomes "deshabillez-moi".
+func Fold(s string) string {
+	// Transformers keep state, so they can't be shared between goroutines.
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, ligatures.Replace(s))
+	if err != nil {
+		folded = s
+	}
+	return strings.ToLower(folded)
+}
+
+// typographic are punctuation marks with a plain ASCII equivalent.
+var typographic = strings.NewReplacer(
+	"‘", "'", "’", "'", "ʼ", "'", "`", "'", "´", "'",
+	"“", `"`, "”", `"`, "«", `"`, "»", `"`,
+	"‐", "-", "‑", "-", "–", "-", "—", "-",
+	"…", "...",
+)
+
+// Punctuation replaces the punctuation in s with spaces, and collapses the
+// spaces. Apostrophes are kept since they are part of words, e.g. "can't",
+// after turning typographic apostrophes into ASCII ones.
+func Punctuation(s string) string {
+	s = typographic.Replace(s)
+	s = strings.Map(func(r rune) rune {
+		if r == '\'' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Key is s folded with its punctuation normalized, to compare strings
+// regardless of how they are spelled, e.g. "Retiens l’été " becomes "retiens
+// l'ete".
+func Key(s string) string {
+	return Punctuation(Fold(s))
+}
+
+// parenthetical matches a part of a title between parentheses or brackets.
+var parenthetical = regexp.MustCompile(`\s*[(\[]([^)\]]*)[)\]]`)
+
+// dashSuffix matches a part of a title after a dash, e.g. "Song - Remastered
+// 2011".
+var dashSuffix = regexp.MustCompile(`\s+[-–—]\s+(.*)$`)
+
+// featuring matches what introduces featured artists.
+var featuring = regexp.MustCompile(`(?i)^\s*(?:feat\.?|ft\.?|featuring|avec)\s+`)
+
+// featuringInTitle matches featured artists written straight in a title, e.g.
+// "Song feat. Someone".
+var featuringInTitle = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring)\s+(.*)$`)
+
+// noise are the folded words marking a part of a title as describing the
+// recording rather than naming the track, e.g. "(Remastered 2011)" or
+// "(Live)".
+var noise = map[string]bool{
+	"remaster":    true,
+	"remastered":  true,
+	"remasterise": true,
+	"live":        true,
+	"version":     true,
+	"edit":        true,
+	"mono":        true,
+	"stereo":      true,
+	"bonus":       true,
+	"deluxe":      true,
+	"explicit":    true,
+	"single":      true,
+	"direct":      true,
+}
+
+// isNoise tells whether part of a title only describes the recording.
+func isNoise(part string) bool {
+	for _, w := range strings.Fields(Key(part)) {
+		if noise[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// Title strips the parts of title describing the recording, such as
+// "(Remastered 2011)", "(Live)" or "- Radio Edit", and returns the featured
+// artists it names separately, e.g. "Sambarilove (feat. Roubinho Jacobina)"
+// becomes "Sambarilove" featuring "Roubinho Jacobina". Other parts, such as
+// "(Flute mix)", are kept since they tell different tracks apart.
+func Title(title string) (stripped string, featured []string) {
+	stripped = parenthetical.ReplaceAllStringFunc(title, func(p string) string {
+		inner := parenthetical.FindStringSubmatch(p)[1]
+		if featuring.MatchString(inner) {
+			featured = append(featured, Artists(featuring.ReplaceAllString(inner, ""))...)
+			return ""
+		}
+		if isNoise(inner) {
+			return ""
+		}
+		return p
+	})
+	if m := dashSuffix.FindStringSubmatch(stripped); m != nil && isNoise(m[1]) {
+		stripped = strings.TrimSuffix(stripped, m[0])
+	}
+	if m := featuringInTitle.FindStringSubmatch(stripped); m != nil {
+		featured = append(featured, Artists(m[1])...)
+		stripped = strings.TrimSuffix(stripped, m[0])
+	}
+	return strings.TrimSpace(stripped), featured
+}
+
+// StripParentheticals removes every part of title between parentheses or
+// brackets, including those Title keeps, e.g. "Kalimba (Flute mix)" becomes
+// "Kalimba".
+func StripParentheticals(title string) string {
+	return strings.TrimSpace(parenthetical.ReplaceAllString(title, ""))
+}
+
+// artistSeparator matches what separates several artists credited together,
+// in English or French.
+var artistSeparator = regexp.MustCompile(`(?i)\s*(?:[,;&]|\s(?:feat\.?|ft\.?|featuring|et|and|avec)\s)\s*`)
+
+// Artists splits artist into the artists credited together, e.g. "Chiara
+// Civello feat. Roubinho Jacobina" or "Brigitte Fontaine et Areski" become
+// two artists. The main artist comes first.
+func Artists(artist string) (artists []string) {
+	for _, a := range artistSeparator.Split(artist, -1) {
+		a = strings.TrimSpace(a)
+		if a != "" {
+			artists = append(artists, a)
+		}
+	}
+	return artists
+}
+
+// featuringSeparator matches what introduces featured artists in a credit.
+var featuringSeparator = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring|avec)\s+`)
+
+// Featured splits artist on what introduces featured artists only, e.g.
+// "Chiara Civello feat. Roubinho Jacobina" becomes two artists, while
+// "Earth, Wind & Fire" or "Brigitte Fontaine et Areski" are kept whole since
+// they can't be told apart from band names. The main artist comes first.
+func Featured(artist string) (artists []string) {
+	for _, a := range featuringSeparator.Split(artist, -1) {
+		a = strings.TrimSpace(a)
+		if a != "" {
+			artists = append(artists, a)
+		}
+	}
+	return artists
+}
+
+// PrimaryArtist returns the main artist of those credited in artist, e.g.
+// "Chiara Civello" for "Chiara Civello feat. Roubinho Jacobina".
+func PrimaryArtist(artist string) string {
+	artists := Artists(artist)
+	if len(artists) == 0 {
+		return ""
+	}
+	return artists[0]
+}
+
+// Query returns what to search for to find the track title by artist: the
+// title without the parts describing the recording, followed by every
+// artist, folded and without punctuation.
+func Query(title string, artist string) string {
+	stripped, featured := Title(title)
+	words := []string{Key(stripped)}
+	for _, a := range append(Artists(artist), featured...) {
+		words = append(words, Key(a))
+	}
+	return strings.Join(strings.Fields(strings.Join(words, " ")), " ")
+}
@@ -0,0 +1,187 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		msg   string
+	}{
+		{"Scar tissue", "scar tissue", "should lower-case"},
+		{"Déshabillez-moi", "deshabillez-moi", "should remove acute accents"},
+		{"Retiens l'été", "retiens l'ete", "should remove accents on every letter"},
+		{"Ça plane pour moi", "ca plane pour moi", "should remove cedillas"},
+		{"Noël à la plage", "noel a la plage", "should remove diaereses and graves"},
+		{"Sigur Rós", "sigur ros", "should fold other languages"},
+		{"Mötley Crüe", "motley crue", "should remove umlauts"},
+		{"Cœur de pirate", "coeur de pirate", "should spell out ligatures"},
+		{"Ærøskøbing", "aeroskobing", "should spell out letters that don't decompose"},
+		{"Straße", "strasse", "should spell out the eszett"},
+		{"Keiko Mari", "keiko mari", "should leave plain letters alone"},
+		{"東京", "東京", "should leave scripts without diacritics alone"},
+		{"", "", "should handle empty strings"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Fold(tc.input), tc.msg)
+	}
+}
+
+func TestPunctuation(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		msg   string
+	}{
+		{"Pop / pop rock ", "Pop pop rock", "should trim and collapse spaces"},
+		{"I’m so happy I can’t stop crying", "I'm so happy I can't stop crying", "should use ASCII apostrophes"},
+		{"Un petit poisson, un petit oiseau", "Un petit poisson un petit oiseau", "should remove commas"},
+		{`Serenade ""une petite musique de nuit"" : I. Allegro`, "Serenade une petite musique de nuit I Allegro", "should remove quotes, colons and dots"},
+		{"Rock & roll", "Rock roll", "should remove ampersands"},
+		{"Hey – you", "Hey you", "should remove dashes"},
+		{"« Bonjour »", "Bonjour", "should remove French quotes"},
+		{"Wait…", "Wait", "should remove ellipses"},
+		{"1999", "1999", "should keep digits"},
+		{"  ", "", "should handle blank strings"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Punctuation(tc.input), tc.msg)
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		msg   string
+	}{
+		{"Retiens l’été ", "retiens l'ete", "should fold and normalize punctuation"},
+		{"SCAR TISSUE", "scar tissue", "should ignore case"},
+		{"Déshabillez-moi", "deshabillez moi", "should split hyphenated words"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Key(tc.input), tc.msg)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		input    string
+		want     string
+		featured []string
+		msg      string
+	}{
+		{"Scar tissue", "Scar tissue", nil, "should leave plain titles alone"},
+		{"Scar Tissue (Remastered 2011)", "Scar Tissue", nil, "should strip remasters"},
+		{"Scar Tissue (2011 Remaster)", "Scar Tissue", nil, "should strip remasters whatever the word order"},
+		{"Scar Tissue (Live)", "Scar Tissue", nil, "should strip live versions"},
+		{"Scar Tissue [Live at Slane Castle]", "Scar Tissue", nil, "should strip brackets"},
+		{"Scar Tissue (Radio Edit)", "Scar Tissue", nil, "should strip edits"},
+		{"Scar Tissue (Album Version)", "Scar Tissue", nil, "should strip versions"},
+		{"Scar Tissue (Mono)", "Scar Tissue", nil, "should strip mono mixes"},
+		{"Retiens l'été (remasterisé)", "Retiens l'été", nil, "should strip French remasters"},
+		{"Ma préférence (en direct)", "Ma préférence", nil, "should strip French live versions"},
+		{"Scar Tissue - Remastered 2011", "Scar Tissue", nil, "should strip suffixes after a dash"},
+		{"Scar Tissue - Live", "Scar Tissue", nil, "should strip live suffixes after a dash"},
+		{"Hey - You", "Hey - You", nil, "should keep dashes that are part of the title"},
+		{"Kalimba (Flute mix)", "Kalimba (Flute mix)", nil, "should keep parts telling tracks apart"},
+		{"Sambarilove (feat. Roubinho Jacobina)", "Sambarilove", []string{"Roubinho Jacobina"}, "should split featured artists"},
+		{"Sambarilove (ft. Roubinho Jacobina)", "Sambarilove", []string{"Roubinho Jacobina"}, "should split abbreviated featuring"},
+		{"Sambarilove [featuring Roubinho Jacobina & Someone]", "Sambarilove", []string{"Roubinho Jacobina", "Someone"}, "should split every featured artist"},
+		{"Sambarilove (avec Roubinho Jacobina)", "Sambarilove", []string{"Roubinho Jacobina"}, "should split French featuring"},
+		{"Sambarilove feat. Roubinho Jacobina", "Sambarilove", []string{"Roubinho Jacobina"}, "should split featuring outside of parentheses"},
+		{"Sambarilove (feat. Roubinho Jacobina) (Live)", "Sambarilove", []string{"Roubinho Jacobina"}, "should strip several parts"},
+		{"  Scar tissue ", "Scar tissue", nil, "should trim spaces"},
+	}
+	for _, tc := range tests {
+		got, featured := Title(tc.input)
+		assert.Equal(t, tc.want, got, tc.msg)
+		assert.Equal(t, tc.featured, featured, tc.msg)
+	}
+}
+
+func TestStripParentheticals(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		msg   string
+	}{
+		{"Kalimba (Flute mix)", "Kalimba", "should strip parts Title keeps"},
+		{"Scar Tissue [Live] (Remastered)", "Scar Tissue", "should strip every part"},
+		{"Scar tissue", "Scar tissue", "should leave plain titles alone"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, StripParentheticals(tc.input), tc.msg)
+	}
+}
+
+func TestArtists(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+		msg   string
+	}{
+		{"Red Hot Chili Peppers", []string{"Red Hot Chili Peppers"}, "should leave single artists alone"},
+		{"Chiara Civello feat. Roubinho Jacobina", []string{"Chiara Civello", "Roubinho Jacobina"}, "should split featured artists"},
+		{"Chiara Civello Feat Roubinho Jacobina", []string{"Chiara Civello", "Roubinho Jacobina"}, "should ignore case and dots"},
+		{"Chiara Civello ft. Roubinho Jacobina", []string{"Chiara Civello", "Roubinho Jacobina"}, "should split abbreviated featuring"},
+		{"Chiara Civello featuring Roubinho Jacobina", []string{"Chiara Civello", "Roubinho Jacobina"}, "should split featuring"},
+		{"Brigitte Fontaine et Areski", []string{"Brigitte Fontaine", "Areski"}, "should split French conjunctions"},
+		{"Brigitte Fontaine avec Areski", []string{"Brigitte Fontaine", "Areski"}, "should split French featuring"},
+		{"Simon & Garfunkel", []string{"Simon", "Garfunkel"}, "should split ampersands"},
+		{"Simon and Garfunkel", []string{"Simon", "Garfunkel"}, "should split English conjunctions"},
+		{"Crosby, Stills, Nash & Young", []string{"Crosby", "Stills", "Nash", "Young"}, "should split lists"},
+		{"AC/DC", []string{"AC/DC"}, "should keep slashes"},
+		{"Daft Punk", []string{"Daft Punk"}, "should only split whole words"},
+		{"Etienne Daho", []string{"Etienne Daho"}, "should not split names starting like conjunctions"},
+		{"", nil, "should handle empty strings"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Artists(tc.input), tc.msg)
+	}
+}
+
+func TestFeatured(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+		msg   string
+	}{
+		{"Chiara Civello feat. Roubinho Jacobina", []string{"Chiara Civello", "Roubinho Jacobina"}, "should split featured artists"},
+		{"Brigitte Fontaine avec Areski", []string{"Brigitte Fontaine", "Areski"}, "should split French featuring"},
+		{"Earth, Wind & Fire", []string{"Earth, Wind & Fire"}, "should keep band names whole"},
+		{"Mumford & Sons ft. Baaba Maal", []string{"Mumford & Sons", "Baaba Maal"}, "should only split featuring"},
+		{"", nil, "should handle empty strings"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Featured(tc.input), tc.msg)
+	}
+}
+
+func TestPrimaryArtist(t *testing.T) {
+	assert.Equal(t, "Chiara Civello", PrimaryArtist("Chiara Civello feat. Roubinho Jacobina"), "should return the main artist")
+	assert.Equal(t, "Red Hot Chili Peppers", PrimaryArtist("Red Hot Chili Peppers"), "should return single artists")
+	assert.Equal(t, "", PrimaryArtist(" "), "should handle blank artists")
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		title  string
+		artist string
+		want   string
+		msg    string
+	}{
+		{"Scar tissue", "Red Hot Chili Peppers", "scar tissue red hot chili peppers", "should search for the title and artist"},
+		{"Scar Tissue (Remastered 2011) ", "Red Hot Chili Peppers ", "scar tissue red hot chili peppers", "should strip the recording's description"},
+		{"Déshabillez-moi", "Juliette Gréco", "deshabillez moi juliette greco", "should fold the query"},
+		{"Sambarilove (feat. Roubinho Jacobina)", "Chiara Civello", "sambarilove chiara civello roubinho jacobina", "should search for the featured artists"},
+		{"Un homme heureux", "Brigitte Fontaine et Areski", "un homme heureux brigitte fontaine areski", "should leave out conjunctions"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Query(tc.title, tc.artist), tc.msg)
+	}
+}
@@ -0,0 +1,252 @@
+// Package overrides keeps the user's corrections to track matches in a small
+// YAML file, so that tracks which never match correctly are exported as the
+// user decided rather than searched for.
+package overrides
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/coaxial/tizinger/utils/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// Override decides what a source track matches on Tidal.
+type Override struct {
+	// Title and Artist identify the source track, ignoring case and
+	// surrounding spaces, unless UUID is set.
+	Title  string `yaml:"title,omitempty" json:"title,omitempty"`
+	Artist string `yaml:"artist,omitempty" json:"artist,omitempty"`
+	// UUID identifies the source track by FIP's uuid instead, for when the
+	// title and artist are too ambiguous.
+	UUID string `yaml:"uuid,omitempty" json:"uuid,omitempty"`
+	// TidalID is the Tidal track the source track matches.
+	TidalID int `yaml:"tidal_id,omitempty" json:"tidal_id,omitempty"`
+	// Skip leaves the source track out of the playlists instead.
+	Skip bool `yaml:"skip,omitempty" json:"skip,omitempty"`
+}
+
+// Check returns what is wrong with the override, if anything.
+func (o Override) Check() (err error) {
+	switch {
+	case strings.TrimSpace(o.Title) == "" && strings.TrimSpace(o.UUID) == "":
+		return fmt.Errorf("override has neither a title nor a uuid")
+	case o.Skip && o.TidalID != 0:
+		return fmt.Errorf("override for %s can't both skip the track and match it", o.track())
+	case !o.Skip && o.TidalID <= 0:
+		return fmt.Errorf("override for %s needs either a Tidal ID or skip", o.track())
+	}
+	return err
+}
+
+// key identifies the source track the override is for.
+func (o Override) key() string {
+	if strings.TrimSpace(o.UUID) != "" {
+		return "uuid\x00" + normalize(o.UUID)
+	}
+	return normalize(o.Title) + "\x00" + normalize(o.Artist)
+}
+
+// track describes the source track the override is for.
+func (o Override) track() string {
+	if strings.TrimSpace(o.UUID) != "" {
+		return "uuid " + o.UUID
+	}
+	return fmt.Sprintf("%q by %q", o.Title, o.Artist)
+}
+
+// String describes the override, e.g. for listing them.
+func (o Override) String() string {
+	if o.Skip {
+		return o.track() + ": skip"
+	}
+	return fmt.Sprintf("%s: Tidal track %d", o.track(), o.TidalID)
+}
+
+// normalize lower-cases s and trims its surrounding spaces.
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// overridesYAML represents the overrides file's YAML structure.
+type overridesYAML struct {
+	Overrides []Override `yaml:"overrides"`
+}
+
+// Store gives concurrency-safe access to an overrides file. Every change is
+// written to disk right away, in the order the overrides were added. The file
+// is read again whenever it changed, e.g. when the overrides command edits it
+// while serve runs, so that neither loses the other's changes.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	list []Override
+	// info describes the file as last read or written, nil if it didn't
+	// exist.
+	info os.FileInfo
+}
+
+// Open loads the overrides file at path. A missing file is not an error, it
+// is created upon the first change.
+func Open(path string) (s *Store, err error) {
+	s = &Store{path: path}
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+	if s.info == nil {
+		logger.Info("no overrides file yet", "path", path)
+		return s, nil
+	}
+	logger.Info("loaded overrides", "overrides", len(s.list), "path", path)
+	return s, nil
+}
+
+// load reads the overrides file. A missing file has no overrides. The caller
+// must hold s.mu, unless s isn't shared yet.
+func (s *Store) load() (err error) {
+	logger.Trace("reading overrides", "path", s.path)
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.list, s.info = nil, nil
+		return nil
+	}
+	if err != nil {
+		logger.Error("could not read overrides", "path", s.path, "err", err)
+		return err
+	}
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		logger.Error("could not read overrides", "path", s.path, "err", err)
+		return err
+	}
+
+	var doc overridesYAML
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	err = dec.Decode(&doc)
+	if err != nil && err != io.EOF {
+		logger.Error("could not parse overrides", "path", s.path, "err", err)
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+	for _, o := range doc.Overrides {
+		err = o.Check()
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.path, err)
+		}
+	}
+	s.list, s.info = doc.Overrides, info
+	return nil
+}
+
+// reload reads the overrides file again if it changed since it was last read
+// or written. The caller must hold s.mu.
+func (s *Store) reload() (err error) {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) && s.info == nil {
+		return nil
+	}
+	if err == nil && s.info != nil && os.SameFile(info, s.info) && info.ModTime().Equal(s.info.ModTime()) && info.Size() == s.info.Size() {
+		return nil
+	}
+	logger.Info("overrides file changed, reloading it", "path", s.path)
+	return s.load()
+}
+
+// Lookup returns the override for the source track t, by its UUID first and
+// then by its title and artist. ok is false if there is none.
+func (s *Store) Lookup(t extractor.Track) (o Override, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.reload()
+	if err != nil {
+		logger.Warning("could not reload overrides, using those already loaded", "path", s.path, "err", err)
+	}
+	var keys []string
+	if strings.TrimSpace(t.UUID) != "" {
+		keys = append(keys, Override{UUID: t.UUID}.key())
+	}
+	keys = append(keys, Override{Title: t.Title, Artist: t.Artist}.key())
+	for _, key := range keys {
+		for _, o := range s.list {
+			if o.key() == key {
+				return o, true
+			}
+		}
+	}
+	return o, false
+}
+
+// Set records o, replacing the override for the same source track if any.
+func (s *Store) Set(o Override) (err error) {
+	err = o.Check()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Merge with the latest changes rather than overwrite them.
+	err = s.reload()
+	if err != nil {
+		return err
+	}
+	for i, existing := range s.list {
+		if existing.key() == o.key() {
+			s.list[i] = o
+			return s.save()
+		}
+	}
+	s.list = append(s.list, o)
+	return s.save()
+}
+
+// List returns the overrides, in the order they were added.
+func (s *Store) List() (list []Override) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.reload()
+	if err != nil {
+		logger.Warning("could not reload overrides, using those already loaded", "path", s.path, "err", err)
+	}
+	return append(list, s.list...)
+}
+
+// save writes the overrides to a temporary file first and then moves it in
+// place, so that a crash mid-write can't leave a truncated file. The caller
+// must hold s.mu.
+func (s *Store) save() (err error) {
+	content, err := yaml.Marshal(overridesYAML{Overrides: s.list})
+	if err != nil {
+		logger.Error("could not encode overrides", "err", err)
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		logger.Error("could not create temporary overrides file", "err", err)
+		return err
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Error("could not write temporary overrides file", "err", err)
+		return err
+	}
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Error("could not save overrides", "path", s.path, "err", err)
+		return err
+	}
+	s.info, err = os.Stat(s.path)
+	return err
+}
@@ -0,0 +1,95 @@
+package overrides
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coaxial/tizinger/extractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-overrides")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "overrides.yaml")
+
+	s, err := Open(path)
+	assert.Nil(t, err, "should not error on a missing file")
+	_, ok := s.Lookup(extractor.Track{Title: "Sous le vent", Artist: "Garou"})
+	assert.False(t, ok, "should not have an override yet")
+	assert.Nil(t, s.Set(Override{Title: "Sous le vent", Artist: "Garou", TidalID: 42}), "should not have errored")
+	assert.Nil(t, s.Set(Override{Title: "Jingle", Artist: "FIP", Skip: true}), "should not have errored")
+	assert.Nil(t, s.Set(Override{Title: "SOUS LE VENT", Artist: "garou ", TidalID: 1337}), "should not have errored")
+
+	reopened, err := Open(path)
+	assert.Nil(t, err, "should not have errored")
+	got, ok := reopened.Lookup(extractor.Track{Title: "sous le vent", Artist: "Garou"})
+
+	assert.True(t, ok, "should have found the override ignoring case")
+	assert.Equal(t, 1337, got.TidalID, "should have replaced the override for the same track")
+	assert.Len(t, reopened.List(), 2, "should have persisted both overrides")
+	assert.Equal(t, 1337, reopened.List()[0].TidalID, "should keep the order the overrides were added in")
+}
+
+func TestSetInvalid(t *testing.T) {
+	s := &Store{path: filepath.Join(os.TempDir(), "never-written.yaml")}
+	tests := map[string]Override{
+		"no track":          {Artist: "Garou", TidalID: 42},
+		"no decision":       {Title: "Sous le vent", Artist: "Garou"},
+		"both decisions":    {Title: "Sous le vent", Artist: "Garou", TidalID: 42, Skip: true},
+		"negative tidal ID": {Title: "Sous le vent", Artist: "Garou", TidalID: -1},
+	}
+	for name, o := range tests {
+		assert.Error(t, s.Set(o), "should refuse the override: "+name)
+	}
+	assert.Empty(t, s.List(), "should not have kept invalid overrides")
+}
+
+func TestOpenInvalid(t *testing.T) {
+	s, err := Open("../../fixtures/overrides/invalid-overrides.yaml")
+
+	assert.Nil(t, s, "should not return a store")
+	assert.Error(t, err, "should error on invalid overrides")
+}
+
+func TestLookupUUID(t *testing.T) {
+	s := &Store{list: []Override{
+		{Title: "Sous le vent", Artist: "Garou", TidalID: 42},
+		{UUID: "31883778-0704-4914-8d14-2df7af12814b", Skip: true},
+	}}
+
+	byUUID, ok := s.Lookup(extractor.Track{Title: "Sous le vent", Artist: "Garou", UUID: "31883778-0704-4914-8d14-2df7af12814b"})
+	assert.True(t, ok, "should have found the override")
+	assert.True(t, byUUID.Skip, "should prefer the override for the uuid")
+
+	byTitle, ok := s.Lookup(extractor.Track{Title: "Sous le vent", Artist: "Garou", UUID: "0863cd87-f4c8-4728-8e55-6b182a26de27"})
+	assert.True(t, ok, "should have found the override")
+	assert.Equal(t, 42, byTitle.TidalID, "should fall back to the title and artist")
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-overrides")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "overrides.yaml")
+	daemon, err := Open(path)
+	assert.Nil(t, err)
+	_, ok := daemon.Lookup(extractor.Track{Title: "Sous le vent", Artist: "Garou"})
+	assert.False(t, ok, "should not have an override yet")
+
+	cli, err := Open(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cli.Set(Override{Title: "Sous le vent", Artist: "Garou", TidalID: 42}))
+	got, ok := daemon.Lookup(extractor.Track{Title: "Sous le vent", Artist: "Garou"})
+	assert.True(t, ok, "should see the overrides saved by others")
+	assert.Equal(t, 42, got.TidalID, "should see the overrides saved by others")
+
+	assert.Nil(t, daemon.Set(Override{Title: "Jingle", Artist: "FIP", Skip: true}))
+	assert.Nil(t, cli.Set(Override{Title: "Otherside", Artist: "RHCP", TidalID: 1337}))
+	reopened, err := Open(path)
+	assert.Nil(t, err)
+	assert.Len(t, reopened.List(), 3, "should have merged the overrides saved by every store")
+}
@@ -0,0 +1,227 @@
+// Package state persists what was already done between runs in a small JSON
+// file, so that work can be resumed or skipped after a restart.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coaxial/tizinger/utils/logger"
+)
+
+// stateJSON represents the state file's JSON structure.
+type stateJSON struct {
+	// LastRuns maps job names to the end of the last window they
+	// successfully processed, as a Unix epoch in seconds.
+	LastRuns map[string]int64 `json:"last_runs"`
+	// Failed maps job names to the end of the window they failed to
+	// process since their last success, as a Unix epoch in seconds.
+	Failed map[string]int64 `json:"failed,omitempty"`
+	// Done maps the keys of completed tasks to when they were completed,
+	// as a Unix epoch in seconds.
+	Done map[string]int64 `json:"done,omitempty"`
+	// Runs maps run keys to the progress of exporting a playlist.
+	Runs map[string]Run `json:"runs,omitempty"`
+}
+
+// Run records the progress of exporting a job's playlist to an account.
+type Run struct {
+	Job      string `json:"job"`
+	Account  string `json:"account"`
+	Playlist string `json:"playlist"`
+	// From and To are the Unix epochs in seconds of the window the
+	// playlist's tracks aired in.
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+	// PlaylistUUID is the playlist created for the run, empty until then.
+	PlaylistUUID string `json:"playlist_uuid,omitempty"`
+	// TracksAdded are the IDs of the tracks already added to the playlist.
+	TracksAdded []int `json:"tracks_added,omitempty"`
+	// Done is true once every track was added.
+	Done bool `json:"done"`
+}
+
+// runKey identifies the run for a job's playlist on an account, over a
+// window: playlists named the same for different windows are different runs.
+func runKey(r Run) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%d\x00%d", r.Job, r.Account, r.Playlist, r.From, r.To)
+}
+
+// Store gives concurrency-safe access to a state file. Every change is
+// written to disk right away.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	data stateJSON
+}
+
+// Open loads the state file at path. A missing file is not an error, it is
+// created upon the first change.
+func Open(path string) (s *Store, err error) {
+	s = &Store{path: path}
+	logger.Trace("reading state", "path", path)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.Info("no state file yet, starting afresh", "path", path)
+		content, err = []byte("{}"), nil
+	}
+	if err != nil {
+		logger.Error("could not read state", "path", path, "err", err)
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, &s.data)
+	if err != nil {
+		logger.Error("could not parse state", "path", path, "err", err)
+		return nil, err
+	}
+	if s.data.LastRuns == nil {
+		s.data.LastRuns = make(map[string]int64)
+	}
+	if s.data.Failed == nil {
+		s.data.Failed = make(map[string]int64)
+	}
+	if s.data.Done == nil {
+		s.data.Done = make(map[string]int64)
+	}
+	// Runs used to be keyed without their window, so they are keyed again
+	// from their fields.
+	runs := make(map[string]Run)
+	for _, r := range s.data.Runs {
+		runs[runKey(r)] = r
+	}
+	s.data.Runs = runs
+	return s, err
+}
+
+// LastRun returns the end of the last window job successfully processed. ok
+// is false if there is none.
+func (s *Store) LastRun(job string) (t time.Time, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, ok := s.data.LastRuns[job]
+	if !ok {
+		return t, ok
+	}
+	return time.Unix(ts, 0), ok
+}
+
+// SetLastRun records t as the end of the last window job successfully
+// processed, clearing its failure if any.
+func (s *Store) SetLastRun(job string, t time.Time) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.LastRuns[job] = t.Unix()
+	delete(s.data.Failed, job)
+	return s.save()
+}
+
+// Failed returns the end of the window job failed to process since its last
+// success. ok is false if there is none.
+func (s *Store) Failed(job string) (t time.Time, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, ok := s.data.Failed[job]
+	if !ok {
+		return t, ok
+	}
+	return time.Unix(ts, 0), ok
+}
+
+// SetFailed records t as the end of the window job failed to process.
+func (s *Store) SetFailed(job string, t time.Time) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Failed[job] = t.Unix()
+	return s.save()
+}
+
+// IsDone returns whether the task identified by key was marked as done.
+func (s *Store) IsDone(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.data.Done[key]
+	return ok
+}
+
+// MarkDone records the task identified by key as done.
+func (s *Store) MarkDone(key string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Done[key] = time.Now().Unix()
+	return s.save()
+}
+
+// Run returns the recorded run with the same job, account, playlist and
+// window as key. ok is false if there is none.
+func (s *Store) Run(key Run) (r Run, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok = s.data.Runs[runKey(key)]
+	return r, ok
+}
+
+// Runs returns the recorded runs of job on account, the most recent window
+// first.
+func (s *Store) Runs(job string, account string) (runs []Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.data.Runs {
+		if r.Job == job && r.Account == account {
+			runs = append(runs, r)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].From != runs[j].From {
+			return runs[i].From > runs[j].From
+		}
+		return runs[i].Playlist < runs[j].Playlist
+	})
+	return runs
+}
+
+// SaveRun records r, replacing the previous record for the same job, account,
+// playlist and window.
+func (s *Store) SaveRun(r Run) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Runs[runKey(r)] = r
+	return s.save()
+}
+
+// save writes the state to a temporary file first and then moves it in
+// place, so that a crash mid-write can't leave a truncated state file. The
+// caller must hold s.mu.
+func (s *Store) save() (err error) {
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		logger.Error("could not encode state", "err", err)
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		logger.Error("could not create temporary state file", "err", err)
+		return err
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Error("could not write temporary state file", "err", err)
+		return err
+	}
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Error("could not save state", "path", s.path, "err", err)
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	want := time.Date(2020, time.June, 23, 6, 0, 0, 0, time.UTC)
+
+	s, err := Open(path)
+	assert.Nil(t, err, "should not error on a missing file")
+	_, ok := s.LastRun("daily")
+	assert.False(t, ok, "should not have a last run yet")
+	err = s.SetLastRun("daily", want)
+	assert.Nil(t, err, "should not have errored")
+
+	reopened, err := Open(path)
+	assert.Nil(t, err, "should not have errored")
+	got, ok := reopened.LastRun("daily")
+
+	assert.True(t, ok, "should have a last run")
+	assert.True(t, want.Equal(got), "should have persisted the last run")
+}
+
+func TestFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	failed := time.Date(2020, time.June, 23, 6, 0, 0, 0, time.UTC)
+
+	s, err := Open(path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.SetFailed("daily", failed), "should not have errored")
+	reopened, err := Open(path)
+	assert.Nil(t, err)
+	got, ok := reopened.Failed("daily")
+	assert.True(t, ok, "should have persisted the failure")
+	assert.True(t, failed.Equal(got), "should have persisted the failed window")
+
+	assert.Nil(t, reopened.SetLastRun("daily", failed))
+	_, ok = reopened.Failed("daily")
+	assert.False(t, ok, "should clear the failure upon success")
+}
+
+func TestOpenInvalid(t *testing.T) {
+	s, err := Open("../../fixtures/config/mock-config.yaml")
+
+	assert.Nil(t, s, "should not return a store")
+	assert.Error(t, err, "should error on a file that isn't JSON")
+}
+
+func TestDone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	s, err := Open(path)
+	assert.Nil(t, err)
+	assert.False(t, s.IsDone("fip/2020-06-01"), "should not be done yet")
+	err = s.MarkDone("fip/2020-06-01")
+	assert.Nil(t, err, "should not have errored")
+
+	reopened, err := Open(path)
+	assert.Nil(t, err)
+	assert.True(t, reopened.IsDone("fip/2020-06-01"), "should have persisted the task as done")
+	assert.False(t, reopened.IsDone("fip/2020-06-02"), "should only mark that task as done")
+}
+
+func TestRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	s, err := Open(filepath.Join(dir, "state.json"))
+	assert.Nil(t, err)
+	older := Run{Job: "daily", Account: "mockuser@example.org", Playlist: "FIP 2020-06-22", From: 1592784000, To: 1592870400}
+	newer := Run{Job: "daily", Account: "mockuser@example.org", Playlist: "FIP 2020-06-23", From: 1592870400, To: 1592956800}
+	other := Run{Job: "daily", Account: "otheruser@example.org", Playlist: "FIP 2020-06-23", From: 1592870400, To: 1592956800}
+	for _, r := range []Run{older, other, newer} {
+		assert.Nil(t, s.SaveRun(r))
+	}
+
+	assert.Equal(t, []Run{newer, older}, s.Runs("daily", "mockuser@example.org"), "should list the account's runs, most recent first")
+	assert.Empty(t, s.Runs("weekly", "mockuser@example.org"), "should not list other jobs' runs")
+}
+
+func TestOpenRekeysRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	old := `{"last_runs": {}, "runs": {"daily\u0000mockuser@example.org\u0000FIP": {"job": "daily", "account": "mockuser@example.org", "playlist": "FIP", "from": 1592805600, "to": 1592892000, "done": true}}}`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(old), 0600))
+
+	s, err := Open(path)
+	assert.Nil(t, err)
+	got, ok := s.Run(Run{Job: "daily", Account: "mockuser@example.org", Playlist: "FIP", From: 1592805600, To: 1592892000})
+
+	assert.True(t, ok, "should find the runs recorded by older versions")
+	assert.True(t, got.Done, "should have kept the run's progress")
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tizinger-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	want := Run{
+		Job:          "daily",
+		Account:      "mockuser@example.org",
+		Playlist:     "FIP 2020-06-23",
+		From:         1592805600,
+		To:           1592892000,
+		PlaylistUUID: "mock-playlist-uuid",
+		TracksAdded:  []int{42, 1337},
+	}
+
+	s, err := Open(path)
+	assert.Nil(t, err)
+	_, ok := s.Run(want)
+	assert.False(t, ok, "should not have a run yet")
+	err = s.SaveRun(want)
+	assert.Nil(t, err, "should not have errored")
+
+	reopened, err := Open(path)
+	assert.Nil(t, err)
+	got, ok := reopened.Run(Run{Job: "daily", Account: "mockuser@example.org", Playlist: "FIP 2020-06-23", From: 1592805600, To: 1592892000})
+	assert.True(t, ok, "should have found the run")
+	assert.Equal(t, want, got, "should have persisted the run")
+	_, ok = reopened.Run(Run{Job: "daily", Account: "otheruser@example.org", Playlist: "FIP 2020-06-23", From: 1592805600, To: 1592892000})
+	assert.False(t, ok, "should keep runs separate per account")
+	_, ok = reopened.Run(Run{Job: "daily", Account: "mockuser@example.org", Playlist: "FIP 2020-06-23", From: 1592892000, To: 1592978400})
+	assert.False(t, ok, "should keep runs separate per window, even when their playlists are named the same")
+}